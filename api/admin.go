@@ -0,0 +1,68 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	restful "github.com/emicklei/go-restful/v3"
+)
+
+// CacheInvalidator is implemented by authenticators caching results.
+type CacheInvalidator interface {
+	// Invalidate drops the cached results of the user.
+	Invalidate(user string)
+	// InvalidateAll drops every cached result.
+	InvalidateAll()
+}
+
+func (api *API) registerAdmin(ws *restful.WebService) {
+	ws.
+		Route(ws.DELETE("/admin/cache").
+			To(api.invalidateCache).
+			Filter(api.requireAdmin).
+			Doc("Drop every cached authentication result (requires the admin token)").
+			Param(restful.HeaderParameter("Authorization", "Bearer <admin token>")))
+
+	ws.
+		Route(ws.DELETE("/admin/cache/{user}").
+			To(api.invalidateCachedUser).
+			Filter(api.requireAdmin).
+			Doc("Drop the cached authentication results of a user (requires the admin token)").
+			Param(restful.HeaderParameter("Authorization", "Bearer <admin token>")).
+			Param(ws.PathParameter("user", "the user name")))
+
+	api.registerLockouts(ws)
+	api.registerAPIKeyUsage(ws)
+}
+
+// requireAdmin checks the admin token. Admin routes are disabled without one.
+func (api *API) requireAdmin(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	if api.AdminToken == "" {
+		resp.WriteErrorString(http.StatusNotFound, "Administration is not enabled.\n")
+		return
+	}
+
+	expected := []byte("Bearer " + api.AdminToken)
+	if subtle.ConstantTimeCompare([]byte(req.HeaderParameter("Authorization")), expected) != 1 {
+		resp.WriteErrorString(http.StatusUnauthorized, "Unauthorized.\n")
+		return
+	}
+
+	chain.ProcessFilter(req, resp)
+}
+
+func (api *API) invalidateCache(request *restful.Request, response *restful.Response) {
+	if cache, ok := api.Authenticator.(CacheInvalidator); ok {
+		cache.InvalidateAll()
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) invalidateCachedUser(request *restful.Request, response *restful.Response) {
+	if cache, ok := api.Authenticator.(CacheInvalidator); ok {
+		cache.Invalidate(request.PathParameter("user"))
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
@@ -1,31 +1,178 @@
 package api
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/emicklei/go-restful/v3"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/isi-nc/autentigo/pkg/apikey"
+	"github.com/isi-nc/autentigo/pkg/certmap"
+	"github.com/isi-nc/autentigo/pkg/claimmap"
+	"github.com/isi-nc/autentigo/pkg/oauthclient"
+	"github.com/isi-nc/autentigo/pkg/store"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
 )
 
 var (
 	// ErrInvalidAuthentication indicates an invalid authentication
 	ErrInvalidAuthentication = errors.New("invalid authentication")
+	// ErrUnknownUser indicates an invalid authentication because the backend doesn't know the user.
+	// It is an ErrInvalidAuthentication (see errors.Is).
+	ErrUnknownUser = fmt.Errorf("%w: unknown user", ErrInvalidAuthentication)
+	// ErrOTPRequired indicates a valid password of a user with a second factor, given without one-time password.
+	// It is an ErrInvalidAuthentication (see errors.Is).
+	ErrOTPRequired = fmt.Errorf("%w: one-time password required", ErrInvalidAuthentication)
 )
 
-// Authenticator is the interface for authn backends
+// Authenticator is the interface for authn backends. Backends must give up
+// when the context is done (the client went away, or the server is stopping).
 type Authenticator interface {
+	Authenticate(ctx context.Context, user, password string, expiresAt time.Time, req RequestInfo) (claims jwt.Claims, err error)
+}
+
+// RequestInfo describes the request an authentication comes from, for backends applying policies.
+type RequestInfo struct {
+	// RemoteAddr is the client's address (host:port), as seen by the server.
+	RemoteAddr string
+	// ClientIP is the client's IP, behind trusted proxies (see API.ClientIP).
+	ClientIP string
+	// UserAgent is the client's User-Agent header.
+	UserAgent string
+	// Endpoint is the endpoint used: basic, simple, keystone, introspect, webauthn, token or mtls.
+	Endpoint string
+	// Audience is the audience requested for the token, if any.
+	Audience string
+	// Domain is the domain given in a keystone request, if any.
+	Domain string
+	// OTP is the one-time password given with the password, if any (see CheckOTP).
+	OTP string
+}
+
+// GroupsLookup is implemented by authenticators able to give the groups of a user
+// without authenticating them. It returns ErrUnknownUser for unknown users.
+type GroupsLookup interface {
+	LookupGroups(ctx context.Context, user string) ([]string, error)
+}
+
+// WebAuthnLookup is implemented by authenticators storing WebAuthn credentials.
+// It gives the user's credentials and claims without authenticating them: the
+// caller must verify an assertion. It returns ErrUnknownUser for unknown users.
+type WebAuthnLookup interface {
+	LookupWebAuthn(ctx context.Context, user string, expiresAt time.Time) ([]webauthn.Credential, jwt.Claims, error)
+}
+
+// APIKeyLookup is implemented by authenticators storing API keys. It gives the
+// user's keys and claims without authenticating them: the caller must check the
+// key. It returns ErrUnknownUser for unknown users.
+type APIKeyLookup interface {
+	LookupAPIKeys(ctx context.Context, user string, expiresAt time.Time) ([]apikey.Key, jwt.Claims, error)
+}
+
+// ClientRegistry gives the registered OAuth2 clients. It returns
+// oauthclient.ErrUnknownClient for unknown clients.
+type ClientRegistry interface {
+	LookupClient(ctx context.Context, id string) (*oauthclient.Client, error)
+}
+
+// LegacyAuthenticator is the authenticator interface without context nor request details.
+type LegacyAuthenticator interface {
 	Authenticate(user, password string, expiresAt time.Time) (claims jwt.Claims, err error)
 }
 
+// FromLegacy adapts a legacy authenticator. It can't be interrupted, but the
+// request is answered as soon as the context is done.
+func FromLegacy(a LegacyAuthenticator) Authenticator {
+	return legacyAuthenticator{a}
+}
+
+type legacyAuthenticator struct {
+	legacy LegacyAuthenticator
+}
+
+type legacyResult struct {
+	claims jwt.Claims
+	err    error
+}
+
+func (a legacyAuthenticator) Authenticate(ctx context.Context, user, password string, expiresAt time.Time, _ RequestInfo) (jwt.Claims, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make(chan legacyResult, 1)
+	go func() {
+		claims, err := a.legacy.Authenticate(user, password, expiresAt)
+		result <- legacyResult{claims, err}
+	}()
+
+	select {
+	case r := <-result:
+		return r.claims, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 // API registering with restful
 type API struct {
-	CRTData       []byte
 	Authenticator Authenticator
-	PublicKey     interface{}
-	PrivateKey    interface{}
-	SigningMethod jwt.SigningMethod
+	Keys          *KeyRing
 	TokenDuration time.Duration
+
+	// Signer signs tokens (nil means the active key of Keys). Its keys must be in Keys for verification.
+	Signer Signer
+
+	// ClaimMapping maps the backend's claims to the token's claims (nil keeps them as they are).
+	ClaimMapping *claimmap.Config
+
+	// Issuer is the issuer URL stamped in tokens (iss claim), if any.
+	Issuer string
+
+	// X5CHeader enables the x5c header in tokens signed by keys given with certificates.
+	X5CHeader bool
+
+	// Store holds the server's state (refresh tokens, revocations...).
+	Store store.Store
+
+	// RefreshTokenDuration is the lifetime of refresh tokens (0 disables them).
+	RefreshTokenDuration time.Duration
+
+	// ExchangeTokenDuration is the maximum lifetime of tokens obtained by token exchange.
+	ExchangeTokenDuration time.Duration
+
+	// Lockout slows down repeated authentication failures (state in Store).
+	Lockout LockoutPolicy
+
+	// ClientIP finds the IP of a request's client, for the IP lockout (nil
+	// uses the remote address, like requests without trusted proxies).
+	ClientIP func(r *http.Request) string
+
+	// AdminToken protects the administration routes (disabled if empty).
+	AdminToken string
+
+	// WebAuthn enables passkey logins (nil disables them), with credentials of
+	// authenticators implementing WebAuthnLookup.
+	WebAuthn *webauthn.Config
+
+	// WebAuthnMaxPending caps the passkey logins a client IP can start during
+	// the challenge timeout, so /webauthn/options can't fill the store with
+	// challenges (0 disables the cap).
+	WebAuthnMaxPending int
+
+	// ClientCertificates maps the client certificates verified by the TLS
+	// listener to tokens (nil disables the mtls route).
+	ClientCertificates *certmap.Config
+
+	// Clients is the registry of the client credentials grant and the
+	// authorization code flow (nil disables them).
+	Clients ClientRegistry
+
+	// IntrospectionClients authenticates the clients of the introspection endpoint (nil disables it).
+	IntrospectionClients Authenticator
 }
 
 // Register provide a restful.WebService from this API
@@ -36,5 +183,15 @@ func (api *API) Register() *restful.WebService {
 	api.registerKeystone(ws)
 	api.registerK8sAuthenticator(ws)
 	api.registerCertificate(ws)
+	api.registerJWKS(ws)
+	api.registerDiscovery(ws)
+	api.registerRefresh(ws)
+	api.registerRevoke(ws)
+	api.registerIntrospection(ws)
+	api.registerToken(ws)
+	api.registerAuthorize(ws)
+	api.registerWebAuthn(ws)
+	api.registerMTLS(ws)
+	api.registerAdmin(ws)
 	return ws
 }
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/isi-nc/autentigo/pkg/apikey"
+	"github.com/isi-nc/autentigo/pkg/store"
+)
+
+// APIKeyUsage gives when API keys were last used.
+type APIKeyUsage struct {
+	// LastUsed maps the IDs of the keys used since the store was created to their last use.
+	LastUsed map[string]time.Time `json:"last_used"`
+}
+
+func (api *API) registerAPIKeyUsage(ws *restful.WebService) {
+	ws.
+		Route(ws.GET("/admin/api-keys").
+			To(api.apiKeyUsage).
+			Filter(api.requireAdmin).
+			Doc("Give when API keys were last used (requires the admin token)").
+			Param(restful.HeaderParameter("Authorization", "Bearer <admin token>")).
+			Param(ws.QueryParameter("id", "the key IDs (repeated)")).
+			Produces("application/json").
+			Writes(APIKeyUsage{}))
+}
+
+// authenticateAPIKey checks an API key given as password, and records its use.
+func (api *API) authenticateAPIKey(ctx context.Context, lookup APIKeyLookup, user, key string, expiresAt time.Time) (jwt.Claims, error) {
+	keyUser, _, ok := apikey.Parse(key)
+	if !ok || keyUser != user {
+		return nil, fmt.Errorf("%w: API key of another user", ErrInvalidAuthentication)
+	}
+
+	keys, claims, err := lookup.LookupAPIKeys(ctx, user, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	k := apikey.Find(keys, key, time.Now())
+	if k == nil {
+		return nil, fmt.Errorf("%w: unknown or expired API key", ErrInvalidAuthentication)
+	}
+
+	if api.Store != nil {
+		now := time.Now().UTC().Format(time.RFC3339)
+		if err := api.Store.Put(ctx, "apikeys/used/"+k.ID, []byte(now), 0); err != nil {
+			log.Print("failed to record the use of an API key: ", err)
+		}
+	}
+
+	return claims, nil
+}
+
+func (api *API) apiKeyUsage(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			WriteError(err.(error), response)
+		}
+	}()
+
+	usage := APIKeyUsage{LastUsed: map[string]time.Time{}}
+
+	if api.Store != nil {
+		for _, id := range request.Request.URL.Query()["id"] {
+			ba, err := api.Store.Get(request.Request.Context(), "apikeys/used/"+id)
+			if err == store.ErrNotFound {
+				continue
+			} else if err != nil {
+				panic(err)
+			}
+
+			if t, err := time.Parse(time.RFC3339, string(ba)); err == nil {
+				usage.LastUsed[id] = t
+			}
+		}
+	}
+
+	response.WriteEntity(usage)
+}
+
+// apiKeyExchange exchanges an API key for a token, like a /basic authentication
+// with the key: the audience is optional, and the scope restricts the groups.
+func (api *API) apiKeyExchange(request *restful.Request, response *restful.Response) {
+	form := request.Request.PostForm
+
+	if tokenType := form.Get("requested_token_type"); tokenType != "" && tokenType != accessTokenType && tokenType != jwtTokenType {
+		writeOAuthError(response, http.StatusBadRequest, "invalid_request", "unsupported requested_token_type: "+tokenType)
+		return
+	}
+
+	audiences := form["audience"]
+	if len(audiences) > 1 {
+		writeOAuthError(response, http.StatusBadRequest, "invalid_target", "at most one audience is allowed")
+		return
+	}
+
+	key := form.Get("subject_token")
+	user, _, ok := apikey.Parse(key)
+	if !ok {
+		writeOAuthError(response, http.StatusBadRequest, "invalid_grant", "malformed API key")
+		return
+	}
+
+	info := api.requestInfo(request, "token")
+	if len(audiences) != 0 {
+		info.Audience = audiences[0]
+	}
+
+	claims, err := api.authenticate(request.Request.Context(), user, key, info)
+	lockedOut := &LockedOutError{}
+	if errors.As(err, &lockedOut) || errors.Is(err, ErrInvalidAuthentication) {
+		writeOAuthError(response, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	groups, missing := api.restrictGroups(claims, form.Get("scope"))
+	if missing != "" {
+		writeOAuthError(response, http.StatusBadRequest, "invalid_scope", "not a group of the subject: "+missing)
+		return
+	}
+
+	_, tokenString, err := api.createToken(request.Request.Context(), user, claims)
+	if err != nil {
+		panic(err)
+	}
+
+	response.WriteEntity(&TokenResponse{
+		AccessToken:     tokenString,
+		IssuedTokenType: accessTokenType,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(api.TokenDuration.Seconds()),
+		Scope:           strings.Join(groups, " "),
+	})
+}
@@ -0,0 +1,472 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/isi-nc/autentigo/pkg/oauthclient"
+	"github.com/isi-nc/autentigo/pkg/store"
+)
+
+const authorizationCodeGrant = "authorization_code"
+
+// authorizationCodeTTL is the lifetime of authorization codes (RFC 6749
+// section 4.1.2 recommends 10 minutes at most).
+const authorizationCodeTTL = time.Minute
+
+// errInvalidCode indicates an unknown, expired or already used authorization code.
+var errInvalidCode = errors.New("invalid or expired code")
+
+// authorizationRequest is a validated request of the authorize endpoint.
+type authorizationRequest struct {
+	Client *oauthclient.Client
+	// RedirectURI is where the user is sent back.
+	RedirectURI string
+	// RequestedRedirectURI is the redirect_uri parameter, which must be given again with the code.
+	RequestedRedirectURI string
+	State                string
+	CodeChallenge        string
+	Audience             string
+	Scope                string
+
+	// params are the request's parameters, posted back by the login form.
+	params map[string]string
+}
+
+// authorizationCode is the state of an authorization code, stored by hash.
+type authorizationCode struct {
+	ClientID      string        `json:"client_id"`
+	RedirectURI   string        `json:"redirect_uri,omitempty"`
+	CodeChallenge string        `json:"code_challenge"`
+	Subject       string        `json:"sub"`
+	Claims        jwt.MapClaims `json:"claims"`
+	Scope         string        `json:"scope,omitempty"`
+}
+
+// loginPage is the form of the authorize endpoint.
+type loginPage struct {
+	ClientID string
+	User     string
+	Error    string
+	// Params are the authorization request's parameters, posted back with the credentials.
+	Params map[string]string
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in</title>
+<style>
+body { font-family: sans-serif; max-width: 22em; margin: 4em auto; padding: 0 1em; }
+label, input, button { display: block; width: 100%; box-sizing: border-box; }
+input { margin: .25em 0 1em; padding: .4em; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>Sign in</h1>
+<p>to continue to {{.ClientID}}</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="authorize">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<label>User <input name="user" value="{{.User}}" autocomplete="username" required autofocus></label>
+<label>Password <input name="password" type="password" autocomplete="current-password" required></label>
+<label>One-time password, if enrolled <input name="otp" inputmode="numeric" autocomplete="one-time-code"></label>
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+// authorizeParams are the parameters of an authorization request.
+var authorizeParams = []string{"response_type", "client_id", "redirect_uri", "state", "code_challenge", "code_challenge_method", "audience", "scope"}
+
+func (api *API) registerAuthorize(ws *restful.WebService) {
+	ws.
+		Route(ws.GET("/authorize").
+			To(api.authorizeForm).
+			Doc("OAuth2 authorization endpoint (authorization code flow with PKCE): shows the login form").
+			Produces("text/html").
+			Param(ws.QueryParameter("response_type", "Must be code").Required(true)).
+			Param(ws.QueryParameter("client_id", "The client's ID").Required(true)).
+			Param(ws.QueryParameter("redirect_uri", "One of the client's redirect URIs (default: its only one)")).
+			Param(ws.QueryParameter("state", "Given back to the client with the code")).
+			Param(ws.QueryParameter("code_challenge", "The PKCE challenge").Required(true)).
+			Param(ws.QueryParameter("code_challenge_method", "Must be S256").Required(true)).
+			Param(ws.QueryParameter("audience", "Restrict the token to this audience")).
+			Param(ws.QueryParameter("scope", "Restrict the token to these groups (space separated)")))
+
+	ws.
+		Route(ws.POST("/authorize").
+			To(api.authorizeLogin).
+			Doc("Authenticate with the login form, and redirect to the client with an authorization code").
+			Consumes("application/x-www-form-urlencoded").
+			Produces("text/html"))
+}
+
+func (api *API) authorizeEnabled() bool {
+	return api.Clients != nil && api.Store != nil
+}
+
+func (api *API) authorizeForm(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			WriteError(err.(error), response)
+		}
+	}()
+
+	req, ok := api.authorizationRequest(request, response, request.Request.URL.Query())
+	if !ok {
+		return
+	}
+
+	writeLoginPage(response, http.StatusOK, req, "", "")
+}
+
+func (api *API) authorizeLogin(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			WriteError(err.(error), response)
+		}
+	}()
+
+	if err := request.Request.ParseForm(); err != nil {
+		response.WriteErrorString(http.StatusBadRequest, "Invalid form.\n")
+		return
+	}
+
+	form := request.Request.PostForm
+
+	req, ok := api.authorizationRequest(request, response, form)
+	if !ok {
+		return
+	}
+
+	user, password := form.Get("user"), form.Get("password")
+	if user == "" || password == "" {
+		writeLoginPage(response, http.StatusUnauthorized, req, user, "User and password are required.")
+		return
+	}
+
+	info := api.requestInfo(request, "authorize")
+	info.Audience = req.Audience
+	info.OTP = form.Get("otp")
+
+	ctx := request.Request.Context()
+
+	claims, err := api.authenticate(ctx, user, password, info)
+	lockedOut := &LockedOutError{}
+	if errors.As(err, &lockedOut) {
+		writeLoginPage(response, http.StatusTooManyRequests, req, user, "Too many failed authentications, please retry later.")
+		return
+	} else if errors.Is(err, ErrOTPRequired) {
+		writeLoginPage(response, http.StatusUnauthorized, req, user, "One-time password required.")
+		return
+	} else if errors.Is(err, ErrInvalidAuthentication) {
+		writeLoginPage(response, http.StatusUnauthorized, req, user, "Authentication failed.")
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	groups, missing := api.restrictGroups(claims, req.Scope)
+	if missing != "" {
+		api.redirectAuthorizeError(response, req, "invalid_scope", "not a group of the user: "+missing)
+		return
+	}
+
+	claims["client_id"] = req.Client.ID
+
+	code, err := randomString(32)
+	if err != nil {
+		panic(err)
+	}
+
+	ba, err := json.Marshal(authorizationCode{
+		ClientID:      req.Client.ID,
+		RedirectURI:   req.RequestedRedirectURI,
+		CodeChallenge: req.CodeChallenge,
+		Subject:       user,
+		Claims:        claims,
+		Scope:         strings.Join(groups, " "),
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err = api.Store.Create(ctx, "authorize/codes/"+authorizationCodeHash(code), ba, authorizationCodeTTL); err != nil {
+		panic(err)
+	}
+
+	api.redirectAuthorize(response, req, url.Values{"code": {code}})
+}
+
+// authorizationRequest validates the parameters of an authorization request.
+// Requests with an unknown client or redirect URI get an error page, other
+// errors are sent to the client (RFC 6749 section 4.1.2.1).
+func (api *API) authorizationRequest(request *restful.Request, response *restful.Response, params url.Values) (req *authorizationRequest, ok bool) {
+	if !api.authorizeEnabled() {
+		response.WriteErrorString(http.StatusNotFound, "The authorization code flow is not enabled.\n")
+		return nil, false
+	}
+
+	clientID := params.Get("client_id")
+	if clientID == "" {
+		response.WriteErrorString(http.StatusBadRequest, "No client_id given.\n")
+		return nil, false
+	}
+
+	client, err := api.Clients.LookupClient(request.Request.Context(), clientID)
+	if errors.Is(err, oauthclient.ErrUnknownClient) {
+		response.WriteErrorString(http.StatusBadRequest, "Unknown client.\n")
+		return nil, false
+	} else if err != nil {
+		panic(err)
+	}
+
+	redirectURI, err := client.RedirectURI(params.Get("redirect_uri"))
+	if err != nil {
+		response.WriteErrorString(http.StatusBadRequest, "Invalid redirect_uri: "+err.Error()+".\n")
+		return nil, false
+	}
+
+	req = &authorizationRequest{
+		Client:               client,
+		RedirectURI:          redirectURI,
+		RequestedRedirectURI: params.Get("redirect_uri"),
+		State:                params.Get("state"),
+		CodeChallenge:        params.Get("code_challenge"),
+		Scope:                params.Get("scope"),
+		params:               map[string]string{},
+	}
+
+	for _, name := range authorizeParams {
+		if v := params.Get(name); v != "" {
+			req.params[name] = v
+		}
+	}
+
+	if responseType := params.Get("response_type"); responseType != "code" {
+		api.redirectAuthorizeError(response, req, "unsupported_response_type", "only the code response type is supported")
+		return nil, false
+	}
+
+	// PKCE is required for every client
+	if params.Get("code_challenge_method") != oauthclient.CodeChallengeS256 {
+		api.redirectAuthorizeError(response, req, "invalid_request", "code_challenge_method must be S256")
+		return nil, false
+	}
+
+	if !oauthclient.ValidCodeChallenge(req.CodeChallenge) {
+		api.redirectAuthorizeError(response, req, "invalid_request", "invalid or missing code_challenge")
+		return nil, false
+	}
+
+	if req.Audience, err = client.Audience(params.Get("audience")); err != nil {
+		api.redirectAuthorizeError(response, req, "invalid_target", err.Error())
+		return nil, false
+	}
+
+	return req, true
+}
+
+func writeLoginPage(response *restful.Response, status int, req *authorizationRequest, user, message string) {
+	page := loginPage{
+		ClientID: req.Client.ID,
+		User:     user,
+		Error:    message,
+		Params:   req.params,
+	}
+
+	header := response.Header()
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	header.Set("Cache-Control", "no-store")
+	header.Set("X-Frame-Options", "DENY")
+	header.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; form-action 'self' "+
+		cspSource(req.RedirectURI)+"; frame-ancestors 'none'")
+
+	response.WriteHeader(status)
+	if err := loginTemplate.Execute(response, page); err != nil {
+		log.Print("failed to write the login page: ", err)
+	}
+}
+
+// cspSource returns the CSP source expression of the URI's origin: browsers
+// apply form-action to the redirect following the login form's submission.
+func cspSource(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		// registered URIs are validated
+		panic(err)
+	}
+
+	source := u.Scheme + ":"
+	if u.Host != "" {
+		source += "//" + u.Host
+	}
+
+	// the policy's separators can't be part of the source
+	if strings.ContainsAny(source, " ;,'") {
+		return ""
+	}
+
+	return source
+}
+
+func (api *API) redirectAuthorizeError(response *restful.Response, req *authorizationRequest, code, description string) {
+	api.redirectAuthorize(response, req, url.Values{"error": {code}, "error_description": {description}})
+}
+
+// redirectAuthorize sends the user back to the client with the values, the
+// request's state, and the issuer if any (RFC 9207).
+func (api *API) redirectAuthorize(response *restful.Response, req *authorizationRequest, values url.Values) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		// registered URIs are validated
+		panic(err)
+	}
+
+	q := u.Query()
+	for k, v := range values {
+		q[k] = v
+	}
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	if api.Issuer != "" {
+		q.Set("iss", api.Issuer)
+	}
+	u.RawQuery = q.Encode()
+
+	response.Header().Set("Cache-Control", "no-store")
+	response.Header().Set("Location", u.String())
+	response.WriteHeader(http.StatusSeeOther)
+}
+
+// authorizationCodeToken redeems an authorization code at the token endpoint
+// (RFC 6749 section 4.1.3), with its PKCE verifier (RFC 7636 section 4.5).
+func (api *API) authorizationCodeToken(request *restful.Request, response *restful.Response) {
+	form := request.Request.PostForm
+
+	client, ok := api.authenticateClient(request, response, true)
+	if !ok {
+		return
+	}
+
+	ctx := request.Request.Context()
+
+	code, err := api.spendAuthorizationCode(ctx, form.Get("code"))
+	if err == errInvalidCode {
+		writeOAuthError(response, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	if code.ClientID != client.ID {
+		writeOAuthError(response, http.StatusBadRequest, "invalid_grant", "code issued to another client")
+		return
+	}
+
+	if form.Get("redirect_uri") != code.RedirectURI {
+		writeOAuthError(response, http.StatusBadRequest, "invalid_grant", "redirect_uri mismatch")
+		return
+	}
+
+	if !oauthclient.VerifyCodeVerifier(code.CodeChallenge, form.Get("code_verifier")) {
+		writeOAuthError(response, http.StatusBadRequest, "invalid_grant", "invalid code_verifier")
+		return
+	}
+
+	now := time.Now()
+
+	claims := jwt.MapClaims{}
+	for k, v := range code.Claims {
+		claims[k] = v
+	}
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(api.TokenDuration).Unix()
+
+	stamped, err := api.stamp(claims)
+	if err != nil {
+		panic(err)
+	}
+
+	_, tokenString, err := api.createToken(ctx, code.Subject, stamped)
+	if err != nil {
+		panic(err)
+	}
+
+	tokenResp := &TokenResponse{
+		AccessToken: tokenString,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(api.TokenDuration.Seconds()),
+		Scope:       code.Scope,
+	}
+
+	if api.refreshEnabled() {
+		if tokenResp.RefreshToken, err = api.startRefreshFamily(ctx, code.Subject, stamped); err != nil {
+			panic(err)
+		}
+	}
+
+	response.WriteEntity(tokenResp)
+}
+
+// spendAuthorizationCode returns the state of an authorization code, which can only be redeemed once.
+func (api *API) spendAuthorizationCode(ctx context.Context, code string) (state *authorizationCode, err error) {
+	if code == "" {
+		return nil, errInvalidCode
+	}
+
+	hash := authorizationCodeHash(code)
+	key := "authorize/codes/" + hash
+
+	err = api.Store.Create(ctx, "authorize/spent/"+hash, []byte{}, authorizationCodeTTL)
+	if err == store.ErrExists {
+		log.Print("authorization code reuse detected")
+		return nil, errInvalidCode
+	} else if err != nil {
+		return
+	}
+
+	ba, err := api.Store.Get(ctx, key)
+	if err == store.ErrNotFound {
+		return nil, errInvalidCode
+	} else if err != nil {
+		return
+	}
+
+	if err := api.Store.Delete(ctx, key); err != nil {
+		log.Print("failed to delete an authorization code: ", err)
+	}
+
+	state = &authorizationCode{}
+	if err = json.Unmarshal(ba, state); err != nil {
+		return nil, fmt.Errorf("invalid authorization code state: %w", err)
+	}
+
+	return
+}
+
+// authorizationCodeHash returns the hash codes are stored by.
+func authorizationCodeHash(code string) string {
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
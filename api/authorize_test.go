@@ -0,0 +1,261 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/isi-nc/autentigo/auth"
+	"github.com/isi-nc/autentigo/pkg/oauthclient"
+	"github.com/isi-nc/autentigo/pkg/store"
+)
+
+const (
+	testRedirectURI = "https://app.example.com/callback"
+	// testVerifier and testChallenge are the example of RFC 7636 appendix B.
+	testVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+// testAuth accepts alice with the password "ok".
+type testAuth struct{}
+
+func (testAuth) Authenticate(ctx context.Context, user, password string, expiresAt time.Time, req RequestInfo) (jwt.Claims, error) {
+	if user != "alice" {
+		return nil, ErrUnknownUser
+	}
+	if password != "ok" {
+		return nil, ErrInvalidAuthentication
+	}
+
+	claims := auth.Claims{}
+	claims.Subject = user
+	claims.ExpiresAt = expiresAt.Unix()
+	claims.Groups = []string{"dev", "ops"}
+	return claims, nil
+}
+
+type testClients map[string]*oauthclient.Client
+
+func (c testClients) LookupClient(ctx context.Context, id string) (*oauthclient.Client, error) {
+	if client, ok := c[id]; ok {
+		return client, nil
+	}
+	return nil, oauthclient.ErrUnknownClient
+}
+
+func newAuthorizeTestAPI(t *testing.T) (*API, http.Handler) {
+	api := &API{
+		Authenticator: testAuth{},
+		Keys:          NewKeyRing(newTestSigningKey(t), 0),
+		TokenDuration: time.Hour,
+		Issuer:        "https://auth.example.com",
+		Store:         store.NewMemory(),
+		Clients: testClients{
+			"app":   {ID: "app", RedirectURIs: []string{testRedirectURI}},
+			"other": {ID: "other", RedirectURIs: []string{"https://other.example.com/callback"}},
+		},
+	}
+
+	container := restful.NewContainer()
+	container.Add(api.Register())
+
+	return api, container
+}
+
+func authorizeParamsFor(clientID string) url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"state":                 {"xyz"},
+		"code_challenge":        {testChallenge},
+		"code_challenge_method": {oauthclient.CodeChallengeS256},
+		"scope":                 {"dev"},
+	}
+}
+
+func postForm(handler http.Handler, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// login signs alice in through the login form, and returns the code.
+func login(t *testing.T, handler http.Handler) string {
+	form := authorizeParamsFor("app")
+	form.Set("user", "alice")
+	form.Set("password", "ok")
+
+	rec := postForm(handler, "/authorize", form)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("login: expected a redirection, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(location.String(), testRedirectURI+"?") {
+		t.Fatalf("login: unexpected redirection to %s", location)
+	}
+
+	q := location.Query()
+	if q.Get("state") != "xyz" || q.Get("iss") != "https://auth.example.com" {
+		t.Errorf("login: unexpected state or issuer: %s", location)
+	}
+
+	code := q.Get("code")
+	if code == "" {
+		t.Fatalf("login: no code in %s", location)
+	}
+
+	return code
+}
+
+func redeem(handler http.Handler, clientID, code, redirectURI, verifier string) (*httptest.ResponseRecorder, OAuthError) {
+	form := url.Values{
+		"grant_type":    {authorizationCodeGrant},
+		"client_id":     {clientID},
+		"code":          {code},
+		"code_verifier": {verifier},
+	}
+	if redirectURI != "" {
+		form.Set("redirect_uri", redirectURI)
+	}
+
+	rec := postForm(handler, "/token", form)
+
+	oauthErr := OAuthError{}
+	if rec.Code != http.StatusOK {
+		json.Unmarshal(rec.Body.Bytes(), &oauthErr)
+	}
+
+	return rec, oauthErr
+}
+
+func TestLoginPage(t *testing.T) {
+	_, handler := newAuthorizeTestAPI(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/authorize?"+authorizeParamsFor("app").Encode(), nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the login page, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// the redirection after the form's submission must be allowed
+	if csp := rec.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "form-action 'self' https://app.example.com;") {
+		t.Errorf("form-action doesn't allow the client's origin: %s", csp)
+	}
+}
+
+func TestAuthorizationCode(t *testing.T) {
+	api, handler := newAuthorizeTestAPI(t)
+
+	code := login(t, handler)
+
+	rec, _ := redeem(handler, "app", code, "", testVerifier)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("redeem: expected a token, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	tokenResp := TokenResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tokenResp); err != nil {
+		t.Fatal(err)
+	}
+
+	if tokenResp.Scope != "dev" {
+		t.Errorf("expected the dev scope, got %q", tokenResp.Scope)
+	}
+
+	claims, err := api.checkToken(context.Background(), tokenResp.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "alice" || len(claims.Groups) != 1 || claims.Groups[0] != "dev" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	// codes are single use
+	if rec, oauthErr := redeem(handler, "app", code, "", testVerifier); rec.Code != http.StatusBadRequest || oauthErr.Code != "invalid_grant" {
+		t.Errorf("reuse: expected invalid_grant, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAuthorizationCodeChecks(t *testing.T) {
+	_, handler := newAuthorizeTestAPI(t)
+
+	for _, test := range []struct {
+		name        string
+		clientID    string
+		redirectURI string
+		verifier    string
+	}{
+		{name: "other client", clientID: "other", verifier: testVerifier},
+		{name: "redirect_uri mismatch", clientID: "app", redirectURI: testRedirectURI, verifier: testVerifier},
+		{name: "wrong verifier", clientID: "app", verifier: strings.Repeat("a", 43)},
+		{name: "no verifier", clientID: "app"},
+	} {
+		code := login(t, handler)
+
+		rec, oauthErr := redeem(handler, test.clientID, code, test.redirectURI, test.verifier)
+		if rec.Code != http.StatusBadRequest || oauthErr.Code != "invalid_grant" {
+			t.Errorf("%s: expected invalid_grant, got %d: %s", test.name, rec.Code, rec.Body.String())
+		}
+
+		// a refused code is spent too
+		if rec, _ := redeem(handler, "app", code, "", testVerifier); rec.Code == http.StatusOK {
+			t.Errorf("%s: the code could be redeemed after a refused attempt", test.name)
+		}
+	}
+}
+
+func TestAuthorizeErrors(t *testing.T) {
+	_, handler := newAuthorizeTestAPI(t)
+
+	// unknown redirect URIs get an error page, never a redirection
+	params := authorizeParamsFor("app")
+	params.Set("redirect_uri", "https://evil.example.com/callback")
+
+	req := httptest.NewRequest(http.MethodGet, "/authorize?"+params.Encode(), nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Location") != "" {
+		t.Errorf("unknown redirect_uri: expected an error page, got %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// wrong passwords show the form again
+	form := authorizeParamsFor("app")
+	form.Set("user", "alice")
+	form.Set("password", "bad")
+
+	if rec := postForm(handler, "/authorize", form); rec.Code != http.StatusUnauthorized || rec.Header().Get("Location") != "" {
+		t.Errorf("wrong password: expected the form again, got %d", rec.Code)
+	}
+
+	// other errors are sent to the client
+	params = authorizeParamsFor("app")
+	params.Set("code_challenge_method", "plain")
+
+	req = httptest.NewRequest(http.MethodGet, "/authorize?"+params.Encode(), nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	if rec.Code != http.StatusSeeOther || location.Query().Get("error") != "invalid_request" {
+		t.Errorf("plain PKCE: expected an invalid_request redirection, got %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
@@ -16,6 +16,9 @@ func (api *API) registerBasic(ws *restful.WebService) {
 			Param(setCookieHeader()).
 			Param(setCookieDomainHeader()).
 			Param(setCookieInsecureHeader()).
+			Param(restful.HeaderParameter(
+				"X-OTP", "One-time password, for users with a second factor")).
+			Param(ws.QueryParameter("audience", "Restrict the token to this audience")).
 			Produces("application/json").
 			Writes(AuthResponse{}))
 }
@@ -35,5 +38,9 @@ func (api *API) basicAuthenticate(request *restful.Request, response *restful.Re
 		return
 	}
 
-	api.writeAuthResponse(request, response, user, password)
+	info := api.requestInfo(request, "basic")
+	info.Audience = request.QueryParameter("audience")
+	info.OTP = request.HeaderParameter("X-OTP")
+
+	api.writeAuthResponse(request, response, user, password, info)
 }
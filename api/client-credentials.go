@@ -0,0 +1,138 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/isi-nc/autentigo/auth"
+	"github.com/isi-nc/autentigo/pkg/oauthclient"
+)
+
+const clientCredentialsGrant = "client_credentials"
+
+// clientCredentials implements the client credentials grant (RFC 6749 section
+// 4.4): registered clients get tokens for themselves, with the client as sub.
+// Clients authenticate with HTTP basic auth, or client_id and client_secret
+// form parameters.
+func (api *API) clientCredentials(request *restful.Request, response *restful.Response) {
+	form := request.Request.PostForm
+
+	client, ok := api.authenticateClient(request, response, false)
+	if !ok {
+		return
+	}
+
+	audiences := form["audience"]
+	if len(audiences) > 1 {
+		writeOAuthError(response, http.StatusBadRequest, "invalid_target", "at most one audience is allowed")
+		return
+	}
+
+	requested := ""
+	if len(audiences) != 0 {
+		requested = audiences[0]
+	}
+
+	audience, err := client.Audience(requested)
+	if err != nil {
+		writeOAuthError(response, http.StatusBadRequest, "invalid_target", err.Error())
+		return
+	}
+
+	groups := client.Groups
+	if scope := form.Get("scope"); scope != "" {
+		groups = nil
+		for _, group := range strings.Fields(scope) {
+			if !contains(client.Groups, group) {
+				writeOAuthError(response, http.StatusBadRequest, "invalid_scope", "not a group of the client: "+group)
+				return
+			}
+			groups = append(groups, group)
+		}
+	}
+
+	now := time.Now()
+	lifetime := client.Lifetime(api.TokenDuration)
+
+	c := auth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  audience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(lifetime).Unix(),
+			Subject:   client.ID,
+		},
+	}
+	c.Groups = groups
+
+	claims, err := api.stamp(c)
+	if err != nil {
+		panic(err)
+	}
+
+	claims["client_id"] = client.ID
+
+	_, tokenString, err := api.createToken(request.Request.Context(), client.ID, claims)
+	if err != nil {
+		panic(err)
+	}
+
+	// no refresh token: the client can get a new token at any time (RFC 6749 section 4.4.3)
+	response.WriteEntity(&TokenResponse{
+		AccessToken: tokenString,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(lifetime.Seconds()),
+		Scope:       strings.Join(groups, " "),
+	})
+}
+
+// authenticateClient returns the client authenticated by the request, or
+// answers an invalid_client error. Public clients only give their client_id,
+// and are refused unless allowed.
+func (api *API) authenticateClient(request *restful.Request, response *restful.Response, allowPublic bool) (*oauthclient.Client, bool) {
+	form := request.Request.PostForm
+
+	clientID, secret, basic := request.Request.BasicAuth()
+	if !basic {
+		clientID, secret = form.Get("client_id"), form.Get("client_secret")
+	}
+
+	if clientID == "" {
+		writeInvalidClient(response, basic, "no client credentials given")
+		return nil, false
+	}
+
+	client, err := api.Clients.LookupClient(request.Request.Context(), clientID)
+	if errors.Is(err, oauthclient.ErrUnknownClient) {
+		writeInvalidClient(response, basic, "client authentication failed")
+		return nil, false
+	} else if err != nil {
+		panic(err)
+	}
+
+	if client.Public() && allowPublic {
+		return client, true
+	}
+
+	if ok, err := client.CheckSecret(secret); err != nil {
+		panic(err)
+	} else if !ok {
+		writeInvalidClient(response, basic, "client authentication failed")
+		return nil, false
+	}
+
+	return client, true
+}
+
+func writeInvalidClient(response *restful.Response, basic bool, description string) {
+	status := http.StatusBadRequest
+	if basic {
+		response.Header().Set("WWW-Authenticate", `Basic realm="Autorizo"`)
+		status = http.StatusUnauthorized
+	}
+
+	writeOAuthError(response, status, "invalid_client", description)
+}
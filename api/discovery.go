@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/isi-nc/autentigo/auth"
+	"github.com/isi-nc/autentigo/pkg/claimmap"
+	"github.com/isi-nc/autentigo/pkg/oauthclient"
+)
+
+// OpenIDConfiguration is the OpenID Connect discovery document of this server.
+type OpenIDConfiguration struct {
+	Issuer                           string   `json:"issuer"`
+	AuthorizationEndpoint            string   `json:"authorization_endpoint,omitempty"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	TokenEndpoint                    string   `json:"token_endpoint,omitempty"`
+	GrantTypesSupported              []string `json:"grant_types_supported,omitempty"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+	ClaimsSupported                  []string `json:"claims_supported"`
+	CodeChallengeMethodsSupported    []string `json:"code_challenge_methods_supported,omitempty"`
+	AuthorizationResponseIssParam    bool     `json:"authorization_response_iss_parameter_supported,omitempty"`
+}
+
+func (api *API) registerDiscovery(ws *restful.WebService) {
+	ws.
+		Route(ws.GET("/.well-known/openid-configuration").
+			To(api.openIDConfiguration).
+			Doc("Returns the OpenID Connect discovery document (requires an issuer)").
+			Produces("application/json").
+			Writes(OpenIDConfiguration{}))
+}
+
+func (api *API) openIDConfiguration(request *restful.Request, response *restful.Response) {
+	if api.Issuer == "" {
+		response.WriteErrorString(http.StatusNotFound, "No issuer configured.\n")
+		return
+	}
+
+	base := strings.TrimSuffix(api.Issuer, "/")
+
+	config := &OpenIDConfiguration{
+		Issuer:                           api.Issuer,
+		JWKSURI:                          base + "/.well-known/jwks.json",
+		TokenEndpoint:                    base + "/token",
+		GrantTypesSupported:              api.grantTypes(),
+		ResponseTypesSupported:           []string{"id_token"},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: api.signingAlgs(),
+		ClaimsSupported:                  api.supportedClaims(),
+	}
+
+	if api.authorizeEnabled() {
+		config.AuthorizationEndpoint = base + "/authorize"
+		config.ResponseTypesSupported = append(config.ResponseTypesSupported, "code")
+		config.CodeChallengeMethodsSupported = []string{oauthclient.CodeChallengeS256}
+		config.AuthorizationResponseIssParam = true
+	}
+
+	response.WriteEntity(config)
+}
+
+// signingAlgs lists the signing methods of the keys in the ring.
+func (api *API) signingAlgs() (algs []string) {
+	seen := map[string]bool{}
+	for _, key := range api.Keys.Keys() {
+		alg := key.Method.Alg()
+		if !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return
+}
+
+// supportedClaims lists the standard claims we emit, our extra claims and the mapped ones.
+func (api *API) supportedClaims() []string {
+	claims := []string{"iss", "sub", "aud", "iat", "exp", "jti", "amr"}
+	if api.Clients != nil {
+		claims = append(claims, "client_id")
+	}
+
+	t := reflect.TypeOf(auth.ExtraClaims{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || name == claimmap.AttributesClaim || api.ClaimMapping.Renames(name) {
+			continue
+		}
+		claims = append(claims, name)
+	}
+
+	for _, name := range api.ClaimMapping.Claims() {
+		if !contains(claims, name) {
+			claims = append(claims, name)
+		}
+	}
+
+	return claims
+}
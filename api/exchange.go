@@ -0,0 +1,136 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	tokenExchangeGrant = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	accessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+	jwtTokenType    = "urn:ietf:params:oauth:token-type:jwt"
+	apiKeyTokenType = "urn:autentigo:token-type:api-key"
+)
+
+// tokenExchange implements RFC 8693 token exchange: the subject token is
+// exchanged for a shorter-lived token restricted to the requested audience,
+// with a subset of its groups (requested as scope).
+func (api *API) tokenExchange(request *restful.Request, response *restful.Response) {
+	form := request.Request.PostForm
+
+	switch tokenType := form.Get("subject_token_type"); tokenType {
+	case accessTokenType, jwtTokenType:
+	case apiKeyTokenType:
+		api.apiKeyExchange(request, response)
+		return
+	default:
+		writeOAuthError(response, http.StatusBadRequest, "invalid_request", "unsupported subject_token_type: "+tokenType)
+		return
+	}
+
+	if tokenType := form.Get("requested_token_type"); tokenType != "" && tokenType != accessTokenType && tokenType != jwtTokenType {
+		writeOAuthError(response, http.StatusBadRequest, "invalid_request", "unsupported requested_token_type: "+tokenType)
+		return
+	}
+
+	audiences := form["audience"]
+	if len(audiences) != 1 || audiences[0] == "" {
+		writeOAuthError(response, http.StatusBadRequest, "invalid_target", "exactly one audience is required")
+		return
+	}
+
+	// audience restricted tokens can't be exchanged again
+	subjectToken := form.Get("subject_token")
+	subject, err := api.checkToken(request.Request.Context(), subjectToken)
+	if err != nil {
+		writeOAuthError(response, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	}
+
+	// the subject token is valid: keep all its claims, including the mapped ones
+	claims := jwt.MapClaims{}
+	if _, _, err = new(jwt.Parser).ParseUnverified(subjectToken, claims); err != nil {
+		panic(err)
+	}
+
+	groups, missing := api.restrictGroups(claims, form.Get("scope"))
+	if missing != "" {
+		writeOAuthError(response, http.StatusBadRequest, "invalid_scope", "not a group of the subject: "+missing)
+		return
+	}
+
+	now := time.Now()
+	exp := now.Add(api.ExchangeTokenDuration)
+	if subjectExp := time.Unix(subject.ExpiresAt, 0); subjectExp.Before(exp) {
+		exp = subjectExp
+	}
+
+	delete(claims, "nbf")
+	claims["aud"] = audiences[0]
+	claims["iat"] = now.Unix()
+	claims["exp"] = exp.Unix()
+
+	claims, err = api.stamp(claims)
+	if err != nil {
+		panic(err)
+	}
+
+	_, tokenString, err := api.createToken(request.Request.Context(), subject.Subject, claims)
+	if err != nil {
+		panic(err)
+	}
+
+	response.WriteEntity(&TokenResponse{
+		AccessToken:     tokenString,
+		IssuedTokenType: accessTokenType,
+		TokenType:       "Bearer",
+		ExpiresIn:       exp.Unix() - now.Unix(),
+		Scope:           strings.Join(groups, " "),
+	})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictGroups restricts the groups of the claims, and their copies by the
+// claim mapping, to the scope (space separated groups), if any. It returns the
+// groups, or the first requested group missing from the claims.
+func (api *API) restrictGroups(claims jwt.MapClaims, scope string) (groups []string, missing string) {
+	claimGroups, _ := claims["groups"].([]interface{})
+	for _, group := range claimGroups {
+		if group, ok := group.(string); ok {
+			groups = append(groups, group)
+		}
+	}
+
+	if scope == "" {
+		return groups, ""
+	}
+
+	requested := strings.Fields(scope)
+	for _, group := range requested {
+		if !contains(groups, group) {
+			return nil, group
+		}
+	}
+
+	claims["groups"] = requested
+	for _, name := range api.ClaimMapping.Copies("groups") {
+		if _, ok := claims[name]; ok {
+			claims[name] = requested
+		}
+	}
+
+	return requested, ""
+}
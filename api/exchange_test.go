@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/isi-nc/autentigo/pkg/claimmap"
+)
+
+func TestExchangeKeepsMappedClaims(t *testing.T) {
+	api, handler := newAuthorizeTestAPI(t)
+	api.ExchangeTokenDuration = 15 * time.Minute
+
+	var err error
+	if api.ClaimMapping, err = claimmap.FromBytes([]byte("copy: {groups: roles}\nstatic: {tenant: acme}")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a token of alice, with all her groups
+	claims, err := api.authenticate(context.Background(), "alice", "ok", RequestInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, subjectToken, err := api.createToken(context.Background(), "alice", claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := postForm(handler, "/token", url.Values{
+		"grant_type":         {tokenExchangeGrant},
+		"subject_token":      {subjectToken},
+		"subject_token_type": {accessTokenType},
+		"audience":           {"svc-a"},
+		"scope":              {"dev"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected a token, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	tokenResp := TokenResponse{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tokenResp); err != nil {
+		t.Fatal(err)
+	}
+
+	exchanged := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(tokenResp.AccessToken, exchanged); err != nil {
+		t.Fatal(err)
+	}
+
+	if exchanged["tenant"] != "acme" || exchanged["aud"] != "svc-a" || exchanged["sub"] != "alice" {
+		t.Errorf("unexpected claims: %v", exchanged)
+	}
+
+	// the copies of the groups are restricted too
+	dev := []interface{}{"dev"}
+	if !reflect.DeepEqual(exchanged["groups"], dev) || !reflect.DeepEqual(exchanged["roles"], dev) {
+		t.Errorf("groups and roles should be restricted to the scope: %v", exchanged)
+	}
+}
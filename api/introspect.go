@@ -0,0 +1,75 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/isi-nc/autentigo/auth"
+)
+
+func (api *API) registerIntrospection(ws *restful.WebService) {
+	ws.
+		Route(ws.POST("/introspect").
+			To(api.introspect).
+			Doc("Token introspection (RFC 7662), requires client credentials").
+			Consumes("application/x-www-form-urlencoded").
+			Produces("application/json").
+			Param(restful.HeaderParameter(
+				"Authorization", "Basic authorization header with the client's credentials")).
+			Param(ws.FormParameter("token", "The token to introspect").Required(true)).
+			Param(ws.FormParameter("token_type_hint", "Ignored, only access tokens can be introspected")))
+}
+
+func (api *API) introspect(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			WriteError(err.(error), response)
+		}
+	}()
+
+	if api.IntrospectionClients == nil {
+		response.WriteErrorString(http.StatusNotFound, "Introspection is not enabled.\n")
+		return
+	}
+
+	clientID, secret, ok := request.Request.BasicAuth()
+	if !ok {
+		response.Header().Set("WWW-Authenticate", `Basic realm="Autorizo"`)
+		response.WriteErrorString(http.StatusUnauthorized, "Unauthorized.\n")
+		return
+	}
+
+	exp := time.Now().Add(api.TokenDuration)
+	info := api.requestInfo(request, "introspect")
+	if _, err := api.IntrospectionClients.Authenticate(request.Request.Context(), clientID, secret, exp, info); errors.Is(err, ErrInvalidAuthentication) {
+		response.Header().Set("WWW-Authenticate", `Basic realm="Autorizo"`)
+		response.WriteErrorString(http.StatusUnauthorized, "Unauthorized.\n")
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	result := map[string]interface{}{"active": false}
+
+	// the client is responsible for checking the audience
+	claims, err := api.parseToken(request.Request.Context(), request.Request.PostFormValue("token"))
+	if err == nil {
+		m, err := auth.ToMap(claims)
+		if err != nil {
+			panic(err)
+		}
+
+		for k, v := range m {
+			result[k] = v
+		}
+
+		result["active"] = true
+		result["token_type"] = "Bearer"
+		result["username"] = claims.Subject
+	}
+
+	response.WriteEntity(result)
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/isi-nc/autentigo/pkg/keys"
+)
+
+func (api *API) registerJWKS(ws *restful.WebService) {
+	ws.
+		Route(ws.GET("/.well-known/jwks.json").
+			To(api.jwks).
+			Doc("Returns the JSON Web Key Set to use to validate tokens from this server").
+			Produces("application/json").
+			Writes(keys.JWKSet{}))
+}
+
+func (api *API) jwks(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			WriteError(err.(error), response)
+		}
+	}()
+
+	set := keys.JWKSet{}
+
+	for _, key := range api.Keys.Keys() {
+		jwk, err := keys.NewJWK(key.Public, key.Method.Alg())
+		if err != nil {
+			panic(err)
+		}
+
+		jwk.Kid = key.ID
+		jwk.X5c = keys.EncodeChain(key.Chain)
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	response.WriteEntity(set)
+}
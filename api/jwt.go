@@ -1,23 +1,82 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
 	"time"
 
+	restful "github.com/emicklei/go-restful/v3"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/isi-nc/autentigo/auth"
+	"github.com/isi-nc/autentigo/pkg/apikey"
+	"github.com/isi-nc/autentigo/pkg/keys"
 )
 
-func (api *API) createToken(user string, claims jwt.Claims) (*jwt.Token, string, error) {
-	token := jwt.NewWithClaims(api.SigningMethod, claims)
-	signed, err := token.SignedString(api.PrivateKey)
-	return token, signed, err
+func (api *API) createToken(ctx context.Context, user string, claims jwt.Claims) (*jwt.Token, string, error) {
+	signer := api.Signer
+	if signer == nil {
+		signer = api.Keys
+	}
+
+	key := signer.SigningKey()
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	if api.X5CHeader && len(key.Chain) != 0 {
+		token.Header["x5c"] = keys.EncodeChain(key.Chain)
+	}
+
+	signingString, err := token.SigningString()
+	if err != nil {
+		return nil, "", err
+	}
+
+	sig, err := signer.Sign(ctx, key, signingString)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token.Signature = jwt.EncodeSegment(sig)
+	return token, signingString + "." + token.Signature, nil
 }
 
 func (api *API) keyfunc(t *jwt.Token) (interface{}, error) {
-	return api.PublicKey, nil
+	key := api.Keys.Active()
+
+	// tokens without kid come from before the key ring, they can only be from the active key
+	if kid, ok := t.Header["kid"].(string); ok {
+		if key = api.Keys.Lookup(kid); key == nil {
+			return nil, fmt.Errorf("unknown key id: %q", kid)
+		}
+	}
+
+	if alg := t.Method.Alg(); alg != key.Method.Alg() {
+		return nil, fmt.Errorf("signing method %s does not match key %s", alg, key.ID)
+	}
+
+	return key.Public, nil
 }
 
-func (api *API) checkToken(tokenString string) (*auth.Claims, error) {
+// checkToken validates the token. Tokens restricted to an audience are only
+// accepted if it's one of the given audiences.
+func (api *API) checkToken(ctx context.Context, tokenString string, audiences ...string) (*auth.Claims, error) {
+	claims, err := api.parseToken(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Audience != "" && !contains(audiences, claims.Audience) {
+		return nil, fmt.Errorf("invalid audience: %q", claims.Audience)
+	}
+
+	return claims, nil
+}
+
+// parseToken validates the token, whatever its audience.
+func (api *API) parseToken(ctx context.Context, tokenString string) (*auth.Claims, error) {
 	claims := &auth.Claims{}
 
 	if _, err := jwt.ParseWithClaims(tokenString, claims, api.keyfunc); err != nil {
@@ -28,10 +87,126 @@ func (api *API) checkToken(tokenString string) (*auth.Claims, error) {
 		return nil, err
 	}
 
+	if api.Issuer != "" && !claims.VerifyIssuer(api.Issuer, true) {
+		return nil, fmt.Errorf("invalid issuer: %q", claims.Issuer)
+	}
+
+	if revoked, err := api.isRevoked(ctx, claims); err != nil {
+		return nil, err
+	} else if revoked {
+		return nil, ErrTokenRevoked
+	}
+
 	return claims, nil
 }
 
-func (api *API) authenticate(user, password string) (jwt.Claims, error) {
+func (api *API) authenticate(ctx context.Context, user, password string, info RequestInfo) (jwt.MapClaims, error) {
+	if err := api.checkLockout(ctx, user, info); err != nil {
+		return nil, err
+	}
+
 	exp := time.Now().Add(api.TokenDuration)
-	return api.Authenticator.Authenticate(user, password, exp)
+
+	var claims jwt.Claims
+	var err error
+	if lookup, ok := api.Authenticator.(APIKeyLookup); ok && apikey.IsKey(password) {
+		claims, err = api.authenticateAPIKey(ctx, lookup, user, password, exp)
+	} else {
+		claims, err = api.Authenticator.Authenticate(ctx, user, password, exp, info)
+	}
+
+	var m jwt.MapClaims
+	if err == nil {
+		m, err = auth.ToMap(claims)
+	}
+
+	if err == nil && hasOTP(m) {
+		err = api.checkOTPReplay(ctx, user, info.OTP)
+	}
+
+	if errors.Is(err, ErrOTPRequired) {
+		// the client is expected to retry with the one-time password
+		return nil, err
+	} else if errors.Is(err, ErrInvalidAuthentication) {
+		if lockErr := api.authenticationFailed(ctx, user, info, err); lockErr != nil {
+			log.Print("failed to record the authentication failure: ", lockErr)
+		}
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := api.authenticationSucceeded(ctx, user); err != nil {
+		log.Print("failed to reset the authentication failures: ", err)
+	}
+
+	return api.tokenClaims(m, info)
+}
+
+// tokenClaims maps the claims of an authenticated user to the token's claims.
+func (api *API) tokenClaims(m jwt.MapClaims, info RequestInfo) (jwt.MapClaims, error) {
+	api.ClaimMapping.Apply(m)
+
+	if info.Audience != "" {
+		m["aud"] = info.Audience
+	}
+
+	return api.stamp(m)
+}
+
+// hasOTP tells if the claims are of a user authenticated with a one-time password.
+func hasOTP(claims jwt.MapClaims) bool {
+	amr, _ := claims["amr"].([]interface{})
+	for _, method := range amr {
+		if method == "otp" {
+			return true
+		}
+	}
+	return false
+}
+
+// requestInfo describes the request for authenticators.
+func (api *API) requestInfo(request *restful.Request, endpoint string) RequestInfo {
+	info := RequestInfo{
+		RemoteAddr: request.Request.RemoteAddr,
+		UserAgent:  request.Request.UserAgent(),
+		Endpoint:   endpoint,
+	}
+
+	if api.ClientIP != nil {
+		info.ClientIP = api.ClientIP(request.Request)
+	}
+
+	return info
+}
+
+// clientIP returns the IP of the client: the one found behind trusted
+// proxies, or the host of the remote address.
+func (info RequestInfo) clientIP() string {
+	if info.ClientIP != "" {
+		return info.ClientIP
+	}
+
+	if host, _, err := net.SplitHostPort(info.RemoteAddr); err == nil {
+		return host
+	}
+	return info.RemoteAddr
+}
+
+// stamp adds the claims this server is responsible for to the backend's claims.
+func (api *API) stamp(claims jwt.Claims) (jwt.MapClaims, error) {
+	m, err := auth.ToMap(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if api.Issuer != "" {
+		m["iss"] = api.Issuer
+	}
+
+	if m["jti"], err = newTokenID(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
 }
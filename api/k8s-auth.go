@@ -26,7 +26,7 @@ func (api *API) k8sTokenReview(request *restful.Request, response *restful.Respo
 		return
 	}
 
-	claims, err := api.checkToken(req.Spec.Token)
+	claims, err := api.checkToken(request.Request.Context(), req.Spec.Token, req.Spec.Audiences...)
 
 	tr := &authv1.TokenReview{
 		TypeMeta: metav1.TypeMeta{
@@ -55,8 +55,14 @@ func (api *API) k8sTokenReview(request *restful.Request, response *restful.Respo
 		extra["email_verified"] = authv1.ExtraValue{"true"}
 	}
 
+	var audiences []string
+	if claims.Audience != "" {
+		audiences = []string{claims.Audience}
+	}
+
 	tr.Status = authv1.TokenReviewStatus{
 		Authenticated: true,
+		Audiences:     audiences,
 		User: authv1.UserInfo{
 			Username: claims.Subject,
 			Groups:   claims.Groups,
@@ -0,0 +1,293 @@
+package api
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/isi-nc/autentigo/pkg/keys"
+)
+
+// SigningKey is a key of a KeyRing.
+type SigningKey struct {
+	// ID is the key id, used as the kid header of tokens.
+	ID      string
+	Method  jwt.SigningMethod
+	Public  crypto.PublicKey
+	Private crypto.Signer // nil for verify-only keys
+	CRTData []byte
+
+	// Chain is the certificate chain of the key, if it was given with certificates.
+	Chain []*x509.Certificate
+}
+
+// NewSigningKey parses a PEM key pair. The public part can be a public key,
+// a certificate or a certificate chain. The private key is optional. If the
+// method is nil, it is inferred from the key.
+func NewSigningKey(method jwt.SigningMethod, crtData, keyData []byte) (k *SigningKey, err error) {
+	k = &SigningKey{
+		CRTData: crtData,
+	}
+
+	if k.Public, k.Chain, err = keys.ParsePublicKey(crtData); err != nil {
+		return nil, fmt.Errorf("failed to load public key: %w", err)
+	}
+
+	if keyData != nil {
+		if k.Private, err = keys.ParsePrivateKey(keyData); err != nil {
+			return nil, fmt.Errorf("failed to load private key: %w", err)
+		}
+
+		if !keys.KeyPairMatches(k.Private, k.Public) {
+			return nil, errors.New("private key does not match the public key")
+		}
+	}
+
+	if method == nil {
+		alg, err := keys.DefaultAlgorithm(k.Public)
+		if err != nil {
+			return nil, err
+		}
+		method = jwt.GetSigningMethod(alg)
+	}
+
+	if !keys.AlgorithmMatches(method.Alg(), k.Public) {
+		return nil, fmt.Errorf("signing method %s can't be used with a %T key", method.Alg(), k.Public)
+	}
+
+	k.Method = method
+
+	if k.ID, err = keys.Thumbprint(k.Public); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// KeyRing holds the active signing key and the keys still accepted for
+// verification. Keys leaving the ring are kept for GracePeriod so tokens
+// they signed remain valid.
+type KeyRing struct {
+	GracePeriod time.Duration
+
+	mutex   sync.RWMutex
+	active  *SigningKey
+	keys    map[string]*SigningKey
+	retired map[string]time.Time
+}
+
+// NewKeyRing creates a KeyRing with the given active key.
+func NewKeyRing(active *SigningKey, gracePeriod time.Duration) *KeyRing {
+	r := &KeyRing{GracePeriod: gracePeriod}
+	r.Update(active, nil)
+	return r
+}
+
+// Active returns the key used to sign new tokens.
+func (r *KeyRing) Active() *SigningKey {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.active
+}
+
+// Lookup returns the key with the given id, or nil if it's not (or no longer) in the ring.
+func (r *KeyRing) Lookup(kid string) *SigningKey {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if dropAt, ok := r.retired[kid]; ok && time.Now().After(dropAt) {
+		return nil
+	}
+
+	return r.keys[kid]
+}
+
+// Keys returns every key of the ring, the active one first.
+func (r *KeyRing) Keys() []*SigningKey {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.prune()
+
+	list := make([]*SigningKey, 0, len(r.keys))
+	list = append(list, r.active)
+
+	for _, k := range r.keys {
+		if k != r.active {
+			list = append(list, k)
+		}
+	}
+
+	sort.Slice(list[1:], func(i, j int) bool { return list[1+i].ID < list[1+j].ID })
+
+	return list
+}
+
+// Update replaces the keys of the ring. Keys not given anymore, including
+// the previously active one, are retired for the grace period.
+func (r *KeyRing) Update(active *SigningKey, verifyOnly []*SigningKey) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.keys == nil {
+		r.keys = map[string]*SigningKey{}
+		r.retired = map[string]time.Time{}
+	}
+
+	current := map[string]*SigningKey{active.ID: active}
+	for _, k := range verifyOnly {
+		current[k.ID] = k
+	}
+
+	now := time.Now()
+	for kid := range r.keys {
+		if _, ok := current[kid]; ok {
+			continue
+		}
+		if _, ok := r.retired[kid]; !ok {
+			log.Printf("key %s retired, dropping it in %v", kid, r.GracePeriod)
+			r.retired[kid] = now.Add(r.GracePeriod)
+		}
+	}
+
+	for kid, k := range current {
+		if _, ok := r.keys[kid]; !ok {
+			log.Print("key ", kid, " added")
+		}
+		r.keys[kid] = k
+		delete(r.retired, kid)
+	}
+
+	if r.active == nil || r.active.ID != active.ID {
+		log.Print("key ", active.ID, " is now the active signing key")
+	}
+	r.active = active
+
+	r.prune()
+}
+
+func (r *KeyRing) prune() {
+	now := time.Now()
+	for kid, dropAt := range r.retired {
+		if now.After(dropAt) {
+			log.Print("key ", kid, " dropped")
+			delete(r.keys, kid)
+			delete(r.retired, kid)
+		}
+	}
+}
+
+// LoadKeyDir loads keys from a directory, where each key is a `<name>.crt`
+// file, with an optional `<name>.key` private key. The active key is the one
+// named in the `active` file or, if there's no such file, the only one
+// having a private key. Other keys are verify-only. If the method is nil,
+// it is inferred for each key.
+func LoadKeyDir(dir string, method jwt.SigningMethod) (active *SigningKey, verifyOnly []*SigningKey, err error) {
+	crtFiles, err := filepath.Glob(filepath.Join(dir, "*.crt"))
+	if err != nil {
+		return
+	}
+
+	activeName := ""
+	if ba, err := ioutil.ReadFile(filepath.Join(dir, "active")); err == nil {
+		activeName = strings.TrimSpace(string(ba))
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	for _, crtFile := range crtFiles {
+		name := strings.TrimSuffix(filepath.Base(crtFile), ".crt")
+
+		crtData, err := ioutil.ReadFile(crtFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		keyData, err := ioutil.ReadFile(filepath.Join(dir, name+".key"))
+		if os.IsNotExist(err) {
+			keyData = nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		k, err := NewSigningKey(method, crtData, keyData)
+		if err != nil {
+			return nil, nil, fmt.Errorf("key %s: %w", name, err)
+		}
+
+		isActive := name == activeName
+		if activeName == "" && k.Private != nil {
+			if active != nil {
+				return nil, nil, errors.New("multiple private keys, please specify the active one")
+			}
+			isActive = true
+		}
+
+		if isActive {
+			if k.Private == nil {
+				return nil, nil, fmt.Errorf("key %s: active key has no private key", name)
+			}
+			active = k
+		} else {
+			verifyOnly = append(verifyOnly, k)
+		}
+	}
+
+	if active == nil {
+		return nil, nil, fmt.Errorf("no active key found in %s", dir)
+	}
+
+	return
+}
+
+// WatchKeyDir reloads the ring from the directory every interval, when its content changes.
+func (r *KeyRing) WatchKeyDir(dir string, method jwt.SigningMethod, interval time.Duration) {
+	previous := dirState(dir)
+
+	for range time.Tick(interval) {
+		state := dirState(dir)
+		if bytes.Equal(state, previous) {
+			r.mutex.Lock()
+			r.prune()
+			r.mutex.Unlock()
+			continue
+		}
+
+		active, verifyOnly, err := LoadKeyDir(dir, method)
+		if err != nil {
+			log.Print("failed to reload keys from ", dir, ": ", err)
+			continue
+		}
+
+		r.Update(active, verifyOnly)
+		previous = state
+	}
+}
+
+// dirState returns a cheap fingerprint of the directory content.
+func dirState(dir string) []byte {
+	buf := &bytes.Buffer{}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	for _, e := range entries {
+		fmt.Fprintf(buf, "%s:%d:%d\n", e.Name(), e.Size(), e.ModTime().UnixNano())
+	}
+
+	return buf.Bytes()
+}
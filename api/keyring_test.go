@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// newTestKeyPair generates a PEM encoded ECDSA key pair.
+func newTestKeyPair(t *testing.T) (crtData, keyData []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubDer, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+}
+
+func newTestSigningKey(t *testing.T) *SigningKey {
+	crtData, keyData := newTestKeyPair(t)
+
+	k, err := NewSigningKey(nil, crtData, keyData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestKeyRingLookup(t *testing.T) {
+	a, b := newTestSigningKey(t), newTestSigningKey(t)
+
+	r := NewKeyRing(a, time.Hour)
+	r.Update(a, []*SigningKey{b})
+
+	if r.Active() != a {
+		t.Error("a should be the active key")
+	}
+	if r.Lookup(a.ID) != a || r.Lookup(b.ID) != b {
+		t.Error("keys should be found by kid")
+	}
+	if r.Lookup("unknown") != nil {
+		t.Error("unknown kid should give no key")
+	}
+
+	if keys := r.Keys(); len(keys) != 2 || keys[0] != a {
+		t.Errorf("expected the active key first, then b, got %d keys", len(keys))
+	}
+}
+
+func TestKeyRingKidSelection(t *testing.T) {
+	a, b := newTestSigningKey(t), newTestSigningKey(t)
+
+	api := &API{Keys: NewKeyRing(a, time.Hour)}
+
+	_, tokenA, err := api.createToken(context.Background(), "alice", jwt.MapClaims{"sub": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b becomes the active key, a is retired
+	api.Keys.Update(b, nil)
+
+	_, tokenB, err := api.createToken(context.Background(), "alice", jwt.MapClaims{"sub": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tokenString := range map[string]string{"a": tokenA, "b": tokenB} {
+		token, err := jwt.Parse(tokenString, api.keyfunc)
+		if err != nil || !token.Valid {
+			t.Errorf("token of %s should be valid: %v", name, err)
+		}
+	}
+
+	// a token naming an unknown key
+	c := newTestSigningKey(t)
+	_, tokenC, err := (&API{Keys: NewKeyRing(c, 0)}).createToken(context.Background(), "alice", jwt.MapClaims{"sub": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(tokenC, api.keyfunc); err == nil {
+		t.Error("a token of a key outside the ring should be rejected")
+	}
+}
+
+func TestKeyRingGracePeriod(t *testing.T) {
+	a, b := newTestSigningKey(t), newTestSigningKey(t)
+
+	r := NewKeyRing(a, 50*time.Millisecond)
+	r.Update(b, nil)
+
+	if r.Active() != b {
+		t.Error("b should be the active key")
+	}
+	if r.Lookup(a.ID) != a {
+		t.Error("a should still be accepted during the grace period")
+	}
+
+	// giving a back cancels its retirement
+	r.Update(b, []*SigningKey{a})
+	r.Update(b, nil)
+
+	time.Sleep(60 * time.Millisecond)
+
+	if r.Lookup(a.ID) != nil {
+		t.Error("a should be dropped after the grace period")
+	}
+	if keys := r.Keys(); len(keys) != 1 || keys[0] != b {
+		t.Errorf("expected only b in the ring, got %d keys", len(keys))
+	}
+}
+
+func TestLoadKeyDir(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name string, data []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldCrt, oldKey := newTestKeyPair(t)
+	write("old.crt", oldCrt)
+	write("old.key", oldKey)
+
+	active, verifyOnly, err := LoadKeyDir(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verifyOnly) != 0 {
+		t.Errorf("expected no verify-only key, got %d", len(verifyOnly))
+	}
+
+	r := NewKeyRing(active, time.Hour)
+	oldID := active.ID
+	state := dirState(dir)
+
+	// publish a new key, without using it yet
+	newCrt, newKey := newTestKeyPair(t)
+	write("new.crt", newCrt)
+	write("new.key", newKey)
+
+	if _, _, err := LoadKeyDir(dir, nil); err == nil {
+		t.Error("two private keys without active file should be refused")
+	}
+
+	write("active", []byte("old\n"))
+
+	if _, _, err = LoadKeyDir(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// switch to the new key and remove the old one
+	write("active", []byte("new\n"))
+	for _, name := range []string{"old.crt", "old.key"} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if string(dirState(dir)) == string(state) {
+		t.Fatal("the directory change should be detected")
+	}
+
+	active, verifyOnly, err = LoadKeyDir(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Update(active, verifyOnly)
+
+	if r.Active().ID == oldID {
+		t.Error("the new key should be active after the reload")
+	}
+	if r.Lookup(oldID) == nil {
+		t.Error("the removed key should be kept for the grace period")
+	}
+
+	// an active key needs its private key
+	write("pub.crt", newCrt)
+	write("active", []byte("pub\n"))
+	if _, _, err := LoadKeyDir(dir, nil); err == nil {
+		t.Error("an active key without private key should be refused")
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
@@ -28,6 +29,13 @@ type KeystoneAuth struct {
 				} `json:"domain"`
 			} `json:"user"`
 		} `json:"password"`
+		TOTP struct {
+			User struct {
+				ID       string `json:"id,omitempty"`
+				Name     string `json:"name,omitempty"`
+				Passcode string `json:"passcode"`
+			} `json:"user"`
+		} `json:"totp"`
 	} `json:"identity"`
 }
 
@@ -66,6 +74,15 @@ func (api *API) registerKeystone(ws *restful.WebService) {
 				"X-Subject-Token", "The authentication token.")).
 			Writes(KeystoneAuthResponse{}))
 
+	ws.
+		Route(ws.DELETE(path).
+			To(api.keystoneRevoke).
+			Doc("Revokes a token").
+			Param(restful.HeaderParameter(
+				"X-Auth-Token", "A valid authentication token for an administrative user.")).
+			Param(restful.HeaderParameter(
+				"X-Subject-Token", "The authentication token.")))
+
 	ws.
 		Route(ws.HEAD(path).
 			To(api.keystoneCheck).
@@ -100,21 +117,40 @@ func (api *API) keystoneAuthenticate(request *restful.Request, response *restful
 		login = user.Name
 	}
 
-	claims, err := api.authenticate(login, user.Password)
-	if err == ErrInvalidAuthentication {
+	info := api.requestInfo(request, "keystone")
+	info.Domain = user.Domain.Name
+	if info.Domain == "" {
+		info.Domain = user.Domain.ID
+	}
+
+	for _, method := range authReq.Auth.Identity.Methods {
+		if method == "totp" {
+			info.OTP = authReq.Auth.Identity.TOTP.User.Passcode
+		}
+	}
+
+	claims, err := api.authenticate(request.Request.Context(), login, user.Password, info)
+	lockedOut := &LockedOutError{}
+	if errors.As(err, &lockedOut) {
+		writeLockedOut(response, lockedOut)
+		return
+	} else if errors.Is(err, ErrOTPRequired) {
+		response.WriteErrorString(http.StatusUnauthorized, "TOTP method required")
+		return
+	} else if errors.Is(err, ErrInvalidAuthentication) {
 		response.WriteErrorString(http.StatusUnauthorized, "Authentication failed")
 		return
 	} else if err != nil {
 		panic(err)
 	}
 
-	_, tokenString, err := api.createToken(login, claims)
+	_, tokenString, err := api.createToken(request.Request.Context(), login, claims)
 
 	if err != nil {
 		panic(err)
 	}
 
-	stdClaims, err := api.checkToken(tokenString)
+	stdClaims, err := api.checkToken(request.Request.Context(), tokenString)
 	if err != nil {
 		panic(err)
 	}
@@ -150,16 +186,44 @@ func (api *API) keystoneShow(request *restful.Request, response *restful.Respons
 	response.WriteHeaderAndEntity(http.StatusOK, newKeystoneAuthRespFromClaims(claims))
 }
 
+func (api *API) keystoneRevoke(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			WriteError(err.(error), response)
+		}
+	}()
+
+	if api.Store == nil {
+		response.WriteErrorString(http.StatusNotFound, "Revocation is not enabled.\n")
+		return
+	}
+
+	claims := api.keystoneCheckClaims(request, response)
+
+	if claims == nil {
+		return
+	}
+
+	if err := api.revokeClaims(request.Request.Context(), claims); err != nil {
+		panic(err)
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
 // return nil iff check fails (response already filled)
 func (api *API) keystoneCheckClaims(request *restful.Request, response *restful.Response) *auth.Claims {
+	ctx := request.Request.Context()
+
 	authToken := request.HeaderParameter("X-Auth-Token")
-	if _, err := api.checkToken(authToken); err != nil {
+	if _, err := api.checkToken(ctx, authToken); err != nil {
 		response.WriteError(http.StatusUnauthorized, err)
 		return nil
 	}
 
 	subjectToken := request.HeaderParameter("X-Subject-Token")
-	claims, err := api.checkToken(subjectToken)
+	claims, err := api.checkToken(ctx, subjectToken)
 	if err != nil {
 		response.WriteError(http.StatusBadRequest, err)
 		return nil
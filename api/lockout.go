@@ -0,0 +1,292 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/isi-nc/autentigo/pkg/store"
+)
+
+// LockoutPolicy is the brute-force protection configuration. After the
+// threshold of failures, every new failure locks the user (or IP) out for a
+// delay doubling each time, up to MaxDelay.
+type LockoutPolicy struct {
+	// UserThreshold is the number of failures allowed per user (0 disables the user lockout).
+	UserThreshold int
+	// IPThreshold is the number of failures allowed per source IP (0 disables the IP lockout).
+	IPThreshold int
+	// Delay is the first lockout delay.
+	Delay time.Duration
+	// MaxDelay caps the lockout delay.
+	MaxDelay time.Duration
+	// Window is the time after which failures are forgotten, if there is no new one.
+	Window time.Duration
+}
+
+// LockedOutError indicates an authentication refused because of too many failures.
+type LockedOutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedOutError) Error() string {
+	return fmt.Sprintf("too many failed authentications, retry after %v", e.RetryAfter)
+}
+
+// Lockout is the failure state of a user or IP.
+type Lockout struct {
+	// Kind is "user" or "ip".
+	Kind        string     `json:"kind"`
+	Key         string     `json:"key"`
+	Failures    int        `json:"failures"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+// LockoutList is the list of users and IPs with recent failures.
+type LockoutList struct {
+	Lockouts []Lockout `json:"lockouts"`
+}
+
+type lockoutState struct {
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+const lockoutPrefix = "lockout/"
+
+func (api *API) registerLockouts(ws *restful.WebService) {
+	ws.
+		Route(ws.GET("/admin/lockouts").
+			To(api.listLockouts).
+			Filter(api.requireAdmin).
+			Doc("List users and IPs with recent authentication failures (requires the admin token)").
+			Param(restful.HeaderParameter("Authorization", "Bearer <admin token>")).
+			Produces("application/json").
+			Writes(LockoutList{}))
+
+	ws.
+		Route(ws.DELETE("/admin/lockouts/{kind}/{key}").
+			To(api.clearLockout).
+			Filter(api.requireAdmin).
+			Doc("Clear the failures of a user or IP (requires the admin token)").
+			Param(restful.HeaderParameter("Authorization", "Bearer <admin token>")).
+			Param(ws.PathParameter("kind", "user or ip")).
+			Param(ws.PathParameter("key", "the user name or IP")))
+}
+
+func (api *API) lockoutEnabled() bool {
+	return api.Store != nil && (api.Lockout.UserThreshold != 0 || api.Lockout.IPThreshold != 0)
+}
+
+// checkLockout returns a LockedOutError if the user or the IP is locked out.
+func (api *API) checkLockout(ctx context.Context, user string, info RequestInfo) error {
+	if !api.lockoutEnabled() {
+		return nil
+	}
+
+	var retryAfter time.Duration
+
+	for _, key := range api.lockoutKeys(user, info) {
+		state, _, err := api.getLockout(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		if d := time.Until(state.LockedUntil); d > retryAfter {
+			retryAfter = d
+		}
+	}
+
+	if retryAfter > 0 {
+		return &LockedOutError{RetryAfter: retryAfter}
+	}
+
+	return nil
+}
+
+// authenticationFailed counts a failure for the user and the IP. Failures of
+// unknown users (ErrUnknownUser) only count for the IP: anyone could lock
+// names out, and fill the store with random ones.
+func (api *API) authenticationFailed(ctx context.Context, user string, info RequestInfo, failure error) error {
+	if !api.lockoutEnabled() {
+		return nil
+	}
+
+	for _, key := range api.lockoutKeys(user, info) {
+		threshold := api.Lockout.UserThreshold
+		if strings.HasPrefix(key, lockoutPrefix+"ip/") {
+			threshold = api.Lockout.IPThreshold
+		} else if errors.Is(failure, ErrUnknownUser) {
+			continue
+		}
+
+		if err := api.addFailure(ctx, key, threshold); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// authenticationSucceeded forgets the user's failures. The IP's failures are
+// kept, they may be for other users.
+func (api *API) authenticationSucceeded(ctx context.Context, user string) error {
+	if !api.lockoutEnabled() || api.Lockout.UserThreshold == 0 {
+		return nil
+	}
+
+	return api.Store.Delete(ctx, lockoutKey("user", user))
+}
+
+func (api *API) lockoutKeys(user string, info RequestInfo) (keys []string) {
+	if api.Lockout.UserThreshold != 0 {
+		keys = append(keys, lockoutKey("user", user))
+	}
+
+	if ip := info.clientIP(); api.Lockout.IPThreshold != 0 && ip != "" {
+		keys = append(keys, lockoutKey("ip", ip))
+	}
+
+	return
+}
+
+func lockoutKey(kind, key string) string {
+	return lockoutPrefix + kind + "/" + url.PathEscape(key)
+}
+
+func (api *API) getLockout(ctx context.Context, key string) (state lockoutState, raw []byte, err error) {
+	raw, err = api.Store.Get(ctx, key)
+	if err == store.ErrNotFound {
+		return lockoutState{}, nil, nil
+	} else if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(raw, &state)
+	return
+}
+
+func (api *API) addFailure(ctx context.Context, key string, threshold int) error {
+	// retry on concurrent updates
+	for attempt := 0; ; attempt++ {
+		state, raw, err := api.getLockout(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		state.Failures++
+
+		ttl := api.Lockout.Window
+		if excess := state.Failures - threshold; excess > 0 {
+			delay := time.Duration(float64(api.Lockout.Delay) * math.Pow(2, float64(excess-1)))
+			if delay > api.Lockout.MaxDelay || delay <= 0 {
+				delay = api.Lockout.MaxDelay
+			}
+
+			state.LockedUntil = time.Now().Add(delay)
+			if delay > ttl {
+				ttl = delay
+			}
+		}
+
+		value, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+
+		if raw == nil {
+			err = api.Store.Create(ctx, key, value, ttl)
+		} else {
+			err = api.Store.Swap(ctx, key, raw, value, ttl)
+		}
+
+		if (err == store.ErrExists || err == store.ErrConflict) && attempt < 10 {
+			continue
+		}
+
+		return err
+	}
+}
+
+// writeLockedOut answers a locked out authentication.
+func writeLockedOut(response *restful.Response, err *LockedOutError) {
+	response.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))))
+	response.WriteErrorString(http.StatusTooManyRequests, "Too many failed authentications.\n")
+}
+
+func (api *API) listLockouts(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			WriteError(err.(error), response)
+		}
+	}()
+
+	list := LockoutList{Lockouts: []Lockout{}}
+
+	if api.Store != nil {
+		values, err := api.Store.List(request.Request.Context(), lockoutPrefix)
+		if err != nil {
+			panic(err)
+		}
+
+		for key, value := range values {
+			parts := strings.SplitN(strings.TrimPrefix(key, lockoutPrefix), "/", 2)
+			if len(parts) != 2 {
+				continue
+			}
+
+			name, err := url.PathUnescape(parts[1])
+			if err != nil {
+				continue
+			}
+
+			state := lockoutState{}
+			if err := json.Unmarshal(value, &state); err != nil {
+				panic(err)
+			}
+
+			lockout := Lockout{Kind: parts[0], Key: name, Failures: state.Failures}
+			if !state.LockedUntil.IsZero() {
+				lockedUntil := state.LockedUntil.UTC()
+				lockout.LockedUntil = &lockedUntil
+			}
+
+			list.Lockouts = append(list.Lockouts, lockout)
+		}
+	}
+
+	response.WriteEntity(list)
+}
+
+func (api *API) clearLockout(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			WriteError(err.(error), response)
+		}
+	}()
+
+	kind := request.PathParameter("kind")
+	if kind != "user" && kind != "ip" {
+		response.WriteErrorString(http.StatusBadRequest, "kind must be user or ip.\n")
+		return
+	}
+
+	if api.Store != nil {
+		key := lockoutKey(kind, request.PathParameter("key"))
+		if err := api.Store.Delete(request.Request.Context(), key); err != nil {
+			panic(err)
+		}
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/isi-nc/autentigo/pkg/ratelimit"
+	"github.com/isi-nc/autentigo/pkg/store"
+)
+
+func TestUnknownUsersAreNotLockedOut(t *testing.T) {
+	api := &API{
+		Store: store.NewMemory(),
+		Lockout: LockoutPolicy{
+			UserThreshold: 1,
+			Delay:         time.Minute,
+			MaxDelay:      time.Hour,
+			Window:        time.Hour,
+		},
+	}
+	ctx := context.Background()
+	info := RequestInfo{RemoteAddr: "192.0.2.1:1234"}
+
+	for i := 0; i < 3; i++ {
+		if err := api.authenticationFailed(ctx, "nobody", info, ErrUnknownUser); err != nil {
+			t.Fatal(err)
+		}
+		if err := api.authenticationFailed(ctx, "alice", info, ErrInvalidAuthentication); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := api.checkLockout(ctx, "nobody", info); err != nil {
+		t.Errorf("unknown users should not be locked out, got %v", err)
+	}
+
+	lockedOut := &LockedOutError{}
+	if err := api.checkLockout(ctx, "alice", info); !errors.As(err, &lockedOut) {
+		t.Errorf("alice should be locked out, got %v", err)
+	}
+
+	if values, err := api.Store.List(ctx, lockoutPrefix); err != nil {
+		t.Fatal(err)
+	} else if len(values) != 1 {
+		t.Errorf("expected only alice's failures in the store, got %d entries", len(values))
+	}
+}
+
+func newLockoutTestAPI(t *testing.T) (*API, http.Handler) {
+	api, handler := newAuthorizeTestAPI(t)
+	api.AdminToken = "admin"
+	api.Lockout = LockoutPolicy{
+		UserThreshold: 2,
+		Delay:         time.Minute,
+		MaxDelay:      3 * time.Minute,
+		Window:        time.Hour,
+	}
+	return api, handler
+}
+
+func simpleLogin(handler http.Handler, password string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(AuthReq{User: "alice", Password: password})
+
+	req := httptest.NewRequest(http.MethodPost, "/simple", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLockoutDelay(t *testing.T) {
+	api, _ := newLockoutTestAPI(t)
+	ctx := context.Background()
+
+	// the delay doubles from the first failure past the threshold, up to MaxDelay
+	for i, expected := range []time.Duration{0, 0, time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		if err := api.authenticationFailed(ctx, "alice", RequestInfo{}, ErrInvalidAuthentication); err != nil {
+			t.Fatal(err)
+		}
+
+		state, _, err := api.getLockout(ctx, lockoutKey("user", "alice"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if state.Failures != i+1 {
+			t.Errorf("failure %d: expected %d failures, got %d", i+1, i+1, state.Failures)
+		}
+
+		delay := time.Until(state.LockedUntil)
+		if expected == 0 && !state.LockedUntil.IsZero() || expected != 0 && (delay > expected || delay < expected-time.Second) {
+			t.Errorf("failure %d: expected a %v delay, got %v", i+1, expected, delay)
+		}
+	}
+}
+
+func TestLockedOutResponses(t *testing.T) {
+	api, handler := newLockoutTestAPI(t)
+
+	for i := 0; i < 3; i++ {
+		if rec := simpleLogin(handler, "bad"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: expected 401, got %d", i+1, rec.Code)
+		}
+	}
+
+	// even the right password is refused, without calling the backend
+	rec := simpleLogin(handler, "ok")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("simple: expected 429, got %d", rec.Code)
+	}
+	if retryAfter, _ := strconv.Atoi(rec.Header().Get("Retry-After")); retryAfter < 59 || retryAfter > 60 {
+		t.Errorf("simple: expected a Retry-After of 60s, got %q", rec.Header().Get("Retry-After"))
+	}
+
+	keystoneReq := KeystoneAuthReq{Auth: &KeystoneAuth{}}
+	keystoneReq.Auth.Identity.Methods = []string{"password"}
+	keystoneReq.Auth.Identity.Password.User.Name = "alice"
+	keystoneReq.Auth.Identity.Password.User.Password = "ok"
+	body, _ := json.Marshal(keystoneReq)
+
+	req := httptest.NewRequest(http.MethodPost, "/v3/auth/tokens", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("keystone: expected 429 with Retry-After, got %d", rec.Code)
+	}
+
+	// other users are not affected
+	if err := api.checkLockout(context.Background(), "bob", RequestInfo{}); err != nil {
+		t.Errorf("bob should not be locked out, got %v", err)
+	}
+}
+
+func TestSuccessClearsFailures(t *testing.T) {
+	api, handler := newLockoutTestAPI(t)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		simpleLogin(handler, "bad")
+	}
+
+	if rec := simpleLogin(handler, "ok"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 below the threshold, got %d", rec.Code)
+	}
+
+	if _, raw, err := api.getLockout(ctx, lockoutKey("user", "alice")); err != nil {
+		t.Fatal(err)
+	} else if raw != nil {
+		t.Error("a success should clear the user's failures")
+	}
+
+	// the threshold is available again
+	for i := 0; i < 2; i++ {
+		simpleLogin(handler, "bad")
+	}
+	if err := api.checkLockout(ctx, "alice", RequestInfo{}); err != nil {
+		t.Errorf("alice should not be locked out, got %v", err)
+	}
+}
+
+func TestLockoutIPBehindProxy(t *testing.T) {
+	api, handler := newLockoutTestAPI(t)
+	api.Lockout.UserThreshold = 0
+	api.Lockout.IPThreshold = 1
+
+	limiter, err := ratelimit.New(&ratelimit.Config{TrustedProxies: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.ClientIP = limiter.ClientIP
+
+	login := func(forwardedFor string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(AuthReq{User: "alice", Password: "bad"})
+
+		req := httptest.NewRequest(http.MethodPost, "/simple", bytes.NewReader(body))
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		login("192.0.2.7")
+	}
+
+	if rec := login("192.0.2.7"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("the client behind the proxy should be locked out, got %d", rec.Code)
+	}
+
+	// the proxy itself, and the other clients behind it, are not locked out
+	if rec := login("192.0.2.8"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("another client behind the proxy should not be locked out, got %d", rec.Code)
+	}
+
+	if _, raw, err := api.getLockout(context.Background(), lockoutKey("ip", "10.0.0.1")); err != nil {
+		t.Fatal(err)
+	} else if raw != nil {
+		t.Error("failures should not be counted for the proxy")
+	}
+}
+
+func TestLockoutAdmin(t *testing.T) {
+	_, handler := newLockoutTestAPI(t)
+
+	for i := 0; i < 3; i++ {
+		simpleLogin(handler, "bad")
+	}
+
+	admin := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := admin(http.MethodGet, "/admin/lockouts", "bad"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("list with a bad token: expected 401, got %d", rec.Code)
+	}
+
+	rec := admin(http.MethodGet, "/admin/lockouts", "admin")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	list := LockoutList{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list.Lockouts) != 1 {
+		t.Fatalf("expected alice's lockout, got %+v", list.Lockouts)
+	}
+	if l := list.Lockouts[0]; l.Kind != "user" || l.Key != "alice" || l.Failures != 3 || l.LockedUntil == nil {
+		t.Errorf("unexpected lockout: %+v", l)
+	}
+
+	if rec := admin(http.MethodDelete, "/admin/lockouts/group/alice", "admin"); rec.Code != http.StatusBadRequest {
+		t.Errorf("clear of an unknown kind: expected 400, got %d", rec.Code)
+	}
+
+	if rec := admin(http.MethodDelete, "/admin/lockouts/user/alice", "admin"); rec.Code != http.StatusNoContent {
+		t.Fatalf("clear: expected 204, got %d", rec.Code)
+	}
+
+	if rec := simpleLogin(handler, "ok"); rec.Code != http.StatusOK {
+		t.Errorf("alice should be able to log in once cleared, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/isi-nc/autentigo/auth"
+	"github.com/isi-nc/autentigo/pkg/certmap"
+)
+
+func (api *API) registerMTLS(ws *restful.WebService) {
+	ws.
+		Route(ws.GET("/mtls").
+			To(api.mtlsAuthenticate).
+			Doc("Authenticate with the client certificate of the TLS connection").
+			Param(setCookieHeader()).
+			Param(setCookieDomainHeader()).
+			Param(setCookieInsecureHeader()).
+			Param(ws.QueryParameter("audience", "Restrict the token to this audience")).
+			Produces("application/json").
+			Writes(AuthResponse{}))
+}
+
+func (api *API) mtlsAuthenticate(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			WriteError(err.(error), response)
+		}
+	}()
+
+	if api.ClientCertificates == nil {
+		response.WriteErrorString(http.StatusNotFound, "Client certificate authentication is not enabled.\n")
+		return
+	}
+
+	// certificates are verified by the TLS listener
+	state := request.Request.TLS
+	if state == nil || len(state.VerifiedChains) == 0 {
+		response.WriteErrorString(http.StatusUnauthorized, "Client certificate required.\n")
+		return
+	}
+
+	info := api.requestInfo(request, "mtls")
+	info.Audience = request.QueryParameter("audience")
+
+	user, claims, err := api.certificateAuthenticate(request.Request.Context(), state.VerifiedChains[0][0], info)
+	if errors.Is(err, ErrInvalidAuthentication) {
+		response.WriteErrorString(http.StatusUnauthorized, "Authentication failed.\n")
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	api.writeToken(request, response, user, claims, info)
+}
+
+// certificateAuthenticate maps a verified client certificate to the user and the token's claims.
+func (api *API) certificateAuthenticate(ctx context.Context, cert *x509.Certificate, info RequestInfo) (user string, claims jwt.MapClaims, err error) {
+	config := api.ClientCertificates
+
+	user, err = config.MapSubject(cert)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrInvalidAuthentication, err)
+	}
+
+	groups := config.MapGroups(cert)
+	if config.LookupGroups() {
+		lookup, ok := api.Authenticator.(GroupsLookup)
+		if !ok {
+			return "", nil, errors.New("the authenticator can't look up groups")
+		}
+
+		// unknown users are refused
+		if groups, err = lookup.LookupGroups(ctx, user); err != nil {
+			return "", nil, err
+		}
+	}
+
+	now := time.Now()
+	c := auth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(api.TokenDuration).Unix(),
+			Subject:   user,
+		},
+	}
+	c.Groups = groups
+
+	m, err := auth.ToMap(c)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// the token is bound to the certificate (RFC 8705)
+	m["cnf"] = map[string]interface{}{"x5t#S256": certmap.Thumbprint(cert)}
+
+	claims, err = api.tokenClaims(m, info)
+	return
+}
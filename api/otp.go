@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/isi-nc/autentigo/pkg/store"
+	"github.com/isi-nc/autentigo/pkg/totp"
+)
+
+// OTPMethods are the authentication methods (amr claim) of users authenticated with a TOTP.
+var OTPMethods = []string{"pwd", "otp", "mfa"}
+
+// CheckOTP is for backends storing TOTP secrets, once the password is checked.
+// Users without secret need no one-time password (nil methods are returned),
+// others must give a valid one (ErrOTPRequired or ErrInvalidAuthentication
+// otherwise), and get the OTPMethods.
+func CheckOTP(secret string, req RequestInfo) (amr []string, err error) {
+	if secret == "" {
+		return nil, nil
+	}
+
+	if req.OTP == "" {
+		return nil, ErrOTPRequired
+	}
+
+	if !totp.Validate(secret, req.OTP, time.Now()) {
+		return nil, ErrInvalidAuthentication
+	}
+
+	return OTPMethods, nil
+}
+
+// checkOTPReplay rejects one-time passwords already used by the user.
+func (api *API) checkOTPReplay(ctx context.Context, user, otp string) error {
+	if api.Store == nil {
+		return nil
+	}
+
+	// a code is valid for the current step and the skewed ones
+	ttl := time.Duration(2*totp.Skew+1) * totp.Period
+
+	key := "otp/" + url.PathEscape(user) + "/" + url.PathEscape(otp)
+	err := api.Store.Create(ctx, key, []byte{}, ttl)
+	if err == store.ErrExists {
+		return ErrInvalidAuthentication
+	}
+
+	return err
+}
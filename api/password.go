@@ -0,0 +1,36 @@
+package api
+
+import (
+	"errors"
+	"log"
+
+	"github.com/isi-nc/autentigo/pkg/passhash"
+)
+
+// CheckPassword is for backends storing password hashes (see passhash.Check).
+// Wrong passwords give ErrInvalidAuthentication, and so do hashes of unknown
+// schemes or malformed ones, after logging them: a bad entry must not be
+// reported as a server error, nor tell its user apart. newHash is the password
+// hashed with the rehash scheme, to store instead of the current hash, if any.
+func CheckPassword(user, hash, password, rehash string) (newHash string, err error) {
+	ok, newHash, err := passhash.Check(hash, password, rehash)
+
+	switch {
+	case errors.Is(err, passhash.ErrUnknownScheme) || errors.Is(err, passhash.ErrMalformed):
+		if ok {
+			// the password matches, only the rehash failed
+			log.Printf("failed to rehash the password of %s: %v", user, err)
+			return "", nil
+		}
+		log.Printf("invalid password hash for %s: %v", user, err)
+		return "", ErrInvalidAuthentication
+
+	case err != nil:
+		return "", err
+
+	case !ok:
+		return "", ErrInvalidAuthentication
+	}
+
+	return newHash, nil
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/isi-nc/autentigo/pkg/passhash"
+)
+
+func TestCheckPassword(t *testing.T) {
+	legacy, err := passhash.Hash(passhash.SHA256, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		hash     string
+		password string
+		rehash   string
+		err      error
+		newHash  bool
+	}{
+		{name: "valid", hash: legacy, password: "ok"},
+		{name: "rehash", hash: legacy, password: "ok", rehash: passhash.SSHA, newHash: true},
+		{name: "wrong password", hash: legacy, password: "bad", err: ErrInvalidAuthentication},
+		{name: "unknown scheme", hash: "{NOPE}abc", password: "ok", err: ErrInvalidAuthentication},
+		{name: "malformed", hash: "{BCRYPT}abc", password: "ok", err: ErrInvalidAuthentication},
+		{name: "rehash failure", hash: legacy, password: "ok", rehash: "NOPE"},
+	} {
+		newHash, err := CheckPassword("alice", test.hash, test.password, test.rehash)
+		if err != test.err {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+		if (newHash != "") != test.newHash {
+			t.Errorf("%s: unexpected new hash %q", test.name, newHash)
+		}
+	}
+}
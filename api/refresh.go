@@ -0,0 +1,283 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/isi-nc/autentigo/pkg/store"
+)
+
+var (
+	// ErrInvalidRefreshToken indicates an unknown, expired or revoked refresh token.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+)
+
+// RefreshReq is a refresh token request
+type RefreshReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// refreshFamily is the state shared by the refresh tokens rotated from the same login.
+type refreshFamily struct {
+	Subject string        `json:"sub"`
+	Claims  jwt.MapClaims `json:"claims"`
+	Revoked bool          `json:"revoked,omitempty"`
+}
+
+// refreshTokenState is the state of a single refresh token, stored by hash.
+type refreshTokenState struct {
+	Family string `json:"family"`
+	Spent  bool   `json:"spent,omitempty"`
+}
+
+func (api *API) registerRefresh(ws *restful.WebService) {
+	ws.
+		Route(ws.POST("/refresh").
+			To(api.refresh).
+			Doc("Exchange a refresh token for a new token (and a new refresh token)").
+			Consumes("application/json").
+			Produces("application/json").
+			Reads(RefreshReq{}).
+			Writes(AuthResponse{}))
+}
+
+func (api *API) refreshEnabled() bool {
+	return api.Store != nil && api.RefreshTokenDuration != 0
+}
+
+func (api *API) refresh(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			WriteError(err.(error), response)
+		}
+	}()
+
+	if !api.refreshEnabled() {
+		response.WriteErrorString(http.StatusNotFound, "Refresh tokens are not enabled.\n")
+		return
+	}
+
+	req := RefreshReq{}
+	if err := request.ReadEntity(&req); err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+
+	ctx := request.Request.Context()
+
+	familyID, family, err := api.spendRefreshToken(ctx, req.RefreshToken)
+	if err == ErrInvalidRefreshToken {
+		response.WriteErrorString(http.StatusUnauthorized, "Invalid refresh token.\n")
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	now := time.Now()
+
+	claims := jwt.MapClaims{}
+	for k, v := range family.Claims {
+		claims[k] = v
+	}
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(api.TokenDuration).Unix()
+
+	stamped, err := api.stamp(claims)
+	if err != nil {
+		panic(err)
+	}
+
+	// the family may have been revoked meanwhile
+	refreshToken, err := api.newRefreshToken(ctx, familyID)
+	if err == ErrInvalidRefreshToken {
+		response.WriteErrorString(http.StatusUnauthorized, "Invalid refresh token.\n")
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	_, tokenString, err := api.createToken(ctx, family.Subject, stamped)
+	if err != nil {
+		panic(err)
+	}
+
+	response.WriteEntity(&AuthResponse{
+		Token:        tokenString,
+		Claims:       stamped,
+		RefreshToken: refreshToken,
+	})
+}
+
+// startRefreshFamily creates a new refresh token family for a successful login.
+func (api *API) startRefreshFamily(ctx context.Context, user string, claims jwt.MapClaims) (refreshToken string, err error) {
+	familyID, err := randomString(16)
+	if err != nil {
+		return
+	}
+
+	family := refreshFamily{
+		Subject: user,
+		Claims:  claims,
+	}
+
+	if err = api.putRefreshState(ctx, api.Store.Create, "refresh/families/"+familyID, family); err != nil {
+		return
+	}
+
+	return api.newRefreshToken(ctx, familyID)
+}
+
+// newRefreshToken issues a new refresh token in the family, and extends the family's lifetime.
+func (api *API) newRefreshToken(ctx context.Context, familyID string) (refreshToken string, err error) {
+	familyKey := "refresh/families/" + familyID
+
+	ba, err := api.Store.Get(ctx, familyKey)
+	if err == store.ErrNotFound {
+		return "", ErrInvalidRefreshToken
+	} else if err != nil {
+		return
+	}
+
+	family := refreshFamily{}
+	if err = json.Unmarshal(ba, &family); err != nil {
+		return
+	}
+
+	if family.Revoked {
+		return "", ErrInvalidRefreshToken
+	}
+
+	// swap, not put: a concurrent revocation must not be overwritten
+	if err = api.Store.Swap(ctx, familyKey, ba, ba, api.RefreshTokenDuration); err == store.ErrConflict {
+		return "", ErrInvalidRefreshToken
+	} else if err != nil {
+		return
+	}
+
+	if refreshToken, err = randomString(32); err != nil {
+		return
+	}
+
+	err = api.putRefreshState(ctx, api.Store.Create, refreshTokenKey(refreshToken), refreshTokenState{Family: familyID})
+	return
+}
+
+// spendRefreshToken marks the refresh token as used and returns its family.
+// Using an already spent token revokes its whole family.
+func (api *API) spendRefreshToken(ctx context.Context, refreshToken string) (familyID string, family *refreshFamily, err error) {
+	if refreshToken == "" {
+		return "", nil, ErrInvalidRefreshToken
+	}
+
+	key := refreshTokenKey(refreshToken)
+
+	ba, err := api.Store.Get(ctx, key)
+	if err == store.ErrNotFound {
+		return "", nil, ErrInvalidRefreshToken
+	} else if err != nil {
+		return
+	}
+
+	state := refreshTokenState{}
+	if err = json.Unmarshal(ba, &state); err != nil {
+		return
+	}
+
+	familyID = state.Family
+	family = &refreshFamily{}
+
+	familyBa, err := api.Store.Get(ctx, "refresh/families/"+familyID)
+	if err == store.ErrNotFound {
+		return "", nil, ErrInvalidRefreshToken
+	} else if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(familyBa, family); err != nil {
+		return
+	}
+
+	if family.Revoked {
+		return "", nil, ErrInvalidRefreshToken
+	}
+
+	if state.Spent {
+		log.Printf("refresh token reuse detected for %s, revoking its family", family.Subject)
+		if err = api.revokeRefreshFamily(ctx, familyID); err != nil {
+			return
+		}
+		return "", nil, ErrInvalidRefreshToken
+	}
+
+	state.Spent = true
+	spent, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+
+	if err = api.Store.Swap(ctx, key, ba, spent, api.RefreshTokenDuration); err == store.ErrConflict {
+		// concurrent use of the same token
+		log.Printf("concurrent refresh token use for %s, revoking its family", family.Subject)
+		if err = api.revokeRefreshFamily(ctx, familyID); err != nil {
+			return
+		}
+		return "", nil, ErrInvalidRefreshToken
+	} else if err != nil {
+		return
+	}
+
+	return
+}
+
+func (api *API) revokeRefreshFamily(ctx context.Context, familyID string) error {
+	key := "refresh/families/" + familyID
+
+	ba, err := api.Store.Get(ctx, key)
+	if err == store.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	family := refreshFamily{}
+	if err := json.Unmarshal(ba, &family); err != nil {
+		return err
+	}
+
+	family.Revoked = true
+
+	return api.putRefreshState(ctx, api.Store.Put, key, family)
+}
+
+func (api *API) putRefreshState(ctx context.Context, put func(context.Context, string, []byte, time.Duration) error, key string, v interface{}) error {
+	ba, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return put(ctx, key, ba, api.RefreshTokenDuration)
+}
+
+func refreshTokenKey(refreshToken string) string {
+	h := sha256.Sum256([]byte(refreshToken))
+	return "refresh/tokens/" + hex.EncodeToString(h[:])
+}
+
+func randomString(size int) (string, error) {
+	ba := make([]byte, size)
+	if _, err := rand.Read(ba); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(ba), nil
+}
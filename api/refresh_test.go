@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/isi-nc/autentigo/pkg/store"
+)
+
+func TestRevokedFamilyIsNotExtended(t *testing.T) {
+	api := &API{Store: store.NewMemory(), RefreshTokenDuration: time.Hour}
+	ctx := context.Background()
+
+	refreshToken, err := api.startRefreshFamily(ctx, "alice", jwt.MapClaims{"sub": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	familyID, _, err := api.spendRefreshToken(ctx, refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reuse detected while the token is being rotated
+	if err = api.revokeRefreshFamily(ctx, familyID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = api.newRefreshToken(ctx, familyID); err != ErrInvalidRefreshToken {
+		t.Fatalf("rotating in a revoked family should fail with ErrInvalidRefreshToken, got %v", err)
+	}
+
+	_, family, err := api.spendRefreshToken(ctx, refreshToken)
+	if err != ErrInvalidRefreshToken || family != nil {
+		t.Errorf("the family should stay revoked, got %v", err)
+	}
+}
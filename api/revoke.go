@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/isi-nc/autentigo/auth"
+	"github.com/isi-nc/autentigo/pkg/store"
+)
+
+var (
+	// ErrTokenRevoked indicates a token revoked before its expiration.
+	ErrTokenRevoked = errors.New("token revoked")
+)
+
+// Revocation is an entry of the revocation feed
+type Revocation struct {
+	ID        string `json:"jti"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// RevocationList is the revocation feed, listing revoked and not yet expired tokens
+type RevocationList struct {
+	Revocations []Revocation `json:"revocations"`
+}
+
+const revokedPrefix = "revoked/"
+
+func (api *API) registerRevoke(ws *restful.WebService) {
+	ws.
+		Route(ws.POST("/revoke").
+			To(api.revoke).
+			Doc("Revoke a token or a refresh token (RFC 7009)").
+			Consumes("application/x-www-form-urlencoded").
+			Param(ws.FormParameter("token", "The token to revoke").Required(true)).
+			Param(ws.FormParameter("token_type_hint", "access_token or refresh_token")))
+
+	ws.
+		Route(ws.GET("/revocations").
+			To(api.revocations).
+			Doc("List revoked tokens that are not expired yet, for offline validators").
+			Produces("application/json").
+			Writes(RevocationList{}))
+}
+
+func (api *API) revoke(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			WriteError(err.(error), response)
+		}
+	}()
+
+	if api.Store == nil {
+		response.WriteErrorString(http.StatusNotFound, "Revocation is not enabled.\n")
+		return
+	}
+
+	token := request.Request.PostFormValue("token")
+	if token == "" {
+		response.WriteErrorString(http.StatusBadRequest, "No token given.\n")
+		return
+	}
+
+	ctx := request.Request.Context()
+
+	var err error
+	switch hint := request.Request.PostFormValue("token_type_hint"); hint {
+	case "refresh_token":
+		err = api.revokeRefreshToken(ctx, token)
+	case "access_token":
+		err = api.revokeToken(ctx, token)
+	default:
+		// a JWT has dots, our refresh tokens don't
+		if strings.Contains(token, ".") {
+			err = api.revokeToken(ctx, token)
+		} else {
+			err = api.revokeRefreshToken(ctx, token)
+		}
+	}
+
+	if err != nil {
+		panic(err)
+	}
+
+	// invalid tokens also get a 200 response, as stated by RFC 7009
+	response.WriteHeader(http.StatusOK)
+}
+
+func (api *API) revocations(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			WriteError(err.(error), response)
+		}
+	}()
+
+	list := RevocationList{Revocations: []Revocation{}}
+
+	if api.Store != nil {
+		values, err := api.Store.List(request.Request.Context(), revokedPrefix)
+		if err != nil {
+			panic(err)
+		}
+
+		for _, v := range values {
+			r := Revocation{}
+			if err := json.Unmarshal(v, &r); err != nil {
+				panic(err)
+			}
+			list.Revocations = append(list.Revocations, r)
+		}
+	}
+
+	response.WriteEntity(list)
+}
+
+// revokeToken revokes a valid token. Invalid tokens are ignored.
+func (api *API) revokeToken(ctx context.Context, tokenString string) error {
+	claims, err := api.parseToken(ctx, tokenString)
+	if err != nil {
+		return nil
+	}
+
+	return api.revokeClaims(ctx, claims)
+}
+
+func (api *API) revokeClaims(ctx context.Context, claims *auth.Claims) error {
+	if claims.Id == "" {
+		return errors.New("can't revoke a token without jti")
+	}
+
+	ba, err := json.Marshal(Revocation{ID: claims.Id, ExpiresAt: claims.ExpiresAt})
+	if err != nil {
+		return err
+	}
+
+	// keep the entry until the token expires anyway
+	ttl := time.Until(time.Unix(claims.ExpiresAt, 0)) + time.Minute
+
+	return api.Store.Put(ctx, revokedPrefix+claims.Id, ba, ttl)
+}
+
+// revokeRefreshToken revokes the family of a refresh token. Invalid tokens are ignored.
+func (api *API) revokeRefreshToken(ctx context.Context, refreshToken string) error {
+	ba, err := api.Store.Get(ctx, refreshTokenKey(refreshToken))
+	if err == store.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	state := refreshTokenState{}
+	if err := json.Unmarshal(ba, &state); err != nil {
+		return err
+	}
+
+	return api.revokeRefreshFamily(ctx, state.Family)
+}
+
+func (api *API) isRevoked(ctx context.Context, claims *auth.Claims) (bool, error) {
+	if api.Store == nil || claims.Id == "" {
+		return false, nil
+	}
+
+	_, err := api.Store.Get(ctx, revokedPrefix+claims.Id)
+	if err == store.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func newTokenID() (string, error) {
+	return randomString(16)
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// issueToken returns a token of alice, and its jti.
+func issueToken(t *testing.T, api *API) (tokenString, jti string) {
+	claims, err := api.authenticate(context.Background(), "alice", "ok", RequestInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, tokenString, err = api.createToken(context.Background(), "alice", claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tokenString, claims["jti"].(string)
+}
+
+func listRevocations(t *testing.T, handler http.Handler) RevocationList {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/revocations", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("revocations: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	list := RevocationList{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	api, handler := newAuthorizeTestAPI(t)
+
+	token, jti := issueToken(t, api)
+	other, _ := issueToken(t, api)
+
+	if list := listRevocations(t, handler); len(list.Revocations) != 0 {
+		t.Errorf("expected no revocation, got %v", list.Revocations)
+	}
+
+	if rec := postForm(handler, "/revoke", url.Values{"token": {token}}); rec.Code != http.StatusOK {
+		t.Fatalf("revoke: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if _, err := api.checkToken(context.Background(), token); err != ErrTokenRevoked {
+		t.Errorf("the revoked token should be refused with ErrTokenRevoked, got %v", err)
+	}
+	if _, err := api.checkToken(context.Background(), other); err != nil {
+		t.Errorf("other tokens should still be valid: %v", err)
+	}
+
+	list := listRevocations(t, handler)
+	if len(list.Revocations) != 1 || list.Revocations[0].ID != jti || list.Revocations[0].ExpiresAt == 0 {
+		t.Errorf("expected the revocation of %s, got %v", jti, list.Revocations)
+	}
+
+	// invalid tokens are ignored, as stated by RFC 7009
+	for _, form := range []url.Values{
+		{"token": {"not.a.token"}},
+		{"token": {"unknown"}, "token_type_hint": {"refresh_token"}},
+	} {
+		if rec := postForm(handler, "/revoke", form); rec.Code != http.StatusOK {
+			t.Errorf("revoke %v: expected 200, got %d", form, rec.Code)
+		}
+	}
+
+	if rec := postForm(handler, "/revoke", url.Values{}); rec.Code != http.StatusBadRequest {
+		t.Errorf("revoke without token: expected 400, got %d", rec.Code)
+	}
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+	api, handler := newAuthorizeTestAPI(t)
+	api.RefreshTokenDuration = time.Hour
+
+	refresh := func(refreshToken string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(RefreshReq{RefreshToken: refreshToken})
+
+		req := httptest.NewRequest(http.MethodPost, "/refresh", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	ctx := context.Background()
+
+	first, err := api.startRefreshFamily(ctx, "alice", jwt.MapClaims{"sub": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rotate once, the whole family is revoked with any of its tokens
+	rec := refresh(first)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("refresh: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// only the refresh token of the response is needed
+	resp := RefreshReq{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := postForm(handler, "/revoke", url.Values{"token": {first}}); rec.Code != http.StatusOK {
+		t.Fatalf("revoke: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := refresh(resp.RefreshToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("refresh in a revoked family: expected 401, got %d", rec.Code)
+	}
+}
+
+func TestKeystoneRevoke(t *testing.T) {
+	api, handler := newAuthorizeTestAPI(t)
+
+	authToken, _ := issueToken(t, api)
+	subjectToken, jti := issueToken(t, api)
+
+	keystone := func(method, authToken, subjectToken string) int {
+		req := httptest.NewRequest(method, "/v3/auth/tokens", nil)
+		req.Header.Set("X-Auth-Token", authToken)
+		req.Header.Set("X-Subject-Token", subjectToken)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := keystone(http.MethodDelete, "bad", subjectToken); code != http.StatusUnauthorized {
+		t.Errorf("revoke with a bad auth token: expected 401, got %d", code)
+	}
+
+	if code := keystone(http.MethodDelete, authToken, subjectToken); code != http.StatusNoContent {
+		t.Fatalf("revoke: expected 204, got %d", code)
+	}
+
+	if code := keystone(http.MethodHead, authToken, subjectToken); code != http.StatusBadRequest {
+		t.Errorf("check of the revoked token: expected 400, got %d", code)
+	}
+
+	if list := listRevocations(t, handler); len(list.Revocations) != 1 || list.Revocations[0].ID != jti {
+		t.Errorf("expected the revocation of %s, got %v", jti, list.Revocations)
+	}
+
+	// without store, revocation is not available
+	api.Store = nil
+	if code := keystone(http.MethodDelete, authToken, subjectToken); code != http.StatusNotFound {
+		t.Errorf("revoke without store: expected 404, got %d", code)
+	}
+}
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Signer signs tokens, possibly with a key held outside the process.
+type Signer interface {
+	// SigningKey returns the key to sign new tokens with. Its private part
+	// is not set when the key is held by the signer.
+	SigningKey() *SigningKey
+
+	// Sign returns the raw signature of the signing string (JWS format) with the given key.
+	Sign(ctx context.Context, key *SigningKey, signingString string) ([]byte, error)
+}
+
+var _ Signer = &KeyRing{}
+
+// SigningKey returns the active key of the ring.
+func (r *KeyRing) SigningKey() *SigningKey {
+	return r.Active()
+}
+
+// Sign signs with the in-memory private key.
+func (r *KeyRing) Sign(ctx context.Context, key *SigningKey, signingString string) ([]byte, error) {
+	if key.Private == nil {
+		return nil, fmt.Errorf("key %s has no private key", key.ID)
+	}
+
+	sig, err := key.Method.Sign(signingString, key.Private)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwt.DecodeSegment(sig)
+}
+
+// RemoteSigner delegates signatures to a signing service (an HSM or KMS
+// daemon for instance), over HTTP or a Unix socket. The service must answer:
+//
+//	GET  /key  -> {"alg": "ES256", "public_key": "<PEM public key or certificate chain>"}
+//	POST /sign {"kid": "...", "alg": "ES256", "data": "<base64>"} -> {"signature": "<base64>"}
+//
+// Signatures must be in JWS format (R || S for ECDSA).
+type RemoteSigner struct {
+	baseURL string
+	client  *http.Client
+
+	mutex sync.RWMutex
+	key   *SigningKey
+}
+
+type remoteKey struct {
+	Alg       string `json:"alg"`
+	PublicKey string `json:"public_key"`
+}
+
+type remoteSignReq struct {
+	KeyID string `json:"kid"`
+	Alg   string `json:"alg"`
+	Data  []byte `json:"data"`
+}
+
+type remoteSignResp struct {
+	Signature []byte `json:"signature"`
+}
+
+var _ Signer = &RemoteSigner{}
+
+// NewRemoteSigner creates a signer for the service at the given URL
+// (http://, https:// or unix:///path/to/socket), and discovers its key.
+func NewRemoteSigner(signerURL string, timeout time.Duration) (*RemoteSigner, error) {
+	u, err := url.Parse(signerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &RemoteSigner{
+		client: &http.Client{Timeout: timeout},
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		s.baseURL = strings.TrimSuffix(signerURL, "/")
+
+	case "unix":
+		socket := u.Path
+		s.baseURL = "http://signer"
+		s.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported signer URL scheme: %q", u.Scheme)
+	}
+
+	if s.key, err = s.fetchKey(); err != nil {
+		return nil, fmt.Errorf("failed to get the signer's key: %w", err)
+	}
+
+	return s, nil
+}
+
+// SigningKey returns the key discovered from the signer.
+func (s *RemoteSigner) SigningKey() *SigningKey {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.key
+}
+
+// Sign requests a signature from the signer.
+func (s *RemoteSigner) Sign(ctx context.Context, key *SigningKey, signingString string) ([]byte, error) {
+	ba, err := json.Marshal(remoteSignReq{
+		KeyID: key.ID,
+		Alg:   key.Method.Alg(),
+		Data:  []byte(signingString),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+"/sign", bytes.NewReader(ba))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp := remoteSignResp{}
+	if err := s.do(req, &resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Signature) == 0 {
+		return nil, errors.New("signer returned an empty signature")
+	}
+
+	// don't trust the signer blindly
+	if err := key.Method.Verify(signingString, jwt.EncodeSegment(resp.Signature), key.Public); err != nil {
+		return nil, fmt.Errorf("invalid signature from signer: %w", err)
+	}
+
+	return resp.Signature, nil
+}
+
+// Watch checks the signer's key every interval, updating the ring when it changes.
+func (s *RemoteSigner) Watch(ring *KeyRing, interval time.Duration) {
+	for range time.Tick(interval) {
+		key, err := s.fetchKey()
+		if err != nil {
+			log.Print("failed to get the signer's key: ", err)
+			continue
+		}
+
+		if key.ID == s.SigningKey().ID {
+			continue
+		}
+
+		log.Print("signer key changed to ", key.ID)
+
+		ring.Update(key, nil)
+
+		s.mutex.Lock()
+		s.key = key
+		s.mutex.Unlock()
+	}
+}
+
+func (s *RemoteSigner) fetchKey() (*SigningKey, error) {
+	req, err := http.NewRequest(http.MethodGet, s.baseURL+"/key", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rk := remoteKey{}
+	if err := s.do(req, &rk); err != nil {
+		return nil, err
+	}
+
+	method := jwt.GetSigningMethod(rk.Alg)
+	if method == nil {
+		return nil, fmt.Errorf("unknown signing method: %q", rk.Alg)
+	}
+
+	return NewSigningKey(method, []byte(rk.PublicKey), nil)
+}
+
+func (s *RemoteSigner) do(req *http.Request, v interface{}) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP status from signer: %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
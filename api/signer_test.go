@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/isi-nc/autentigo/pkg/keys"
+)
+
+// fakeSigner is a signing service holding an ECDSA key.
+type fakeSigner struct {
+	crtData []byte
+	private interface{}
+
+	badSignature bool
+	delay        time.Duration
+	status       int
+}
+
+func newFakeSigner(t *testing.T) *fakeSigner {
+	crtData, keyData := newTestKeyPair(t)
+
+	private, err := keys.ParsePrivateKey(keyData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &fakeSigner{crtData: crtData, private: private}
+}
+
+func (s *fakeSigner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/key":
+		json.NewEncoder(w).Encode(remoteKey{Alg: "ES256", PublicKey: string(s.crtData)})
+
+	case "/sign":
+		time.Sleep(s.delay)
+
+		if s.status != 0 {
+			w.WriteHeader(s.status)
+			return
+		}
+
+		req := remoteSignReq{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Alg != "ES256" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		data := string(req.Data)
+		if s.badSignature {
+			data += "x"
+		}
+
+		sig, err := jwt.SigningMethodES256.Sign(data, s.private)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		ba, _ := jwt.DecodeSegment(sig)
+		json.NewEncoder(w).Encode(remoteSignResp{Signature: ba})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func testRemoteSigner(t *testing.T, s *RemoteSigner) {
+	api := &API{Keys: NewKeyRing(s.SigningKey(), 0), Signer: s}
+
+	_, tokenString, err := api.createToken(context.Background(), "alice", jwt.MapClaims{"sub": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := jwt.Parse(tokenString, api.keyfunc); err != nil {
+		t.Errorf("the remotely signed token should be valid: %v", err)
+	}
+}
+
+func TestRemoteSignerHTTP(t *testing.T) {
+	server := httptest.NewServer(newFakeSigner(t))
+	defer server.Close()
+
+	s, err := NewRemoteSigner(server.URL+"/", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRemoteSigner(t, s)
+}
+
+func TestRemoteSignerUnixSocket(t *testing.T) {
+	// socket paths are short, t.TempDir() may be too long
+	dir, err := os.MkdirTemp("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "signer.sock")
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &httptest.Server{Listener: l, Config: &http.Server{Handler: newFakeSigner(t)}}
+	server.Start()
+	defer server.Close()
+
+	s, err := NewRemoteSigner("unix://"+socket, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRemoteSigner(t, s)
+}
+
+func TestRemoteSignerErrors(t *testing.T) {
+	fake := newFakeSigner(t)
+
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	s, err := NewRemoteSigner(server.URL, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := s.SigningKey()
+
+	for _, test := range []struct {
+		name  string
+		setup func()
+		err   string
+	}{
+		{name: "bad signature", setup: func() { fake.badSignature = true }, err: "invalid signature from signer"},
+		{name: "HTTP error", setup: func() { fake.status = http.StatusInternalServerError }, err: "unexpected HTTP status"},
+		{name: "timeout", setup: func() { fake.delay = 200 * time.Millisecond }, err: "Client.Timeout"},
+	} {
+		*fake = fakeSigner{crtData: fake.crtData, private: fake.private}
+		test.setup()
+
+		if _, err := s.Sign(context.Background(), key, "header.payload"); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", test.name, test.err, err)
+		}
+	}
+
+	// unreachable or invalid signers are refused on start
+	for _, signerURL := range []string{"ftp://signer", "unix:///nonexistent/signer.sock", server.URL + "/nowhere"} {
+		if _, err := NewRemoteSigner(signerURL, 100*time.Millisecond); err == nil {
+			t.Errorf("%s: expected an error", signerURL)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/emicklei/go-restful/v3"
@@ -25,12 +26,17 @@ func (api *API) registerSimple(ws *restful.WebService) {
 type AuthReq struct {
 	User     string `json:"user"`
 	Password string `json:"password"`
+	// Audience restricts the token to the given audience (optional).
+	Audience string `json:"audience,omitempty"`
+	// OTP is the one-time password of users with a second factor.
+	OTP string `json:"otp,omitempty"`
 }
 
 // AuthResponse is a simple JWT authn response
 type AuthResponse struct {
-	Token  string     `json:"token"`
-	Claims jwt.Claims `json:"claims"`
+	Token        string     `json:"token"`
+	Claims       jwt.Claims `json:"claims"`
+	RefreshToken string     `json:"refresh_token,omitempty"`
 }
 
 func (api *API) simpleAuthenticate(request *restful.Request, response *restful.Response) {
@@ -57,7 +63,11 @@ func (api *API) simpleAuthenticate(request *restful.Request, response *restful.R
 		return
 	}
 
-	api.writeAuthResponse(request, response, authReq.User, authReq.Password)
+	info := api.requestInfo(request, "simple")
+	info.Audience = authReq.Audience
+	info.OTP = authReq.OTP
+
+	api.writeAuthResponse(request, response, authReq.User, authReq.Password, info)
 }
 
 func setCookieHeader() *restful.Parameter {
@@ -75,22 +85,35 @@ func setCookieDomainHeader() *restful.Parameter {
 		"X-Set-Cookie-Domain", "The domain of the authorization cookie.")
 }
 
-func (api *API) writeAuthResponse(request *restful.Request, response *restful.Response, user, password string) {
-	claims, err := api.authenticate(user, password)
-	if err == ErrInvalidAuthentication {
+func (api *API) writeAuthResponse(request *restful.Request, response *restful.Response, user, password string, info RequestInfo) {
+	claims, err := api.authenticate(request.Request.Context(), user, password, info)
+	lockedOut := &LockedOutError{}
+	if errors.As(err, &lockedOut) {
+		writeLockedOut(response, lockedOut)
+		return
+	} else if errors.Is(err, ErrOTPRequired) {
+		response.WriteErrorString(http.StatusUnauthorized, "One-time password required.\n")
+		return
+	} else if errors.Is(err, ErrInvalidAuthentication) {
 		response.WriteErrorString(http.StatusUnauthorized, "Authentication failed.\n")
 		return
 	} else if err != nil {
 		panic(err)
 	}
 
-	_, tokenString, err := api.createToken(user, claims)
+	api.writeToken(request, response, user, claims, info)
+}
+
+// writeToken answers an authentication with a token of the claims (or a
+// cookie), and a refresh token if enabled.
+func (api *API) writeToken(request *restful.Request, response *restful.Response, user string, claims jwt.MapClaims, info RequestInfo) {
+	_, tokenString, err := api.createToken(request.Request.Context(), user, claims)
 
 	if err != nil {
 		panic(err)
 	}
 
-	_, err = api.checkToken(tokenString)
+	_, err = api.checkToken(request.Request.Context(), tokenString, info.Audience)
 	if err != nil {
 		panic(err)
 	}
@@ -114,5 +137,17 @@ func (api *API) writeAuthResponse(request *restful.Request, response *restful.Re
 		return
 	}
 
-	response.WriteEntity(&AuthResponse{tokenString, claims})
+	authResp := &AuthResponse{
+		Token:  tokenString,
+		Claims: claims,
+	}
+
+	if api.refreshEnabled() {
+		authResp.RefreshToken, err = api.startRefreshFamily(request.Request.Context(), user, claims)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	response.WriteEntity(authResp)
 }
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+
+	restful "github.com/emicklei/go-restful/v3"
+)
+
+// TokenResponse is an OAuth2 token endpoint response
+type TokenResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in,omitempty"`
+	RefreshToken    string `json:"refresh_token,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+}
+
+// OAuthError is an OAuth2 error response (RFC 6749 section 5.2)
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (api *API) registerToken(ws *restful.WebService) {
+	ws.
+		Route(ws.POST("/token").
+			To(api.token).
+			Doc("OAuth2 token endpoint").
+			Consumes("application/x-www-form-urlencoded").
+			Produces("application/json").
+			Param(ws.FormParameter("grant_type", "The grant type").Required(true)).
+			Writes(TokenResponse{}))
+}
+
+func (api *API) token(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			WriteError(err.(error), response)
+		}
+	}()
+
+	// responses must not be cached (RFC 6749 section 5.1)
+	response.Header().Set("Cache-Control", "no-store")
+
+	switch grantType := request.Request.PostFormValue("grant_type"); grantType {
+	case tokenExchangeGrant:
+		api.tokenExchange(request, response)
+
+	case clientCredentialsGrant:
+		if api.Clients == nil {
+			writeOAuthError(response, http.StatusBadRequest, "unsupported_grant_type", grantType)
+			return
+		}
+		api.clientCredentials(request, response)
+
+	case authorizationCodeGrant:
+		if !api.authorizeEnabled() {
+			writeOAuthError(response, http.StatusBadRequest, "unsupported_grant_type", grantType)
+			return
+		}
+		api.authorizationCodeToken(request, response)
+
+	case "":
+		writeOAuthError(response, http.StatusBadRequest, "invalid_request", "no grant_type given")
+
+	default:
+		writeOAuthError(response, http.StatusBadRequest, "unsupported_grant_type", grantType)
+	}
+}
+
+// grantTypes lists the grant types supported by the token endpoint.
+func (api *API) grantTypes() []string {
+	grants := []string{tokenExchangeGrant}
+	if api.Clients != nil {
+		grants = append(grants, clientCredentialsGrant)
+	}
+	if api.authorizeEnabled() {
+		grants = append(grants, authorizationCodeGrant)
+	}
+	return grants
+}
+
+func writeOAuthError(response *restful.Response, status int, code, description string) {
+	response.WriteHeaderAndJson(status, OAuthError{code, description}, restful.MIME_JSON)
+}
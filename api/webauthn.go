@@ -0,0 +1,340 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/isi-nc/autentigo/auth"
+	"github.com/isi-nc/autentigo/pkg/store"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
+)
+
+// WebAuthnOptionsReq starts a passkey login.
+type WebAuthnOptionsReq struct {
+	// User restricts the login to the user's credentials. Without it, the
+	// authenticator offers its discoverable credentials (passkeys).
+	User string `json:"user,omitempty"`
+}
+
+// WebAuthnLoginReq finishes a passkey login.
+type WebAuthnLoginReq struct {
+	// Credential is the result of navigator.credentials.get, in its JSON form.
+	Credential webauthn.AssertionResponse `json:"credential"`
+	// Audience restricts the token to the given audience (optional).
+	Audience string `json:"audience,omitempty"`
+}
+
+// webauthnChallenge is the state of a login, stored by challenge.
+type webauthnChallenge struct {
+	User string `json:"user,omitempty"`
+}
+
+func (api *API) registerWebAuthn(ws *restful.WebService) {
+	ws.
+		Route(ws.POST("/webauthn/options").
+			To(api.webauthnOptions).
+			Doc("Start a WebAuthn (passkey) login: returns the options of navigator.credentials.get").
+			Consumes("application/json").
+			Produces("application/json").
+			Reads(WebAuthnOptionsReq{}).
+			Writes(webauthn.RequestOptions{}))
+
+	ws.
+		Route(ws.POST("/webauthn/login").
+			To(api.webauthnLogin).
+			Doc("Finish a WebAuthn (passkey) login with the authenticator's assertion").
+			Consumes("application/json").
+			Produces("application/json").
+			Param(setCookieHeader()).
+			Param(setCookieDomainHeader()).
+			Param(setCookieInsecureHeader()).
+			Reads(WebAuthnLoginReq{}).
+			Writes(AuthResponse{}))
+}
+
+// webauthnLookup returns the credential lookup, if WebAuthn logins are enabled.
+func (api *API) webauthnLookup() (WebAuthnLookup, bool) {
+	if api.WebAuthn == nil || api.Store == nil {
+		return nil, false
+	}
+
+	lookup, ok := api.Authenticator.(WebAuthnLookup)
+	return lookup, ok
+}
+
+func (api *API) webauthnOptions(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			WriteError(err.(error), response)
+		}
+	}()
+
+	lookup, ok := api.webauthnLookup()
+	if !ok {
+		response.WriteErrorString(http.StatusNotFound, "WebAuthn is not enabled.\n")
+		return
+	}
+
+	req := WebAuthnOptionsReq{}
+	if err := request.ReadEntity(&req); err != nil && err != io.EOF {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+
+	ctx := request.Request.Context()
+
+	var credentials []webauthn.Credential
+	if req.User != "" {
+		var err error
+		credentials, _, err = lookup.LookupWebAuthn(ctx, req.User, time.Now())
+		if err != nil && !errors.Is(err, ErrUnknownUser) {
+			panic(err)
+		}
+	}
+
+	if err := api.countWebAuthnStart(ctx, api.requestInfo(request, "webauthn").clientIP()); err == errTooManyPending {
+		ttl := api.WebAuthn.ChallengeTTL()
+		response.Header().Set("Retry-After", strconv.Itoa(int(ttl.Seconds())))
+		response.WriteErrorString(http.StatusTooManyRequests, "Too many pending logins.\n")
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		panic(err)
+	}
+
+	ba, err := json.Marshal(webauthnChallenge{User: req.User})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := api.Store.Put(ctx, "webauthn/challenges/"+challenge, ba, api.WebAuthn.ChallengeTTL()); err != nil {
+		panic(err)
+	}
+
+	response.WriteEntity(api.WebAuthn.RequestOptions(challenge, credentials))
+}
+
+var errTooManyPending = errors.New("too many pending WebAuthn logins")
+
+// countWebAuthnStart counts a login started by the client IP, or returns
+// errTooManyPending if the IP started WebAuthnMaxPending logins during the
+// challenge timeout.
+func (api *API) countWebAuthnStart(ctx context.Context, ip string) error {
+	if api.WebAuthnMaxPending == 0 || ip == "" {
+		return nil
+	}
+
+	key := "webauthn/pending/" + url.PathEscape(ip)
+
+	// retry on concurrent updates
+	for attempt := 0; ; attempt++ {
+		raw, err := api.Store.Get(ctx, key)
+		if err != nil && err != store.ErrNotFound {
+			return err
+		}
+
+		count := 0
+		if raw != nil {
+			if count, err = strconv.Atoi(string(raw)); err != nil {
+				return err
+			}
+		}
+
+		if count >= api.WebAuthnMaxPending {
+			return errTooManyPending
+		}
+
+		value := []byte(strconv.Itoa(count + 1))
+		if raw == nil {
+			err = api.Store.Create(ctx, key, value, api.WebAuthn.ChallengeTTL())
+		} else {
+			err = api.Store.Swap(ctx, key, raw, value, api.WebAuthn.ChallengeTTL())
+		}
+
+		if (err == store.ErrExists || err == store.ErrConflict) && attempt < 10 {
+			continue
+		}
+
+		return err
+	}
+}
+
+func (api *API) webauthnLogin(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			WriteError(err.(error), response)
+		}
+	}()
+
+	lookup, ok := api.webauthnLookup()
+	if !ok {
+		response.WriteErrorString(http.StatusNotFound, "WebAuthn is not enabled.\n")
+		return
+	}
+
+	req := WebAuthnLoginReq{}
+	if err := request.ReadEntity(&req); err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+
+	info := api.requestInfo(request, "webauthn")
+	info.Audience = req.Audience
+
+	user, claims, err := api.webauthnAuthenticate(request.Request.Context(), lookup, &req.Credential, info)
+	lockedOut := &LockedOutError{}
+	if errors.As(err, &lockedOut) {
+		writeLockedOut(response, lockedOut)
+		return
+	} else if errors.Is(err, ErrInvalidAuthentication) {
+		response.WriteErrorString(http.StatusUnauthorized, "Authentication failed.\n")
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	api.writeToken(request, response, user, claims, info)
+}
+
+// webauthnAuthenticate verifies the assertion, and returns the user and the token's claims.
+func (api *API) webauthnAuthenticate(ctx context.Context, lookup WebAuthnLookup, assertion *webauthn.AssertionResponse, info RequestInfo) (user string, claims jwt.MapClaims, err error) {
+	challenge, err := webauthn.Challenge(assertion.Response.ClientDataJSON)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrInvalidAuthentication, err)
+	}
+
+	state, err := api.spendWebAuthnChallenge(ctx, challenge)
+	if err != nil {
+		return
+	}
+
+	// the user handle is the user name (see the companion's registration)
+	user = state.User
+	if assertion.Response.UserHandle != "" {
+		handle, err := assertion.UserHandle()
+		if err != nil || (user != "" && handle != user) {
+			return "", nil, fmt.Errorf("%w: user handle mismatch", ErrInvalidAuthentication)
+		}
+		user = handle
+	}
+
+	if user == "" {
+		return "", nil, fmt.Errorf("%w: no user handle", ErrInvalidAuthentication)
+	}
+
+	if err = api.checkLockout(ctx, user, info); err != nil {
+		return
+	}
+
+	credentials, backendClaims, err := lookup.LookupWebAuthn(ctx, user, time.Now().Add(api.TokenDuration))
+
+	var verified *webauthn.Assertion
+	if err == nil {
+		verified, err = api.WebAuthn.VerifyAssertion(challenge, assertion, credentials)
+		if errors.Is(err, webauthn.ErrInvalid) {
+			err = fmt.Errorf("%w: %v", ErrInvalidAuthentication, err)
+		}
+	}
+
+	if err == nil {
+		err = api.checkSignCount(ctx, user, verified)
+	}
+
+	if errors.Is(err, ErrInvalidAuthentication) {
+		if lockErr := api.authenticationFailed(ctx, user, info, err); lockErr != nil {
+			log.Print("failed to record the authentication failure: ", lockErr)
+		}
+		return "", nil, err
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	if err := api.authenticationSucceeded(ctx, user); err != nil {
+		log.Print("failed to reset the authentication failures: ", err)
+	}
+
+	m, err := auth.ToMap(backendClaims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// proof of possession of a key, and multi-factor if the authenticator verified the user
+	amr := []string{"hwk"}
+	if verified.UserVerified {
+		amr = append(amr, "mfa")
+	}
+	m["amr"] = amr
+
+	claims, err = api.tokenClaims(m, info)
+	return
+}
+
+// spendWebAuthnChallenge returns the state of a login, which can only be finished once.
+func (api *API) spendWebAuthnChallenge(ctx context.Context, challenge string) (state *webauthnChallenge, err error) {
+	key := "webauthn/challenges/" + challenge
+
+	err = api.Store.Create(ctx, "webauthn/spent/"+challenge, []byte{}, api.WebAuthn.ChallengeTTL())
+	if err == store.ErrExists {
+		return nil, fmt.Errorf("%w: challenge already used", ErrInvalidAuthentication)
+	} else if err != nil {
+		return
+	}
+
+	ba, err := api.Store.Get(ctx, key)
+	if err == store.ErrNotFound {
+		return nil, fmt.Errorf("%w: unknown or expired challenge", ErrInvalidAuthentication)
+	} else if err != nil {
+		return
+	}
+
+	if err := api.Store.Delete(ctx, key); err != nil {
+		log.Print("failed to delete a WebAuthn challenge: ", err)
+	}
+
+	state = &webauthnChallenge{}
+	err = json.Unmarshal(ba, state)
+	return
+}
+
+// checkSignCount rejects assertions whose signature counter did not increase,
+// which indicates a cloned authenticator. Authenticators without counter always give 0.
+func (api *API) checkSignCount(ctx context.Context, user string, assertion *webauthn.Assertion) error {
+	id := assertion.Credential.CredentialID()
+	key := "webauthn/counters/" + id
+
+	var previous uint64
+	ba, err := api.Store.Get(ctx, key)
+	if err == nil {
+		previous, _ = strconv.ParseUint(string(ba), 10, 32)
+	} else if err != store.ErrNotFound {
+		return err
+	}
+
+	if (previous != 0 || assertion.SignCount != 0) && uint64(assertion.SignCount) <= previous {
+		log.Printf("webauthn: the signature counter of %s's credential %s went from %d to %d, it may be cloned",
+			user, id, previous, assertion.SignCount)
+		return fmt.Errorf("%w: signature counter did not increase", ErrInvalidAuthentication)
+	}
+
+	if assertion.SignCount == 0 {
+		return nil
+	}
+
+	return api.Store.Put(ctx, key, []byte(strconv.FormatUint(uint64(assertion.SignCount), 10)), 0)
+}
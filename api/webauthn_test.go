@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
+)
+
+// webauthnTestAuth is testAuth, with no credentials.
+type webauthnTestAuth struct {
+	testAuth
+}
+
+func (webauthnTestAuth) LookupWebAuthn(ctx context.Context, user string, expiresAt time.Time) ([]webauthn.Credential, jwt.Claims, error) {
+	return nil, nil, ErrUnknownUser
+}
+
+func TestWebAuthnPendingCap(t *testing.T) {
+	api, handler := newAuthorizeTestAPI(t)
+	api.Authenticator = webauthnTestAuth{}
+	api.WebAuthn = &webauthn.Config{RPID: "example.com"}
+	api.WebAuthnMaxPending = 2
+
+	options := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/webauthn/options", strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = remoteAddr
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := options("192.0.2.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("login %d: expected 200, got %d: %s", i+1, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := options("192.0.2.1:4321")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "300" {
+		t.Errorf("expected 429 for 300s, got %d for %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	if rec := options("192.0.2.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("other IPs should not be capped, got %d", rec.Code)
+	}
+
+	challenges, err := api.Store.List(context.Background(), "webauthn/challenges/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(challenges) != 3 {
+		t.Errorf("expected 3 stored challenges, got %d", len(challenges))
+	}
+}
@@ -0,0 +1,250 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/isi-nc/autentigo/api"
+	"github.com/isi-nc/autentigo/auth"
+	"github.com/isi-nc/autentigo/pkg/apikey"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
+)
+
+// New Authenticator caching the results of the backend: successes for
+// positiveTTL, and invalid authentications for negativeTTL (0 disables
+// either). Other errors are never cached. At most maxEntries results are
+// kept, the least recently used are dropped first.
+//
+// Results are cached by user, password and keystone domain (which selects
+// the user's backend when routing), but whatever the rest of the request:
+// don't put it in front of backends having request dependent policies.
+// Authentications with a one-time password are never cached, since the
+// password can't be used twice.
+func New(backend api.Authenticator, positiveTTL, negativeTTL time.Duration, maxEntries int) *Cache {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+
+	return &Cache{
+		backend:     backend,
+		positiveTTL: positiveTTL,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+		salt:        salt,
+		lru:         list.New(),
+		entries:     map[string]*list.Element{},
+		byUser:      map[string]map[string]bool{},
+	}
+}
+
+// Cache is a caching authenticator.
+type Cache struct {
+	backend     api.Authenticator
+	positiveTTL time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+
+	// salt of password hashes, so they can't be used outside of this process
+	salt []byte
+
+	mutex   sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	byUser  map[string]map[string]bool
+}
+
+type entry struct {
+	key       string
+	user      string
+	claims    jwt.MapClaims
+	err       error
+	expiresAt time.Time
+}
+
+var (
+	_ api.Authenticator    = &Cache{}
+	_ api.CacheInvalidator = &Cache{}
+	_ api.GroupsLookup     = &Cache{}
+	_ api.WebAuthnLookup   = &Cache{}
+	_ api.APIKeyLookup     = &Cache{}
+)
+
+func (c *Cache) Authenticate(ctx context.Context, user, password string, expiresAt time.Time, req api.RequestInfo) (jwt.Claims, error) {
+	if req.OTP != "" {
+		return c.backend.Authenticate(ctx, user, password, expiresAt, req)
+	}
+
+	key := c.key(user, req.Domain, password)
+
+	if e := c.get(key); e != nil {
+		if e.err != nil {
+			return nil, e.err
+		}
+		return refresh(e.claims, expiresAt), nil
+	}
+
+	claims, err := c.backend.Authenticate(ctx, user, password, expiresAt, req)
+
+	switch {
+	case err == nil && c.positiveTTL != 0:
+		m, err := auth.ToMap(claims)
+		if err != nil {
+			return nil, err
+		}
+
+		c.put(&entry{key: key, user: user, claims: m, expiresAt: time.Now().Add(c.positiveTTL)})
+		return refresh(m, expiresAt), nil
+
+	case errors.Is(err, api.ErrInvalidAuthentication) && c.negativeTTL != 0:
+		c.put(&entry{key: key, user: user, err: err, expiresAt: time.Now().Add(c.negativeTTL)})
+	}
+
+	return claims, err
+}
+
+// Backend returns the cached backend.
+func (c *Cache) Backend() api.Authenticator {
+	return c.backend
+}
+
+// LookupGroups is never cached, the groups are given by the backend (if it can
+// look them up).
+func (c *Cache) LookupGroups(ctx context.Context, user string) ([]string, error) {
+	lookup, ok := c.backend.(api.GroupsLookup)
+	if !ok {
+		return nil, errors.New("backend can't look groups up")
+	}
+
+	return lookup.LookupGroups(ctx, user)
+}
+
+// LookupWebAuthn is never cached, the credentials are given by the backend (if
+// it has some: users are unknown otherwise).
+func (c *Cache) LookupWebAuthn(ctx context.Context, user string, expiresAt time.Time) ([]webauthn.Credential, jwt.Claims, error) {
+	lookup, ok := c.backend.(api.WebAuthnLookup)
+	if !ok {
+		return nil, nil, api.ErrUnknownUser
+	}
+
+	return lookup.LookupWebAuthn(ctx, user, expiresAt)
+}
+
+// LookupAPIKeys is never cached either, so revoked keys are refused at once.
+func (c *Cache) LookupAPIKeys(ctx context.Context, user string, expiresAt time.Time) ([]apikey.Key, jwt.Claims, error) {
+	lookup, ok := c.backend.(api.APIKeyLookup)
+	if !ok {
+		return nil, nil, api.ErrUnknownUser
+	}
+
+	return lookup.LookupAPIKeys(ctx, user, expiresAt)
+}
+
+// Invalidate drops the cached results of the user.
+func (c *Cache) Invalidate(user string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key := range c.byUser[user] {
+		c.remove(c.entries[key])
+	}
+}
+
+// InvalidateAll drops every cached result.
+func (c *Cache) InvalidateAll() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lru.Init()
+	c.entries = map[string]*list.Element{}
+	c.byUser = map[string]map[string]bool{}
+}
+
+// Len returns the number of cached results.
+func (c *Cache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.lru.Len()
+}
+
+func (c *Cache) key(user, domain, password string) string {
+	h := hmac.New(sha256.New, c.salt)
+	h.Write([]byte(user))
+	h.Write([]byte{0})
+	h.Write([]byte(domain))
+	h.Write([]byte{0})
+	h.Write([]byte(password))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Cache) get(key string) *entry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	e := elem.Value.(*entry)
+	if time.Now().After(e.expiresAt) {
+		c.remove(elem)
+		return nil
+	}
+
+	c.lru.MoveToFront(elem)
+	return e
+}
+
+func (c *Cache) put(e *entry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.entries[e.key]; ok {
+		c.remove(elem)
+	}
+
+	c.entries[e.key] = c.lru.PushFront(e)
+
+	if c.byUser[e.user] == nil {
+		c.byUser[e.user] = map[string]bool{}
+	}
+	c.byUser[e.user][e.key] = true
+
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops an entry, the mutex must be held.
+func (c *Cache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*entry)
+	delete(c.entries, e.key)
+
+	delete(c.byUser[e.user], e.key)
+	if len(c.byUser[e.user]) == 0 {
+		delete(c.byUser, e.user)
+	}
+}
+
+// refresh returns a copy of the cached claims, issued now.
+func refresh(claims jwt.MapClaims, expiresAt time.Time) jwt.MapClaims {
+	m := make(jwt.MapClaims, len(claims))
+	for k, v := range claims {
+		m[k] = v
+	}
+
+	m["iat"] = time.Now().Unix()
+	m["exp"] = expiresAt.Unix()
+	return m
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/isi-nc/autentigo/api"
+)
+
+// countingAuth accepts the password "ok" and counts its calls.
+type countingAuth struct {
+	calls int
+	err   error
+}
+
+func (a *countingAuth) Authenticate(ctx context.Context, user, password string, expiresAt time.Time, req api.RequestInfo) (jwt.Claims, error) {
+	a.calls++
+	if a.err != nil {
+		return nil, a.err
+	}
+	if password != "ok" {
+		return nil, api.ErrInvalidAuthentication
+	}
+	return jwt.StandardClaims{Subject: user, ExpiresAt: expiresAt.Unix()}, nil
+}
+
+// LookupGroups gives the user name as only group.
+func (a *countingAuth) LookupGroups(ctx context.Context, user string) ([]string, error) {
+	a.calls++
+	return []string{user}, nil
+}
+
+func authenticate(c *Cache, user, password string, exp time.Time) (jwt.Claims, error) {
+	return c.Authenticate(context.Background(), user, password, exp, api.RequestInfo{})
+}
+
+func TestCache(t *testing.T) {
+	backend := &countingAuth{}
+	c := New(backend, time.Minute, time.Minute, 10)
+
+	exp := time.Now().Add(time.Hour)
+	authenticate(c, "bob", "ok", exp)
+
+	exp2 := exp.Add(time.Hour)
+	claims, err := authenticate(c, "bob", "ok", exp2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend.calls != 1 {
+		t.Errorf("expected a cached result, got %d backend calls", backend.calls)
+	}
+	if got := claims.(jwt.MapClaims)["exp"]; got != exp2.Unix() {
+		t.Errorf("cached claims should expire at %d, got %v", exp2.Unix(), got)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := authenticate(c, "bob", "bad", exp); !errors.Is(err, api.ErrInvalidAuthentication) {
+			t.Fatal("expected an invalid authentication, got ", err)
+		}
+	}
+	if backend.calls != 2 {
+		t.Errorf("expected a cached failure, got %d backend calls", backend.calls)
+	}
+
+	c.Invalidate("bob")
+	if c.Len() != 0 {
+		t.Errorf("expected an empty cache after invalidation, got %d entries", c.Len())
+	}
+
+	authenticate(c, "bob", "ok", exp)
+	if backend.calls != 3 {
+		t.Errorf("expected a backend call after invalidation, got %d backend calls", backend.calls)
+	}
+}
+
+func TestDomainsAreNotShared(t *testing.T) {
+	backend := &countingAuth{}
+	c := New(backend, time.Minute, time.Minute, 10)
+
+	exp := time.Now().Add(time.Hour)
+	for _, domain := range []string{"a", "b", "a"} {
+		if _, err := c.Authenticate(context.Background(), "alice", "ok", exp, api.RequestInfo{Domain: domain}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if backend.calls != 2 {
+		t.Errorf("expected a backend call per domain, got %d backend calls", backend.calls)
+	}
+}
+
+func TestLookupGroupsIsForwarded(t *testing.T) {
+	backend := &countingAuth{}
+	c := New(backend, time.Minute, time.Minute, 10)
+
+	for i := 0; i < 2; i++ {
+		groups, err := c.LookupGroups(context.Background(), "bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(groups) != 1 || groups[0] != "bob" {
+			t.Errorf("got groups %v, expected the backend's", groups)
+		}
+	}
+
+	if backend.calls != 2 {
+		t.Errorf("lookups should not be cached, got %d backend calls", backend.calls)
+	}
+}
+
+func TestErrorsAreNotCached(t *testing.T) {
+	backend := &countingAuth{err: errors.New("backend down")}
+	c := New(backend, time.Minute, time.Minute, 10)
+
+	authenticate(c, "bob", "ok", time.Now())
+	authenticate(c, "bob", "ok", time.Now())
+
+	if backend.calls != 2 {
+		t.Errorf("errors should not be cached, got %d backend calls", backend.calls)
+	}
+}
+
+func TestSizeBound(t *testing.T) {
+	c := New(&countingAuth{}, time.Minute, time.Minute, 2)
+
+	authenticate(c, "a", "ok", time.Now())
+	authenticate(c, "b", "ok", time.Now())
+	authenticate(c, "a", "ok", time.Now()) // a is now the most recently used
+	authenticate(c, "c", "ok", time.Now())
+
+	if c.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", c.Len())
+	}
+	if c.get(c.key("b", "", "ok")) != nil {
+		t.Error("b should have been evicted")
+	}
+	if c.get(c.key("a", "", "ok")) == nil {
+		t.Error("a should still be cached")
+	}
+}
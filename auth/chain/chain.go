@@ -0,0 +1,174 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/isi-nc/autentigo/api"
+	"github.com/isi-nc/autentigo/auth"
+	"github.com/isi-nc/autentigo/pkg/apikey"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
+)
+
+// Backend is a named authenticator of the chain.
+type Backend struct {
+	Name          string
+	Authenticator api.Authenticator
+}
+
+// New Authenticator trying the backends in order. The next backend is only
+// tried when the previous one doesn't know the user (api.ErrUnknownUser): a
+// wrong password, or any other error, stops the chain.
+//
+// With mergeGroups, the groups of the user in the other backends (implementing
+// api.GroupsLookup) are added to the groups given by the authenticating backend.
+func New(backends []Backend, mergeGroups bool) api.Authenticator {
+	return &chainAuth{
+		backends:    backends,
+		mergeGroups: mergeGroups,
+	}
+}
+
+type chainAuth struct {
+	backends    []Backend
+	mergeGroups bool
+}
+
+var (
+	_ api.Authenticator  = &chainAuth{}
+	_ api.WebAuthnLookup = &chainAuth{}
+	_ api.APIKeyLookup   = &chainAuth{}
+)
+
+func (a *chainAuth) Authenticate(ctx context.Context, user, password string, expiresAt time.Time, req api.RequestInfo) (jwt.Claims, error) {
+	for i, backend := range a.backends {
+		claims, err := backend.Authenticator.Authenticate(ctx, user, password, expiresAt, req)
+		if errors.Is(err, api.ErrUnknownUser) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if !a.mergeGroups {
+			return claims, nil
+		}
+
+		return a.withGroups(ctx, i, user, claims)
+	}
+
+	return nil, api.ErrUnknownUser
+}
+
+// LookupWebAuthn returns the credentials of the first backend knowing the user
+// (among those implementing api.WebAuthnLookup).
+func (a *chainAuth) LookupWebAuthn(ctx context.Context, user string, expiresAt time.Time) ([]webauthn.Credential, jwt.Claims, error) {
+	for i, backend := range a.backends {
+		lookup, ok := backend.Authenticator.(api.WebAuthnLookup)
+		if !ok {
+			continue
+		}
+
+		credentials, claims, err := lookup.LookupWebAuthn(ctx, user, expiresAt)
+		if errors.Is(err, api.ErrUnknownUser) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		if a.mergeGroups {
+			if claims, err = a.withGroups(ctx, i, user, claims); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		return credentials, claims, nil
+	}
+
+	return nil, nil, api.ErrUnknownUser
+}
+
+// LookupAPIKeys returns the keys of the first backend knowing the user (among
+// those implementing api.APIKeyLookup).
+func (a *chainAuth) LookupAPIKeys(ctx context.Context, user string, expiresAt time.Time) ([]apikey.Key, jwt.Claims, error) {
+	for i, backend := range a.backends {
+		lookup, ok := backend.Authenticator.(api.APIKeyLookup)
+		if !ok {
+			continue
+		}
+
+		keys, claims, err := lookup.LookupAPIKeys(ctx, user, expiresAt)
+		if errors.Is(err, api.ErrUnknownUser) {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		if a.mergeGroups {
+			if claims, err = a.withGroups(ctx, i, user, claims); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		return keys, claims, nil
+	}
+
+	return nil, nil, api.ErrUnknownUser
+}
+
+// withGroups adds the groups of the user from the backends other than the authenticating one.
+func (a *chainAuth) withGroups(ctx context.Context, authenticating int, user string, claims jwt.Claims) (jwt.Claims, error) {
+	m, err := auth.ToMap(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []string{}
+	seen := map[string]bool{}
+	add := func(group string) {
+		if group != "" && !seen[group] {
+			seen[group] = true
+			groups = append(groups, group)
+		}
+	}
+
+	if current, ok := m["groups"].([]interface{}); ok {
+		for _, group := range current {
+			if s, ok := group.(string); ok {
+				add(s)
+			}
+		}
+	}
+
+	for i, backend := range a.backends {
+		lookup, ok := backend.Authenticator.(api.GroupsLookup)
+		if i == authenticating || !ok {
+			continue
+		}
+
+		backendGroups, err := lookup.LookupGroups(ctx, user)
+		if errors.Is(err, api.ErrUnknownUser) {
+			continue
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// the user is authenticated, missing some groups is better than failing
+			log.Printf("chain: failed to get groups of %s from %s: %v", user, backend.Name, err)
+			continue
+		}
+
+		for _, group := range backendGroups {
+			add(group)
+		}
+	}
+
+	if len(groups) != 0 {
+		m["groups"] = groups
+	}
+
+	return m, nil
+}
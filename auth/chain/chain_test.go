@@ -0,0 +1,127 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/isi-nc/autentigo/api"
+	"github.com/isi-nc/autentigo/auth"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
+)
+
+// fakeAuth knows users by password, and gives them groups and credentials.
+type fakeAuth struct {
+	passwords   map[string]string
+	groups      map[string][]string
+	credentials map[string][]webauthn.Credential
+}
+
+func (a fakeAuth) Authenticate(ctx context.Context, user, password string, expiresAt time.Time, req api.RequestInfo) (jwt.Claims, error) {
+	p, ok := a.passwords[user]
+	if !ok {
+		return nil, api.ErrUnknownUser
+	}
+	if p != password {
+		return nil, api.ErrInvalidAuthentication
+	}
+
+	claims := auth.Claims{}
+	claims.Subject = user
+	claims.Groups = a.groups[user]
+	return claims, nil
+}
+
+func (a fakeAuth) LookupGroups(ctx context.Context, user string) ([]string, error) {
+	if _, ok := a.passwords[user]; !ok {
+		return nil, api.ErrUnknownUser
+	}
+	return a.groups[user], nil
+}
+
+func (a fakeAuth) LookupWebAuthn(ctx context.Context, user string, expiresAt time.Time) ([]webauthn.Credential, jwt.Claims, error) {
+	if _, ok := a.passwords[user]; !ok {
+		return nil, nil, api.ErrUnknownUser
+	}
+
+	claims := auth.Claims{}
+	claims.Subject = user
+	claims.Groups = a.groups[user]
+	return a.credentials[user], claims, nil
+}
+
+var (
+	employees = fakeAuth{
+		passwords: map[string]string{"alice": "a", "bob": "b"},
+		groups:    map[string][]string{"alice": {"staff"}, "bob": {"staff"}},
+		credentials: map[string][]webauthn.Credential{
+			"bob": {{ID: []byte("employees-key")}},
+		},
+	}
+	local = fakeAuth{
+		passwords: map[string]string{"bob": "local", "svc": "s"},
+		groups:    map[string][]string{"bob": {"admin", "staff"}, "svc": {"robots"}},
+	}
+)
+
+func TestFallThrough(t *testing.T) {
+	a := New([]Backend{{"employees", employees}, {"local", local}}, false)
+	ctx := context.Background()
+
+	if _, err := a.Authenticate(ctx, "svc", "s", time.Now(), api.RequestInfo{}); err != nil {
+		t.Error("unknown user in the first backend should fall through: ", err)
+	}
+
+	if _, err := a.Authenticate(ctx, "bob", "local", time.Now(), api.RequestInfo{}); !errors.Is(err, api.ErrInvalidAuthentication) || errors.Is(err, api.ErrUnknownUser) {
+		t.Error("wrong password should stop the chain, got ", err)
+	}
+
+	if _, err := a.Authenticate(ctx, "carol", "c", time.Now(), api.RequestInfo{}); !errors.Is(err, api.ErrUnknownUser) {
+		t.Error("expected an unknown user, got ", err)
+	}
+}
+
+func TestMergeGroups(t *testing.T) {
+	a := New([]Backend{{"employees", employees}, {"local", local}}, true)
+
+	claims, err := a.Authenticate(context.Background(), "bob", "b", time.Now(), api.RequestInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups := claims.(jwt.MapClaims)["groups"]
+	if expected := []string{"staff", "admin"}; !reflect.DeepEqual(groups, expected) {
+		t.Errorf("got groups %v, expected %v", groups, expected)
+	}
+}
+
+func TestLookupWebAuthn(t *testing.T) {
+	a := New([]Backend{{"employees", employees}, {"local", local}}, true).(api.WebAuthnLookup)
+	ctx := context.Background()
+
+	credentials, claims, err := a.LookupWebAuthn(ctx, "bob", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(credentials) != 1 || string(credentials[0].ID) != "employees-key" {
+		t.Errorf("expected the credentials of the first backend, got %v", credentials)
+	}
+
+	groups := claims.(jwt.MapClaims)["groups"]
+	if expected := []string{"staff", "admin"}; !reflect.DeepEqual(groups, expected) {
+		t.Errorf("got groups %v, expected %v", groups, expected)
+	}
+
+	if credentials, _, err := a.LookupWebAuthn(ctx, "svc", time.Now()); err != nil || len(credentials) != 0 {
+		t.Errorf("unknown user in the first backend should fall through, got %v, %v", credentials, err)
+	}
+
+	if _, _, err := a.LookupWebAuthn(ctx, "carol", time.Now()); !errors.Is(err, api.ErrUnknownUser) {
+		t.Error("expected an unknown user, got ", err)
+	}
+}
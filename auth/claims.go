@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"encoding/json"
+
 	jwt "github.com/golang-jwt/jwt/v4"
 )
 
@@ -10,10 +12,35 @@ type ExtraClaims struct {
 	Email         string   `json:"email,omitempty" bson:"email,omitempty"`
 	EmailVerified bool     `json:"email_verified,omitempty" bson:"email_verified,omitempty"`
 	Groups        []string `json:"groups,omitempty" bson:"groups,omitempty"`
+
+	// Attributes are free-form user attributes, only put in tokens through claim mapping.
+	Attributes map[string]interface{} `json:"attributes,omitempty" bson:"attributes,omitempty"`
 }
 
 // Claims supporting our ExtraClaims.
 type Claims struct {
 	jwt.StandardClaims
 	ExtraClaims
+
+	// AMR are the authentication methods used (RFC 8176), set for users with a second factor.
+	AMR []string `json:"amr,omitempty"`
+}
+
+// ToMap converts any claims to their JSON map representation.
+func ToMap(claims jwt.Claims) (jwt.MapClaims, error) {
+	if m, ok := claims.(jwt.MapClaims); ok {
+		return m, nil
+	}
+
+	ba, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	m := jwt.MapClaims{}
+	if err := json.Unmarshal(ba, &m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
 }
@@ -0,0 +1,72 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/isi-nc/autentigo/api"
+	"github.com/isi-nc/autentigo/pkg/oauthclient"
+)
+
+// NewClients returns a client registry with etcd backend. Clients are stored
+// as JSON under <prefix>/<client id>.
+func NewClients(prefix string, endpoints []string) api.ClientRegistry {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints: endpoints,
+	})
+
+	if err != nil {
+		log.Fatal("failed to connect to etcd: ", err)
+	}
+
+	timeout := 5 * time.Second
+	if timeoutEnv := os.Getenv("ETCD_TIMEOUT"); timeoutEnv != "" {
+		timeout, err = time.ParseDuration(timeoutEnv)
+		if err != nil {
+			log.Fatalf("invalid ETCD_TIMEOUT %q: %v", timeoutEnv, timeout)
+		}
+	}
+
+	return &etcdClients{
+		prefix:  prefix,
+		client:  client,
+		timeout: timeout,
+	}
+}
+
+type etcdClients struct {
+	prefix  string
+	client  *clientv3.Client
+	timeout time.Duration
+}
+
+var _ api.ClientRegistry = &etcdClients{}
+
+func (c *etcdClients) LookupClient(ctx context.Context, id string) (*oauthclient.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	resp, err := c.client.Get(ctx, path.Join(c.prefix, id))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, oauthclient.ErrUnknownClient
+	}
+
+	client := &oauthclient.Client{}
+	if err = json.Unmarshal(resp.Kvs[0].Value, client); err != nil {
+		return nil, err
+	}
+
+	// the key is the reference
+	client.ID = id
+	return client, nil
+}
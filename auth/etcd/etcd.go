@@ -2,8 +2,6 @@ package etcd
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"log"
 	"os"
@@ -15,10 +13,13 @@ import (
 
 	"github.com/isi-nc/autentigo/api"
 	"github.com/isi-nc/autentigo/auth"
+	"github.com/isi-nc/autentigo/pkg/apikey"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
 )
 
-// New Authenticator with etcd backend
-func New(prefix string, endpoints []string) api.Authenticator {
+// New Authenticator with etcd backend. Password hashes are upgraded to the
+// rehash scheme on successful authentications (never if empty).
+func New(prefix string, endpoints []string, rehash string) api.Authenticator {
 	client, err := clientv3.New(clientv3.Config{
 		Endpoints: endpoints,
 	})
@@ -39,6 +40,7 @@ func New(prefix string, endpoints []string) api.Authenticator {
 		prefix:  prefix,
 		client:  client,
 		timeout: timeout,
+		rehash:  rehash,
 	}
 }
 
@@ -46,44 +48,47 @@ type etcdAuth struct {
 	prefix  string
 	client  *clientv3.Client
 	timeout time.Duration
+	rehash  string
 }
 
-var _ api.Authenticator = &etcdAuth{}
+var (
+	_ api.Authenticator  = &etcdAuth{}
+	_ api.GroupsLookup   = &etcdAuth{}
+	_ api.WebAuthnLookup = &etcdAuth{}
+	_ api.APIKeyLookup   = &etcdAuth{}
+)
 
 // User describe an user stored in etcd
 type User struct {
 	PasswordHash string `json:"password_hash"`
+	TOTPSecret   string `json:"totp_secret,omitempty"`
+	// WebAuthnCredentials are the user's passkeys.
+	WebAuthnCredentials []webauthn.Credential `json:"webauthn_credentials,omitempty"`
+	// APIKeys are the user's API keys (hashed).
+	APIKeys []apikey.Key `json:"api_keys,omitempty"`
 	auth.ExtraClaims
 }
 
-func (a *etcdAuth) Authenticate(user, password string, expiresAt time.Time) (claims jwt.Claims, err error) {
-
-	ba := sha256.Sum256([]byte(password))
-	passwordHash := hex.EncodeToString(ba[:])
-
-	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
-	defer cancel()
-
-	resp, err := a.client.Get(ctx, path.Join(a.prefix, user))
+func (a *etcdAuth) Authenticate(ctx context.Context, user, password string, expiresAt time.Time, req api.RequestInfo) (claims jwt.Claims, err error) {
+	u, raw, err := a.getUser(ctx, user)
 	if err != nil {
 		return
 	}
 
-	if len(resp.Kvs) == 0 {
-		err = api.ErrInvalidAuthentication
+	newHash, err := api.CheckPassword(user, u.PasswordHash, password, a.rehash)
+	if err != nil {
 		return
 	}
 
-	u := User{}
-	if err = json.Unmarshal(resp.Kvs[0].Value, &u); err != nil {
+	amr, err := api.CheckOTP(u.TOTPSecret, req)
+	if err != nil {
 		return
 	}
 
-	log.Printf("User: %s, password: %s, hash: %s, hashDb: %s", user, password, passwordHash, u.PasswordHash)
-
-	if u.PasswordHash != passwordHash {
-		err = api.ErrInvalidAuthentication
-		return
+	if newHash != "" {
+		if err := a.updateHash(ctx, user, raw, newHash); err != nil {
+			log.Printf("failed to rehash the password of %s: %v", user, err)
+		}
 	}
 
 	claims = auth.Claims{
@@ -93,6 +98,94 @@ func (a *etcdAuth) Authenticate(user, password string, expiresAt time.Time) (cla
 			Subject:   user,
 		},
 		ExtraClaims: u.ExtraClaims,
+		AMR:         amr,
+	}
+	return
+}
+
+func (a *etcdAuth) LookupGroups(ctx context.Context, user string) ([]string, error) {
+	u, _, err := a.getUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.Groups, nil
+}
+
+func (a *etcdAuth) LookupWebAuthn(ctx context.Context, user string, expiresAt time.Time) ([]webauthn.Credential, jwt.Claims, error) {
+	u, _, err := a.getUser(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return u.WebAuthnCredentials, auth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			Subject:   user,
+		},
+		ExtraClaims: u.ExtraClaims,
+	}, nil
+}
+
+func (a *etcdAuth) LookupAPIKeys(ctx context.Context, user string, expiresAt time.Time) ([]apikey.Key, jwt.Claims, error) {
+	u, _, err := a.getUser(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return u.APIKeys, auth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			Subject:   user,
+		},
+		ExtraClaims: u.ExtraClaims,
+	}, nil
+}
+
+// getUser returns the user, and its raw value.
+func (a *etcdAuth) getUser(ctx context.Context, user string) (u *User, raw []byte, err error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	resp, err := a.client.Get(ctx, path.Join(a.prefix, user))
+	if err != nil {
+		return
+	}
+
+	if len(resp.Kvs) == 0 {
+		err = api.ErrUnknownUser
+		return
 	}
+
+	raw = resp.Kvs[0].Value
+	u = &User{}
+	err = json.Unmarshal(raw, u)
 	return
 }
+
+// updateHash replaces the password hash of the user, if the user was not changed since read.
+func (a *etcdAuth) updateHash(ctx context.Context, user string, raw []byte, newHash string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	// keep the unknown fields
+	value := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return err
+	}
+	value["password_hash"] = newHash
+
+	ba, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	key := path.Join(a.prefix, user)
+	_, err = a.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", string(raw))).
+		Then(clientv3.OpPut(key, string(ba))).
+		Commit()
+	return err
+}
@@ -1,66 +1,183 @@
 package ldapbind
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"log"
+	"net"
 	"net/url"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/isi-nc/autentigo/api"
+	autentigoauth "github.com/isi-nc/autentigo/auth"
 	"gopkg.in/ldap.v2"
 )
 
-// New Authenticator with ldap backend
-func New(server, userTemplate string) api.Authenticator {
+// New Authenticator with ldap backend. The given attributes of the user's
+// entry are read after the bind, to be mapped to claims. The user's entry is
+// looked up before the bind, to tell unknown users (api.ErrUnknownUser) from
+// wrong passwords; searchDN and searchPassword give the account used for this
+// lookup (anonymous if empty).
+func New(server, userTemplate string, attributes []string, searchDN, searchPassword string) api.Authenticator {
 	u, err := url.Parse(server)
 	if err != nil {
 		log.Fatal("Bad LDAP server URL: ", err)
 	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		log.Fatal("ldap: bad protocol: ", u.Scheme)
+	}
 	return &auth{
-		url:          u,
-		userTemplate: userTemplate,
+		url:            u,
+		userTemplate:   userTemplate,
+		attributes:     attributes,
+		searchDN:       searchDN,
+		searchPassword: searchPassword,
 	}
 }
 
 type auth struct {
-	url          *url.URL
-	userTemplate string
+	url            *url.URL
+	userTemplate   string
+	attributes     []string
+	searchDN       string
+	searchPassword string
 }
 
 var _ api.Authenticator = auth{}
 
-func (a auth) Authenticate(user, password string, expiresAt time.Time) (jwt.Claims, error) {
+func (a auth) Authenticate(ctx context.Context, user, password string, expiresAt time.Time, req api.RequestInfo) (jwt.Claims, error) {
+	l, err := a.dial(ctx)
+	if err != nil {
+		log.Print("LDAP dial error: ", err)
+		return nil, err
+	}
+
+	defer l.Close()
+
+	// ldap.v2 has no context support, closing the connection interrupts pending operations
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Close()
+		case <-done:
+		}
+	}()
+
+	dn := fmt.Sprintf(a.userTemplate, user)
+
+	if exists, err := a.userExists(l, dn); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Print("LDAP search error: ", err)
+		return nil, err
+	} else if !exists {
+		return nil, api.ErrUnknownUser
+	}
+
+	if err := l.Bind(dn, password); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Print("LDAP bind error: ", err)
+		return nil, api.ErrInvalidAuthentication
+	}
+
+	claims := autentigoauth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			Subject:   user,
+		},
+	}
+
+	if len(a.attributes) != 0 {
+		if claims.Attributes, err = a.readAttributes(l, dn); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Print("LDAP search error: ", err)
+			return nil, err
+		}
+	}
+
+	return claims, nil
+}
+
+func (a auth) dial(ctx context.Context) (*ldap.Conn, error) {
+	dialer := &net.Dialer{}
+
 	var (
-		l   *ldap.Conn
-		err error
+		conn net.Conn
+		err  error
 	)
 
-	switch a.url.Scheme {
-	case "ldaps":
-		l, err = ldap.DialTLS("tcp", a.url.Host, &tls.Config{
-			InsecureSkipVerify: true,
-		})
-	case "ldap":
-		l, err = ldap.Dial("tcp", a.url.Host)
-	default:
-		log.Fatal("ldap: bad protocol: ", a.url.Scheme)
+	if a.url.Scheme == "ldaps" {
+		conn, err = (&tls.Dialer{
+			NetDialer: dialer,
+			Config: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		}).DialContext(ctx, "tcp", a.url.Host)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", a.url.Host)
 	}
 
 	if err != nil {
-		log.Print("LDAP dial error: ", err)
 		return nil, err
 	}
 
-	if err := l.Bind(fmt.Sprintf(a.userTemplate, user), password); err != nil {
-		log.Print("LDAP bind error: ", err)
-		return nil, api.ErrInvalidAuthentication
+	l := ldap.NewConn(conn, a.url.Scheme == "ldaps")
+	l.Start()
+
+	return l, nil
+}
+
+// userExists looks the user's entry up, with the search account if any.
+func (a auth) userExists(l *ldap.Conn, dn string) (bool, error) {
+	if a.searchDN != "" {
+		if err := l.Bind(a.searchDN, a.searchPassword); err != nil {
+			return false, err
+		}
+	}
+
+	_, err := l.Search(ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		1, 0, false, "(objectClass=*)", []string{"1.1"}, nil))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// readAttributes reads the user's entry. Single values are given as strings, multiple values as lists.
+func (a auth) readAttributes(l *ldap.Conn, dn string) (map[string]interface{}, error) {
+	res, err := l.Search(ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases,
+		1, 0, false, "(objectClass=*)", a.attributes, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := map[string]interface{}{}
+
+	if len(res.Entries) == 0 {
+		return attributes, nil
+	}
+
+	for _, attr := range res.Entries[0].Attributes {
+		if len(attr.Values) == 1 {
+			attributes[attr.Name] = attr.Values[0]
+		} else {
+			attributes[attr.Name] = attr.Values
+		}
 	}
 
-	return jwt.StandardClaims{
-		IssuedAt:  time.Now().Unix(),
-		ExpiresAt: expiresAt.Unix(),
-		Subject:   user,
-	}, nil
+	return attributes, nil
 }
@@ -0,0 +1,82 @@
+package mongo
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+
+	"github.com/isi-nc/autentigo/api"
+	"github.com/isi-nc/autentigo/pkg/oauthclient"
+)
+
+// NewClients returns a client registry with mongo backend. Documents are
+// identified by the client id (_id).
+func NewClients(database string, collection string, endpoint string) api.ClientRegistry {
+	timeout := 5 * time.Second
+	if timeoutEnv := os.Getenv("MONGO_TIMEOUT"); timeoutEnv != "" {
+		var err error
+		timeout, err = time.ParseDuration(timeoutEnv)
+		if err != nil {
+			log.Fatalf("invalid MONGO_TIMEOUT %q: %v", timeoutEnv, err)
+		}
+	}
+
+	// create client
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	mongoc, err := mongo.Connect(ctx, options.Client().ApplyURI(endpoint))
+	if err != nil {
+		log.Fatal("failed to create mongo client: ", err)
+	}
+
+	// check connection
+	err = mongoc.Ping(ctx, readpref.Primary())
+	if err != nil {
+		log.Fatal("failed to connect to mongo: ", err)
+	}
+
+	return &mongoClients{
+		database:   database,
+		collection: collection,
+		client:     mongoc,
+		timeout:    timeout,
+	}
+}
+
+type mongoClients struct {
+	database   string
+	collection string
+	client     *mongo.Client
+	timeout    time.Duration
+}
+
+var _ api.ClientRegistry = &mongoClients{}
+
+func (c *mongoClients) LookupClient(ctx context.Context, id string) (*oauthclient.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	sr := c.client.Database(c.database).
+		Collection(c.collection).
+		FindOne(ctx, bson.M{"_id": id})
+
+	if err := sr.Err(); err == mongo.ErrNoDocuments {
+		return nil, oauthclient.ErrUnknownClient
+	} else if err != nil {
+		// timeout, cancellation...
+		return nil, err
+	}
+
+	client := &oauthclient.Client{}
+	if err := sr.Decode(client); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
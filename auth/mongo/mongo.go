@@ -2,8 +2,6 @@ package mongo
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"log"
 	"os"
 	"time"
@@ -19,10 +17,13 @@ import (
 
 	"github.com/isi-nc/autentigo/api"
 	"github.com/isi-nc/autentigo/auth"
+	"github.com/isi-nc/autentigo/pkg/apikey"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
 )
 
-// New Authenticator with mongo backend
-func New(database string, collection string, field string, endpoint string) api.Authenticator {
+// New Authenticator with mongo backend. Password hashes are upgraded to the
+// rehash scheme on successful authentications (never if empty).
+func New(database string, collection string, field string, endpoint string, rehash string) api.Authenticator {
 	timeout := 5 * time.Second
 	if timeoutEnv := os.Getenv("MONGO_TIMEOUT"); timeoutEnv != "" {
 		timeout, err := time.ParseDuration(timeoutEnv)
@@ -56,6 +57,7 @@ func New(database string, collection string, field string, endpoint string) api.
 		field:      field,
 		client:     mongoc,
 		timeout:    timeout,
+		rehash:     rehash,
 	}
 }
 
@@ -65,67 +67,160 @@ type mongoAuth struct {
 	field      string
 	client     *mongo.Client
 	timeout    time.Duration
+	rehash     string
 }
 
-var _ api.Authenticator = &mongoAuth{}
+var (
+	_ api.Authenticator  = &mongoAuth{}
+	_ api.GroupsLookup   = &mongoAuth{}
+	_ api.WebAuthnLookup = &mongoAuth{}
+	_ api.APIKeyLookup   = &mongoAuth{}
+)
 
 // User describe an user stored in mongo
 type User struct {
 	PasswordHash string   `json:"password_hash" bson:"password_hash"`
+	TOTPSecret   string   `json:"totp_secret,omitempty" bson:"totp_secret,omitempty"`
 	Groups       []string `json:"groups,omitempty" bson:"groups,omitempty"` //TODO why not on extraClaims ??
+
+	// WebAuthnCredentials are the user's passkeys.
+	WebAuthnCredentials []webauthn.Credential `json:"webauthn_credentials,omitempty" bson:"webauthn_credentials,omitempty"`
+	// APIKeys are the user's API keys (hashed).
+	APIKeys []apikey.Key `json:"api_keys,omitempty" bson:"api_keys,omitempty"`
+
 	auth.ExtraClaims
 }
 
-func (a *mongoAuth) Authenticate(user string, password string, expiresAt time.Time) (claims jwt.Claims, err error) {
-	ba := sha256.Sum256([]byte(password))
-	passwordHash := hex.EncodeToString(ba[:])
+func (a *mongoAuth) Authenticate(ctx context.Context, user string, password string, expiresAt time.Time, req api.RequestInfo) (claims jwt.Claims, err error) {
+	u, err := a.getUser(ctx, user)
+	if err != nil {
+		return
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
-	defer cancel()
+	newHash, err := api.CheckPassword(user, u.PasswordHash, password, a.rehash)
+	if err != nil {
+		return
+	}
 
-	var filter interface{}
-	// special case for _id
-	if a.field == "_id" {
-		objectId, err := primitive.ObjectIDFromHex(user)
-		if err != nil {
-			return nil, api.ErrInvalidAuthentication
-		}
-		filter = bson.M{a.field: objectId}
-	} else {
-		filter = bson.M{a.field: user}
+	amr, err := api.CheckOTP(u.TOTPSecret, req)
+	if err != nil {
+		return
 	}
 
-	u := &User{}
+	if newHash != "" {
+		if err := a.updateHash(ctx, user, u.PasswordHash, newHash); err != nil {
+			log.Printf("failed to rehash the password of %s: %v", user, err)
+		}
+	}
 
-	sr := a.client.Database(a.database).
-		Collection(a.collection).
-		FindOne(ctx, filter)
+	claims = auth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			Subject:   user,
+		},
+		ExtraClaims: u.ExtraClaims,
+		AMR:         amr,
+	}
+	return
+}
 
-	if sr.Err() == mongo.ErrNoDocuments {
-		err = api.ErrInvalidAuthentication
-		return
+func (a *mongoAuth) LookupGroups(ctx context.Context, user string) ([]string, error) {
+	u, err := a.getUser(ctx, user)
+	if err != nil {
+		return nil, err
 	}
 
-	err = sr.Decode(u)
+	return u.Groups, nil
+}
+
+func (a *mongoAuth) LookupWebAuthn(ctx context.Context, user string, expiresAt time.Time) ([]webauthn.Credential, jwt.Claims, error) {
+	u, err := a.getUser(ctx, user)
 	if err != nil {
-		err = api.ErrInvalidAuthentication
-		return
+		return nil, nil, err
 	}
 
-	if u.PasswordHash != passwordHash {
-		err = api.ErrInvalidAuthentication
-		return
+	return u.WebAuthnCredentials, auth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			Subject:   user,
+		},
+		ExtraClaims: u.ExtraClaims,
+	}, nil
+}
+
+func (a *mongoAuth) LookupAPIKeys(ctx context.Context, user string, expiresAt time.Time) ([]apikey.Key, jwt.Claims, error) {
+	u, err := a.getUser(ctx, user)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	//TODO ugly, should be removed
-	u.ExtraClaims.Groups = u.Groups
-	claims = auth.Claims{
+	return u.APIKeys, auth.Claims{
 		StandardClaims: jwt.StandardClaims{
 			IssuedAt:  time.Now().Unix(),
 			ExpiresAt: expiresAt.Unix(),
 			Subject:   user,
 		},
 		ExtraClaims: u.ExtraClaims,
+	}, nil
+}
+
+func (a *mongoAuth) getUser(ctx context.Context, user string) (u *User, err error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	filter, ok := a.filter(user)
+	if !ok {
+		return nil, api.ErrUnknownUser
+	}
+
+	u = &User{}
+
+	sr := a.client.Database(a.database).
+		Collection(a.collection).
+		FindOne(ctx, filter)
+
+	if err = sr.Err(); err == mongo.ErrNoDocuments {
+		return nil, api.ErrUnknownUser
+	} else if err != nil {
+		// timeout, cancellation...
+		return nil, err
 	}
+
+	if err = sr.Decode(u); err != nil {
+		return nil, api.ErrInvalidAuthentication
+	}
+
+	//TODO ugly, should be removed
+	u.ExtraClaims.Groups = u.Groups
 	return
 }
+
+// filter finds the user's document. It's not ok if the user name can't be an ID.
+func (a *mongoAuth) filter(user string) (filter bson.M, ok bool) {
+	// special case for _id
+	if a.field == "_id" {
+		objectId, err := primitive.ObjectIDFromHex(user)
+		if err != nil {
+			return nil, false
+		}
+		return bson.M{a.field: objectId}, true
+	}
+
+	return bson.M{a.field: user}, true
+}
+
+// updateHash replaces the password hash of the user, if it was not changed since read.
+func (a *mongoAuth) updateHash(ctx context.Context, user, oldHash, newHash string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	filter, _ := a.filter(user)
+	filter["password_hash"] = oldHash
+
+	_, err := a.client.Database(a.database).
+		Collection(a.collection).
+		UpdateOne(ctx, filter, bson.M{"$set": bson.M{"password_hash": newHash}})
+	return err
+}
@@ -0,0 +1,273 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	yaml "github.com/projectcalico/go-yaml-wrapper"
+
+	"github.com/isi-nc/autentigo/api"
+	"github.com/isi-nc/autentigo/auth"
+	"github.com/isi-nc/autentigo/pkg/apikey"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
+)
+
+// Config is the routing configuration.
+type Config struct {
+	// Routes are checked in order, the first matching one is used.
+	Routes []Route
+
+	// Default is the backend of users matching no route (none if empty).
+	Default string
+}
+
+// Route sends the users matching one of its criteria to a backend.
+type Route struct {
+	// Suffix of the user name (like "@corp").
+	Suffix string
+	// Prefix of the user name (like "CORP\").
+	Prefix string
+	// Regexp matching the user name. When stripping, the user name becomes the first submatch.
+	Regexp string
+	// Domain given in a keystone request. The sub claim of these users is
+	// qualified with the domain (user@domain), a name the route also matches
+	// when no domain is given (lookups, other endpoints).
+	Domain string
+
+	// Backend is the name of the backend to use.
+	Backend string
+
+	// Strip removes the suffix, prefix or unmatched part before calling the backend.
+	Strip bool
+
+	re *regexp.Regexp
+}
+
+// FromFile loads the configuration from a YAML file.
+func FromFile(path string) (config *Config, err error) {
+	ba, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	return FromBytes(ba)
+}
+
+// FromBytes loads the configuration from YAML data.
+func FromBytes(ba []byte) (config *Config, err error) {
+	config = &Config{}
+
+	if err = yaml.UnmarshalStrict(ba, config); err != nil {
+		return
+	}
+
+	return
+}
+
+// New Authenticator routing users to the named backends. The sub claim is
+// always the user name as given, even when stripped for the backend, and
+// qualified with the domain for domain routes.
+func New(config *Config, backends map[string]api.Authenticator) (api.Authenticator, error) {
+	if config.Default != "" && backends[config.Default] == nil {
+		return nil, fmt.Errorf("default: unknown backend %q", config.Default)
+	}
+
+	routes := make([]Route, len(config.Routes))
+	for i, route := range config.Routes {
+		criteria := 0
+		for _, c := range []string{route.Suffix, route.Prefix, route.Regexp, route.Domain} {
+			if c != "" {
+				criteria++
+			}
+		}
+		if criteria != 1 {
+			return nil, fmt.Errorf("route %d: exactly one of suffix, prefix, regexp or domain is required", i)
+		}
+
+		if backends[route.Backend] == nil {
+			return nil, fmt.Errorf("route %d: unknown backend %q", i, route.Backend)
+		}
+
+		if route.Regexp != "" {
+			re, err := regexp.Compile(route.Regexp)
+			if err != nil {
+				return nil, fmt.Errorf("route %d: %w", i, err)
+			}
+			if route.Strip && re.NumSubexp() == 0 {
+				return nil, fmt.Errorf("route %d: stripping with a regexp requires a submatch", i)
+			}
+			route.re = re
+		}
+
+		routes[i] = route
+	}
+
+	return &routerAuth{
+		routes:   routes,
+		fallback: config.Default,
+		backends: backends,
+	}, nil
+}
+
+type routerAuth struct {
+	routes   []Route
+	fallback string
+	backends map[string]api.Authenticator
+}
+
+var (
+	_ api.Authenticator  = &routerAuth{}
+	_ api.GroupsLookup   = &routerAuth{}
+	_ api.WebAuthnLookup = &routerAuth{}
+	_ api.APIKeyLookup   = &routerAuth{}
+)
+
+func (a *routerAuth) Authenticate(ctx context.Context, user, password string, expiresAt time.Time, req api.RequestInfo) (jwt.Claims, error) {
+	backend, backendUser, sub, ok := a.route(user, req.Domain)
+	if !ok {
+		return nil, api.ErrUnknownUser
+	}
+
+	claims, err := backend.Authenticate(ctx, backendUser, password, expiresAt, req)
+	if err != nil || backendUser == sub {
+		return claims, err
+	}
+
+	return withSubject(claims, sub)
+}
+
+func (a *routerAuth) LookupGroups(ctx context.Context, user string) ([]string, error) {
+	backend, backendUser, _, ok := a.route(user, "")
+	if !ok {
+		return nil, api.ErrUnknownUser
+	}
+
+	lookup, ok := backend.(api.GroupsLookup)
+	if !ok {
+		return nil, errors.New("backend can't look groups up")
+	}
+
+	return lookup.LookupGroups(ctx, backendUser)
+}
+
+func (a *routerAuth) LookupWebAuthn(ctx context.Context, user string, expiresAt time.Time) ([]webauthn.Credential, jwt.Claims, error) {
+	backend, backendUser, sub, ok := a.route(user, "")
+	if !ok {
+		return nil, nil, api.ErrUnknownUser
+	}
+
+	lookup, ok := backend.(api.WebAuthnLookup)
+	if !ok {
+		// the backend has no credentials
+		return nil, nil, api.ErrUnknownUser
+	}
+
+	credentials, claims, err := lookup.LookupWebAuthn(ctx, backendUser, expiresAt)
+	if err != nil || backendUser == sub {
+		return credentials, claims, err
+	}
+
+	if claims, err = withSubject(claims, sub); err != nil {
+		return nil, nil, err
+	}
+
+	return credentials, claims, nil
+}
+
+func (a *routerAuth) LookupAPIKeys(ctx context.Context, user string, expiresAt time.Time) ([]apikey.Key, jwt.Claims, error) {
+	backend, backendUser, sub, ok := a.route(user, "")
+	if !ok {
+		return nil, nil, api.ErrUnknownUser
+	}
+
+	lookup, ok := backend.(api.APIKeyLookup)
+	if !ok {
+		// the backend has no keys
+		return nil, nil, api.ErrUnknownUser
+	}
+
+	keys, claims, err := lookup.LookupAPIKeys(ctx, backendUser, expiresAt)
+	if err != nil || backendUser == sub {
+		return keys, claims, err
+	}
+
+	if claims, err = withSubject(claims, sub); err != nil {
+		return nil, nil, err
+	}
+
+	return keys, claims, nil
+}
+
+// route finds the user's backend, the user name to give it, and the sub claim.
+func (a *routerAuth) route(user, domain string) (backend api.Authenticator, backendUser, sub string, ok bool) {
+	for _, route := range a.routes {
+		backendUser, sub, ok = route.match(user, domain)
+		if ok {
+			return a.backends[route.Backend], backendUser, sub, true
+		}
+	}
+
+	if a.fallback != "" {
+		return a.backends[a.fallback], user, user, true
+	}
+
+	return nil, "", "", false
+}
+
+func (r Route) match(user, domain string) (backendUser, sub string, ok bool) {
+	backendUser, sub = user, user
+
+	switch {
+	case r.Suffix != "":
+		if ok = strings.HasSuffix(user, r.Suffix); ok && r.Strip {
+			backendUser = strings.TrimSuffix(user, r.Suffix)
+		}
+
+	case r.Prefix != "":
+		if ok = strings.HasPrefix(user, r.Prefix); ok && r.Strip {
+			backendUser = strings.TrimPrefix(user, r.Prefix)
+		}
+
+	case r.re != nil:
+		m := r.re.FindStringSubmatch(user)
+		if ok = m != nil; ok && r.Strip {
+			backendUser = m[1]
+		}
+
+	case r.Domain != "" && domain != "":
+		// users of different domains may have the same name
+		if ok = domain == r.Domain; ok {
+			sub = user + "@" + domain
+		}
+
+	case r.Domain != "":
+		// the qualified name, as in the sub claim
+		if ok = strings.HasSuffix(user, "@"+r.Domain); ok {
+			backendUser = strings.TrimSuffix(user, "@"+r.Domain)
+		}
+	}
+
+	// an empty user name would be surprising to backends
+	if backendUser == "" {
+		return "", "", false
+	}
+
+	return
+}
+
+// withSubject returns the claims with the given sub.
+func withSubject(claims jwt.Claims, sub string) (jwt.Claims, error) {
+	m, err := auth.ToMap(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	m["sub"] = sub
+	return m, nil
+}
@@ -0,0 +1,150 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/isi-nc/autentigo/api"
+	"github.com/isi-nc/autentigo/auth"
+)
+
+// fakeAuth accepts its users with the password "ok".
+type fakeAuth map[string]bool
+
+func (a fakeAuth) Authenticate(ctx context.Context, user, password string, expiresAt time.Time, req api.RequestInfo) (jwt.Claims, error) {
+	if !a[user] {
+		return nil, api.ErrUnknownUser
+	}
+	if password != "ok" {
+		return nil, api.ErrInvalidAuthentication
+	}
+
+	claims := auth.Claims{}
+	claims.Subject = user
+	return claims, nil
+}
+
+// LookupGroups gives the user name as only group.
+func (a fakeAuth) LookupGroups(ctx context.Context, user string) ([]string, error) {
+	if !a[user] {
+		return nil, api.ErrUnknownUser
+	}
+	return []string{user}, nil
+}
+
+const testRoutes = `
+routes:
+- suffix: "@corp"
+  backend: corp
+  strip: true
+- regexp: "^PARTNER\\\\(.+)$"
+  backend: partner
+  strip: true
+- domain: partners
+  backend: partner
+- prefix: "svc-"
+  backend: local
+default: local
+`
+
+func TestRoutes(t *testing.T) {
+	config, err := FromBytes([]byte(testRoutes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := New(config, map[string]api.Authenticator{
+		"corp":    fakeAuth{"alice": true},
+		"partner": fakeAuth{"bob": true},
+		"local":   fakeAuth{"svc-ci": true, "alice": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		user, domain string
+		sub          string
+		err          error
+	}{
+		{user: "alice@corp", sub: "alice@corp"},
+		{user: `PARTNER\bob`, sub: `PARTNER\bob`},
+		{user: "bob", domain: "partners", sub: "bob@partners"},
+		{user: "bob@partners", sub: "bob@partners"},
+		{user: "alice", domain: "partners", err: api.ErrUnknownUser},
+		{user: "svc-ci", sub: "svc-ci"},
+		{user: "alice", sub: "alice"},
+		{user: "bob@corp", err: api.ErrUnknownUser},
+		{user: "@corp", err: api.ErrUnknownUser},
+	} {
+		claims, err := a.Authenticate(context.Background(), test.user, "ok", time.Now(), api.RequestInfo{Domain: test.domain})
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s: expected error %v, got %v", test.user, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.user, err)
+			continue
+		}
+
+		m, _ := auth.ToMap(claims)
+		if m["sub"] != test.sub {
+			t.Errorf("%s: got sub %v, expected %s", test.user, m["sub"], test.sub)
+		}
+	}
+}
+
+func TestDomainLookup(t *testing.T) {
+	config, err := FromBytes([]byte(testRoutes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := New(config, map[string]api.Authenticator{
+		"corp":    fakeAuth{},
+		"partner": fakeAuth{"bob": true},
+		"local":   fakeAuth{"bob": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the sub of keystone users of the partners domain
+	groups, err := a.(api.GroupsLookup).LookupGroups(context.Background(), "bob@partners")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0] != "bob" {
+		t.Errorf("got groups %v, expected the partner backend's bob", groups)
+	}
+
+	if _, err := a.(api.GroupsLookup).LookupGroups(context.Background(), "bob@other"); !errors.Is(err, api.ErrUnknownUser) {
+		t.Errorf("expected an unknown user, got %v", err)
+	}
+}
+
+func TestInvalidRoutes(t *testing.T) {
+	backends := map[string]api.Authenticator{"local": fakeAuth{}}
+
+	for _, routes := range []string{
+		"routes: [{backend: local}]",
+		"routes: [{suffix: '@a', prefix: 'b', backend: local}]",
+		"routes: [{suffix: '@a', backend: other}]",
+		"routes: [{regexp: '^a', strip: true, backend: local}]",
+		"default: other",
+	} {
+		config, err := FromBytes([]byte(routes))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := New(config, backends); err == nil {
+			t.Errorf("%q: expected an error", routes)
+		}
+	}
+}
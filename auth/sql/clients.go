@@ -0,0 +1,67 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/isi-nc/autentigo/api"
+	"github.com/isi-nc/autentigo/pkg/oauthclient"
+)
+
+// NewClients returns a client registry with SQL backend. The table has the
+// columns id, secret_hash, audiences and groups (comma separated),
+// token_lifetime (seconds) and redirect_uris (comma separated).
+func NewClients(driver, dsn, table string) api.ClientRegistry {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		panic(err)
+	}
+
+	// try to connect
+	if err := db.Ping(); err != nil {
+		panic(err)
+	}
+
+	log.Println("Connected to the clients database...")
+	return sqlClients{
+		db:    db,
+		table: table,
+	}
+}
+
+type sqlClients struct {
+	db    *sql.DB
+	table string
+}
+
+var _ api.ClientRegistry = sqlClients{}
+
+func (sc sqlClients) LookupClient(ctx context.Context, id string) (*oauthclient.Client, error) {
+	client := &oauthclient.Client{}
+	audiences, groups, redirectURIs := "", "", ""
+	query := fmt.Sprintf("select id, secret_hash, audiences, groups, token_lifetime, redirect_uris from %s where id=$1;", sc.table)
+
+	err := sc.db.
+		QueryRowContext(ctx, query, id).
+		Scan(&client.ID, &client.SecretHash, &audiences, &groups, &client.TokenLifetime, &redirectURIs)
+	if err == sql.ErrNoRows {
+		return nil, oauthclient.ErrUnknownClient
+	} else if err != nil {
+		return nil, err
+	}
+
+	if audiences != "" {
+		client.Audiences = strings.Split(audiences, ",")
+	}
+	if groups != "" {
+		client.Groups = strings.Split(groups, ",")
+	}
+	if redirectURIs != "" {
+		client.RedirectURIs = strings.Split(redirectURIs, ",")
+	}
+
+	return client, nil
+}
@@ -1,9 +1,9 @@
 package sql
 
 import (
-	"crypto/sha256"
+	"context"
 	"database/sql"
-	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
 	"strings"
@@ -12,6 +12,8 @@ import (
 	jwt "github.com/golang-jwt/jwt/v4"
 	"github.com/isi-nc/autentigo/api"
 	"github.com/isi-nc/autentigo/auth"
+	"github.com/isi-nc/autentigo/pkg/apikey"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
 
 	_ "github.com/lib/pq"
 )
@@ -20,16 +22,23 @@ import (
 type User struct {
 	Id           string
 	PasswordHash string `json:"password_hash"`
+	TOTPSecret   string `json:"totp_secret"`
+	// WebAuthnCredentials are the user's passkeys.
+	WebAuthnCredentials []webauthn.Credential `json:"webauthn_credentials"`
+	// APIKeys are the user's API keys (hashed).
+	APIKeys []apikey.Key `json:"api_keys"`
 	auth.ExtraClaims
 }
 
 type sqlAuth struct {
-	db    *sql.DB
-	table string
+	db     *sql.DB
+	table  string
+	rehash string
 }
 
-// New Authenticator with no backend
-func New(driver, dsn, table string) api.Authenticator {
+// New Authenticator with SQL backend. Password hashes are upgraded to the
+// rehash scheme on successful authentications (never if empty).
+func New(driver, dsn, table, rehash string) api.Authenticator {
 	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		panic(err)
@@ -42,40 +51,42 @@ func New(driver, dsn, table string) api.Authenticator {
 
 	log.Println("Connected to the database...")
 	return &sqlAuth{
-		db:    db,
-		table: table,
+		db:     db,
+		table:  table,
+		rehash: rehash,
 	}
 }
 
-var _ api.Authenticator = sqlAuth{}
-
-func (sa sqlAuth) Authenticate(user, password string, expiresAt time.Time) (claims jwt.Claims, err error) {
-	ba := sha256.Sum256([]byte(password))
-	passwordHash := hex.EncodeToString(ba[:])
-
-	u := User{}
-	groups := ""
-	query := fmt.Sprintf("select id, password_hash, display_name, email, email_verified, groups from %s where id=$1;", sa.table)
+var (
+	_ api.Authenticator  = sqlAuth{}
+	_ api.GroupsLookup   = sqlAuth{}
+	_ api.WebAuthnLookup = sqlAuth{}
+	_ api.APIKeyLookup   = sqlAuth{}
+)
 
-	err = sa.db.
-		QueryRow(query, user).
-		Scan(&u.Id, &u.PasswordHash, &u.DisplayName, &u.Email, &u.EmailVerified, &groups)
+func (sa sqlAuth) Authenticate(ctx context.Context, user, password string, expiresAt time.Time, req api.RequestInfo) (claims jwt.Claims, err error) {
+	u, err := sa.getUser(ctx, user)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("User %s not found", user)
-			err = api.ErrInvalidAuthentication
-			return
-		}
 		return
 	}
 
-	u.Groups = strings.Split(groups, ",")
+	newHash, err := api.CheckPassword(user, u.PasswordHash, password, sa.rehash)
+	if err != nil {
+		return
+	}
 
-	if u.PasswordHash != passwordHash {
-		err = api.ErrInvalidAuthentication
+	amr, err := api.CheckOTP(u.TOTPSecret, req)
+	if err != nil {
 		return
 	}
 
+	if newHash != "" {
+		query := fmt.Sprintf("update %s set password_hash=$1 where id=$2 and password_hash=$3;", sa.table)
+		if _, err := sa.db.ExecContext(ctx, query, newHash, user, u.PasswordHash); err != nil {
+			log.Printf("failed to rehash the password of %s: %v", user, err)
+		}
+	}
+
 	claims = auth.Claims{
 		StandardClaims: jwt.StandardClaims{
 			IssuedAt:  time.Now().Unix(),
@@ -83,6 +94,91 @@ func (sa sqlAuth) Authenticate(user, password string, expiresAt time.Time) (clai
 			Subject:   user,
 		},
 		ExtraClaims: u.ExtraClaims,
+		AMR:         amr,
+	}
+
+	return
+}
+
+func (sa sqlAuth) LookupGroups(ctx context.Context, user string) ([]string, error) {
+	u, err := sa.getUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.Groups, nil
+}
+
+func (sa sqlAuth) LookupWebAuthn(ctx context.Context, user string, expiresAt time.Time) ([]webauthn.Credential, jwt.Claims, error) {
+	u, err := sa.getUser(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return u.WebAuthnCredentials, auth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			Subject:   user,
+		},
+		ExtraClaims: u.ExtraClaims,
+	}, nil
+}
+
+func (sa sqlAuth) LookupAPIKeys(ctx context.Context, user string, expiresAt time.Time) ([]apikey.Key, jwt.Claims, error) {
+	u, err := sa.getUser(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return u.APIKeys, auth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			Subject:   user,
+		},
+		ExtraClaims: u.ExtraClaims,
+	}, nil
+}
+
+func (sa sqlAuth) getUser(ctx context.Context, user string) (u *User, err error) {
+	u = &User{}
+	groups := ""
+	var attributes []byte
+	var totpSecret sql.NullString
+	var credentials, apiKeys []byte
+	query := fmt.Sprintf("select id, password_hash, display_name, email, email_verified, groups, attributes, totp_secret, webauthn_credentials, api_keys from %s where id=$1;", sa.table)
+
+	err = sa.db.
+		QueryRowContext(ctx, query, user).
+		Scan(&u.Id, &u.PasswordHash, &u.DisplayName, &u.Email, &u.EmailVerified, &groups, &attributes, &totpSecret, &credentials, &apiKeys)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Printf("User %s not found", user)
+			err = api.ErrUnknownUser
+		}
+		return nil, err
+	}
+
+	u.Groups = strings.Split(groups, ",")
+	u.TOTPSecret = totpSecret.String
+
+	if len(attributes) != 0 {
+		if err = json.Unmarshal(attributes, &u.Attributes); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(credentials) != 0 {
+		if err = json.Unmarshal(credentials, &u.WebAuthnCredentials); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(apiKeys) != 0 {
+		if err = json.Unmarshal(apiKeys, &u.APIKeys); err != nil {
+			return nil, err
+		}
 	}
 
 	return
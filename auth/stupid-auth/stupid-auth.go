@@ -1,6 +1,7 @@
 package stupidauth
 
 import (
+	"context"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -16,7 +17,11 @@ type stupidAuth struct{}
 
 var _ api.Authenticator = stupidAuth{}
 
-func (sa stupidAuth) Authenticate(user, password string, expiresAt time.Time) (jwt.Claims, error) {
+func (sa stupidAuth) Authenticate(ctx context.Context, user, password string, expiresAt time.Time, req api.RequestInfo) (jwt.Claims, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	return jwt.StandardClaims{
 		IssuedAt:  time.Now().Unix(),
 		ExpiresAt: expiresAt.Unix(),
@@ -0,0 +1,94 @@
+package usersfile
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/isi-nc/autentigo/api"
+	"github.com/isi-nc/autentigo/pkg/oauthclient"
+)
+
+// NewClients returns a client registry with csv file backend. Lines are like
+// <client id>:<secret hash>:<audiences>:<groups>:<token lifetime in seconds>:<redirect URIs>,
+// where lists are comma separated, and trailing fields optional. Redirect URIs
+// must be quoted, since they contain colons.
+func NewClients(filePath string) api.ClientRegistry {
+	return clientsFile{filePath: filePath}
+}
+
+type clientsFile struct {
+	filePath string
+}
+
+var _ api.ClientRegistry = clientsFile{}
+
+func (c clientsFile) LookupClient(ctx context.Context, id string) (*oauthclient.Client, error) {
+	f, err := os.Open(c.filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = ':'
+	r.FieldsPerRecord = -1
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(record) < 2 || record[0] != id {
+			continue
+		}
+
+		return parseClient(record)
+	}
+
+	return nil, oauthclient.ErrUnknownClient
+}
+
+// parseClient reads a client's line.
+func parseClient(record []string) (client *oauthclient.Client, err error) {
+	client = &oauthclient.Client{ID: record[0], SecretHash: record[1]}
+
+	l := len(record)
+	switch {
+	case l >= 6:
+		client.RedirectURIs = splitList(record[5])
+		fallthrough
+	case l == 5:
+		if record[4] != "" {
+			if client.TokenLifetime, err = strconv.ParseInt(record[4], 10, 64); err != nil {
+				return nil, err
+			}
+		}
+		fallthrough
+	case l == 4:
+		client.Groups = splitList(record[3])
+		fallthrough
+	case l == 3:
+		client.Audiences = splitList(record[2])
+	}
+
+	return
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
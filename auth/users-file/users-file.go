@@ -1,11 +1,16 @@
 package usersfile
 
 import (
-	"crypto/sha256"
+	"bytes"
+	"context"
 	"encoding/csv"
-	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"io"
+	"io/ioutil"
+	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -13,6 +18,8 @@ import (
 
 	"github.com/isi-nc/autentigo/api"
 	"github.com/isi-nc/autentigo/auth"
+	"github.com/isi-nc/autentigo/pkg/apikey"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
 )
 
 var yesValues = map[string]bool{
@@ -21,39 +28,135 @@ var yesValues = map[string]bool{
 	"1":    true,
 }
 
-// New Authenticator with csv file backend
-func New(filePath string) api.Authenticator {
+// New Authenticator with csv file backend. Password hashes are upgraded to
+// the rehash scheme on successful authentications (never if empty), which
+// requires the file to be writable.
+func New(filePath, rehash string) api.Authenticator {
 	return &usersFileAuth{
 		filePath: filePath,
+		rehash:   rehash,
 	}
 }
 
 type usersFileAuth struct {
 	filePath string
+	rehash   string
 }
 
-var _ api.Authenticator = usersFileAuth{}
+var (
+	_ api.Authenticator  = usersFileAuth{}
+	_ api.GroupsLookup   = usersFileAuth{}
+	_ api.WebAuthnLookup = usersFileAuth{}
+	_ api.APIKeyLookup   = usersFileAuth{}
+)
 
-func (a usersFileAuth) Authenticate(user, password string, expiresAt time.Time) (jwt.Claims, error) {
-	ba := sha256.Sum256([]byte(password))
-	passwordHash := hex.EncodeToString(ba[:])
+// fileUser is a user's line.
+type fileUser struct {
+	hash        string
+	totpSecret  string
+	credentials []webauthn.Credential
+	apiKeys     []apikey.Key
+	claims      auth.ExtraClaims
+}
 
-	f, err := os.Open(a.filePath)
+func (a usersFileAuth) Authenticate(ctx context.Context, user, password string, expiresAt time.Time, req api.RequestInfo) (jwt.Claims, error) {
+	u, err := a.lookup(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	newHash, err := api.CheckPassword(user, u.hash, password, a.rehash)
+	if err != nil {
+		return nil, err
+	}
+
+	amr, err := api.CheckOTP(u.totpSecret, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if newHash != "" {
+		if err := a.updateHash(user, u.hash, newHash); err != nil {
+			log.Printf("failed to rehash the password of %s: %v", user, err)
+		}
+	}
+
+	return auth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			Subject:   user,
+		},
+		ExtraClaims: u.claims,
+		AMR:         amr,
+	}, nil
+}
+
+func (a usersFileAuth) LookupGroups(ctx context.Context, user string) ([]string, error) {
+	u, err := a.lookup(ctx, user)
 	if err != nil {
 		return nil, err
 	}
 
+	return u.claims.Groups, nil
+}
+
+func (a usersFileAuth) LookupWebAuthn(ctx context.Context, user string, expiresAt time.Time) ([]webauthn.Credential, jwt.Claims, error) {
+	u, err := a.lookup(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return u.credentials, auth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			Subject:   user,
+		},
+		ExtraClaims: u.claims,
+	}, nil
+}
+
+func (a usersFileAuth) LookupAPIKeys(ctx context.Context, user string, expiresAt time.Time) ([]apikey.Key, jwt.Claims, error) {
+	u, err := a.lookup(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return u.apiKeys, auth.Claims{
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: expiresAt.Unix(),
+			Subject:   user,
+		},
+		ExtraClaims: u.claims,
+	}, nil
+}
+
+// lookup finds the user's line.
+func (a usersFileAuth) lookup(ctx context.Context, user string) (u *fileUser, err error) {
+	f, err := os.Open(a.filePath)
+	if err != nil {
+		return
+	}
+
 	defer f.Close()
 
 	r := csv.NewReader(f)
 	r.Comma = ':'
+	r.FieldsPerRecord = -1
 
 	for {
-		record, err := r.Read()
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		var record []string
+		record, err = r.Read()
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return nil, err
+			return
 		}
 
 		if len(record) < 2 {
@@ -61,17 +164,40 @@ func (a usersFileAuth) Authenticate(user, password string, expiresAt time.Time)
 			continue
 		}
 
-		fileUser, hash := record[0], record[1]
-
-		if user != fileUser || hash != passwordHash {
+		if user != record[0] {
 			continue
 		}
 
-		claims := auth.ExtraClaims{}
+		u = &fileUser{hash: record[1]}
+		claims := &u.claims
 
 		l := len(record)
 		switch {
-		case l >= 6:
+		case l >= 10:
+			if record[9] != "" {
+				if err = json.Unmarshal([]byte(record[9]), &u.apiKeys); err != nil {
+					return nil, err
+				}
+			}
+			fallthrough
+		case l == 9:
+			if record[8] != "" {
+				if err = json.Unmarshal([]byte(record[8]), &u.credentials); err != nil {
+					return nil, err
+				}
+			}
+			fallthrough
+		case l == 8:
+			u.totpSecret = record[7]
+			fallthrough
+		case l == 7:
+			if record[6] != "" {
+				if err = json.Unmarshal([]byte(record[6]), &claims.Attributes); err != nil {
+					return nil, err
+				}
+			}
+			fallthrough
+		case l == 6:
 			claims.Groups = strings.Split(record[5], ",")
 			fallthrough
 		case l == 5:
@@ -84,15 +210,97 @@ func (a usersFileAuth) Authenticate(user, password string, expiresAt time.Time)
 			claims.DisplayName = record[2]
 		}
 
-		return auth.Claims{
-			StandardClaims: jwt.StandardClaims{
-				IssuedAt:  time.Now().Unix(),
-				ExpiresAt: expiresAt.Unix(),
-				Subject:   user,
-			},
-			ExtraClaims: claims,
-		}, nil
+		return
+	}
+
+	return nil, api.ErrUnknownUser
+}
+
+// updateHash replaces the password hash of the user, if it was not changed
+// since read. Only the user's line is rewritten, and the file is replaced
+// atomically. The companion API also rewrites the file: if it changed while
+// the hash was updated, the update is dropped (and done on a later login).
+// The check and the replacement are not atomic, so a write of the companion
+// in between would be lost; the window is only the time of a rename.
+func (a usersFileAuth) updateHash(user, oldHash, newHash string) error {
+	info, err := os.Stat(a.filePath)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(a.filePath)
+	if err != nil {
+		return err
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = ':'
+	r.FieldsPerRecord = -1
+
+	// find the span of the user's record
+	var record []string
+	var start, end int64
+	for {
+		start = r.InputOffset()
+
+		if record, err = r.Read(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if len(record) >= 2 && record[0] == user && record[1] == oldHash {
+			end = r.InputOffset()
+			break
+		}
+	}
+
+	record[1] = newHash
+
+	line := &bytes.Buffer{}
+	w := csv.NewWriter(line)
+	w.Comma = ':'
+	w.Write(record)
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return err
+	}
+
+	// keep the empty lines skipped before the record, and a missing final newline
+	for data[start] == '\n' || data[start] == '\r' {
+		start++
+	}
+	if data[end-1] != '\n' {
+		line.Truncate(line.Len() - 1)
+	}
+
+	data = append(data[:start:start], append(line.Bytes(), data[end:]...)...)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(a.filePath), ".users-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Chmod(info.Mode()); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if current, err := os.Stat(a.filePath); err != nil {
+		return err
+	} else if !os.SameFile(info, current) || !current.ModTime().Equal(info.ModTime()) || current.Size() != info.Size() {
+		return errors.New("the users file changed meanwhile")
 	}
 
-	return nil, api.ErrInvalidAuthentication
+	return os.Rename(tmp.Name(), a.filePath)
 }
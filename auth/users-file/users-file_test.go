@@ -0,0 +1,115 @@
+package usersfile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/isi-nc/autentigo/api"
+	"github.com/isi-nc/autentigo/pkg/passhash"
+	"github.com/isi-nc/autentigo/pkg/totp"
+)
+
+func TestRehashKeepsTheFile(t *testing.T) {
+	legacy, err := passhash.Hash(passhash.SHA256, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// quoted as written by hand, or by the companion API
+	lines := []string{
+		`bob:` + legacy + `:Bob:bob@example.com:true:dev:"{""team"": ""b""}"`,
+		``,
+		`alice:` + legacy + `:Alice:alice@example.com:true:dev,ops:"{""team"":""a"",""level"":3}":JBSWY3DPEHPK3PXP:"[]":"[{""id"":""k1"",""hash"":""h""}]"`,
+		`# not a comment, but a short line`,
+		`carol:` + legacy,
+	}
+	original := strings.Join(lines, "\n")
+
+	filePath := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(filePath, []byte(original), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	a := New(filePath, passhash.SSHA).(*usersFileAuth)
+	ctx := context.Background()
+
+	before, err := a.lookup(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := totp.Code("JBSWY3DPEHPK3PXP", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Authenticate(ctx, "alice", "ok", time.Now().Add(time.Hour), api.RequestInfo{OTP: code}); err != nil {
+		t.Fatal(err)
+	}
+
+	ba, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := strings.Split(string(ba), "\n")
+
+	if len(updated) != len(lines) {
+		t.Fatalf("expected %d lines, got %d:\n%s", len(lines), len(updated), ba)
+	}
+	for i, line := range lines {
+		if i != 2 && updated[i] != line {
+			t.Errorf("line %d changed:\n%s\n%s", i+1, line, updated[i])
+		}
+	}
+
+	after, err := a.lookup(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if passhash.Scheme(after.hash) != passhash.SSHA {
+		t.Errorf("expected an SSHA hash, got %q", after.hash)
+	}
+	if ok, err := passhash.Verify(after.hash, "ok"); !ok || err != nil {
+		t.Errorf("the new hash doesn't match the password: %v", err)
+	}
+
+	after.hash = before.hash
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("the user changed:\n%+v\n%+v", before, after)
+	}
+
+	if info, err := os.Stat(filePath); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0640 {
+		t.Errorf("the file mode changed to %v", info.Mode())
+	}
+
+	// a user changed meanwhile is not overwritten
+	if err := a.updateHash("bob", "other", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if ba2, _ := os.ReadFile(filePath); string(ba2) != string(ba) {
+		t.Error("the file changed for a stale hash")
+	}
+}
+
+func TestInvalidHashes(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(filePath, []byte("alice:{NOPE}abc\nbob:{BCRYPT}abc\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a := New(filePath, "")
+
+	for _, user := range []string{"alice", "bob"} {
+		if _, err := a.Authenticate(context.Background(), user, "ok", time.Now(), api.RequestInfo{}); err != api.ErrInvalidAuthentication {
+			t.Errorf("%s: expected ErrInvalidAuthentication, got %v", user, err)
+		}
+	}
+}
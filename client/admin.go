@@ -0,0 +1,111 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Lockout is the authentication failures state of a user or IP.
+type Lockout struct {
+	// Kind is "user" or "ip".
+	Kind        string     `json:"kind"`
+	Key         string     `json:"key"`
+	Failures    int        `json:"failures"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+// InvalidateCache drops the server's cached authentication results of the user,
+// or every cached result if user is empty. It requires the server's admin token.
+func (c *Client) InvalidateCache(adminToken, user string) (err error) {
+	path := "/admin/cache"
+	if user != "" {
+		path += "/" + url.PathEscape(user)
+	}
+
+	resp, err := c.admin(http.MethodDelete, path, adminToken)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		err = fmt.Errorf("cache invalidation failed: %s", resp.Status)
+	}
+
+	return
+}
+
+// Lockouts lists the users and IPs with recent authentication failures. It
+// requires the server's admin token.
+func (c *Client) Lockouts(adminToken string) (lockouts []Lockout, err error) {
+	resp, err := c.admin(http.MethodGet, "/admin/lockouts", adminToken)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("lockouts listing failed: %s", resp.Status)
+		return
+	}
+
+	list := struct {
+		Lockouts []Lockout `json:"lockouts"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	lockouts = list.Lockouts
+	return
+}
+
+// ClearLockout forgets the authentication failures of a user or IP (kind is
+// "user" or "ip"). It requires the server's admin token.
+func (c *Client) ClearLockout(adminToken, kind, key string) (err error) {
+	path := "/admin/lockouts/" + url.PathEscape(kind) + "/" + url.PathEscape(key)
+
+	resp, err := c.admin(http.MethodDelete, path, adminToken)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		err = fmt.Errorf("lockout clearing failed: %s", resp.Status)
+	}
+
+	return
+}
+
+// APIKeysLastUsed gives when the API keys were last used, by ID (unused keys
+// are missing). It requires the server's admin token.
+func (c *Client) APIKeysLastUsed(adminToken string, ids []string) (lastUsed map[string]time.Time, err error) {
+	resp, err := c.admin(http.MethodGet, "/admin/api-keys?"+url.Values{"id": ids}.Encode(), adminToken)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("API keys usage listing failed: %s", resp.Status)
+		return
+	}
+
+	usage := struct {
+		LastUsed map[string]time.Time `json:"last_used"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&usage)
+	lastUsed = usage.LastUsed
+	return
+}
+
+func (c *Client) admin(method, path, adminToken string) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.ServerURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+
+	return http.DefaultClient.Do(req)
+}
@@ -1,9 +1,17 @@
 package client
 
+import "sync"
+
 type Client struct {
 	ServerURL string
 
+	// Audience, if set, is required in validated tokens.
+	Audience string
+
 	validationCrt []byte
+
+	mutex   sync.RWMutex
+	revoked map[string]bool
 }
 
 func New(serverURL string) *Client {
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+type revocationList struct {
+	Revocations []struct {
+		ID        string `json:"jti"`
+		ExpiresAt int64  `json:"exp"`
+	} `json:"revocations"`
+}
+
+// RefreshRevocations fetches the server's list of revoked tokens, honoured by Validate.
+func (c *Client) RefreshRevocations() (err error) {
+	data, err := c.get("/revocations")
+	if err != nil {
+		return
+	}
+
+	list := revocationList{}
+	if err = json.Unmarshal(data, &list); err != nil {
+		return
+	}
+
+	revoked := make(map[string]bool, len(list.Revocations))
+	for _, r := range list.Revocations {
+		revoked[r.ID] = true
+	}
+
+	c.mutex.Lock()
+	c.revoked = revoked
+	c.mutex.Unlock()
+
+	return
+}
+
+// PollRevocations refreshes the revocation list every interval, until stop is closed.
+func (c *Client) PollRevocations(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.RefreshRevocations(); err != nil {
+			log.Print("failed to refresh revocations: ", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// IsRevoked checks if the token id (jti) is in the last fetched revocation list.
+func (c *Client) IsRevoked(tokenID string) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.revoked[tokenID]
+}
@@ -1,28 +1,161 @@
 package client
 
 import (
+	"bytes"
+	"crypto/x509"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/isi-nc/autentigo/pkg/keys"
 )
 
+// Parse parses and validates a token. The validation data can either be a
+// PEM encoded public key (or certificate chain), or a JSON Web Key Set in
+// which case the key is selected by the token's kid. Tokens restricted to an
+// audience are rejected: use ParseForAudience.
 func Parse(validationCrt []byte, tokenString string) (*jwt.Token, error) {
-	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		switch alg := token.Method.Alg(); alg {
-		case "ES256", "ES384", "ES512":
-			return jwt.ParseECPublicKeyFromPEM(validationCrt)
+	return withoutAudience(parse(validationCrt, tokenString))
+}
+
+// ParseForAudience parses and validates a token, that must be restricted to the given audience.
+func ParseForAudience(validationCrt []byte, tokenString, audience string) (*jwt.Token, error) {
+	token, err := parse(validationCrt, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyAudience(token, audience); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func parse(validationCrt []byte, tokenString string) (*jwt.Token, error) {
+	if isJWKSet(validationCrt) {
+		set, err := keys.ParseJWKSet(validationCrt)
+		if err != nil {
+			return nil, err
+		}
+		return parseWithKeySet(set, tokenString)
+	}
 
-		case "RS256", "RS384", "RS512":
-			return jwt.ParseRSAPublicKeyFromPEM(validationCrt)
+	pub, _, err := keys.ParsePublicKey(validationCrt)
+	if err != nil {
+		return nil, err
+	}
 
-		default:
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if alg := token.Method.Alg(); !keys.AlgorithmMatches(alg, pub) {
 			return nil, fmt.Errorf("unknown signing method: %s", alg)
 		}
+		return pub, nil
+	})
+}
+
+func verifyAudience(token *jwt.Token, audience string) error {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !claims.VerifyAudience(audience, true) {
+		return fmt.Errorf("token is not for audience %q", audience)
+	}
+	return nil
+}
+
+// withoutAudience rejects tokens restricted to an audience, which are only
+// for the services of that audience.
+func withoutAudience(token *jwt.Token, err error) (*jwt.Token, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(jwt.MapClaims); !ok {
+		return nil, fmt.Errorf("unexpected claims type")
+	} else if aud, ok := claims["aud"]; ok && aud != nil {
+		return nil, fmt.Errorf("token is restricted to an audience")
+	}
+
+	return token, nil
+}
+
+// ParseWithKeySet parses and validates a token using the key matching its kid.
+// Tokens restricted to an audience are rejected.
+func ParseWithKeySet(set *keys.JWKSet, tokenString string) (*jwt.Token, error) {
+	return withoutAudience(parseWithKeySet(set, tokenString))
+}
+
+func parseWithKeySet(set *keys.JWKSet, tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		var jwk *keys.JWK
+
+		if kid, ok := token.Header["kid"].(string); ok {
+			jwk = set.Lookup(kid)
+		} else if len(set.Keys) == 1 {
+			jwk = &set.Keys[0]
+		}
+
+		if jwk == nil {
+			return nil, fmt.Errorf("no validation key for token")
+		}
+
+		if alg := token.Method.Alg(); jwk.Alg != "" && jwk.Alg != alg {
+			return nil, fmt.Errorf("signing method %s does not match key (%s)", alg, jwk.Alg)
+		}
+
+		return jwk.PublicKey()
 	})
 }
 
+// ParseWithRoots parses and validates a token using the certificate chain in
+// its x5c header, that must be issued by one of the given roots. Tokens
+// restricted to an audience are rejected.
+func ParseWithRoots(roots *x509.CertPool, tokenString string) (*jwt.Token, error) {
+	return withoutAudience(jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		x5c, ok := token.Header["x5c"].([]interface{})
+		if !ok || len(x5c) == 0 {
+			return nil, fmt.Errorf("no x5c header in token")
+		}
+
+		encoded := make([]string, 0, len(x5c))
+		for _, v := range x5c {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid x5c header")
+			}
+			encoded = append(encoded, s)
+		}
+
+		chain, err := keys.DecodeChain(encoded)
+		if err != nil {
+			return nil, err
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range chain[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		if _, err := chain[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}); err != nil {
+			return nil, err
+		}
+
+		if alg := token.Method.Alg(); !keys.AlgorithmMatches(alg, chain[0].PublicKey) {
+			return nil, fmt.Errorf("unknown signing method: %s", alg)
+		}
+
+		return chain[0].PublicKey, nil
+	}))
+}
+
+func isJWKSet(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
 func (c *Client) Validate(tokenString string) (isValid bool, err error) {
 	if c.validationCrt == nil {
 		err = c.RefreshValidationCertificate()
@@ -31,33 +164,60 @@ func (c *Client) Validate(tokenString string) (isValid bool, err error) {
 		}
 	}
 
-	token, err := Parse(c.validationCrt, tokenString)
+	var token *jwt.Token
+	if c.Audience != "" {
+		token, err = ParseForAudience(c.validationCrt, tokenString, c.Audience)
+	} else {
+		token, err = Parse(c.validationCrt, tokenString)
+	}
 
 	if err != nil {
 		return
 	}
 
 	isValid = token.Valid
+
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		if jti, ok := claims["jti"].(string); ok && c.IsRevoked(jti) {
+			isValid = false
+		}
+	}
+
 	return
 }
 
+// RefreshValidationCertificate fetches the server's key set, falling back to
+// the raw certificate on servers not publishing one.
 func (c *Client) RefreshValidationCertificate() (err error) {
-	resp, err := http.Get(c.ServerURL + "/validation-certificate")
+	crt, err := c.get("/.well-known/jwks.json")
+	if err == errNotFound {
+		crt, err = c.get("/validation-certificate")
+	}
 	if err != nil {
 		return
 	}
 
-	defer resp.Body.Close()
+	c.validationCrt = crt
+	return
+}
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("unexpected HTTP status: %d (%s)", resp.StatusCode, resp.Status)
-	}
+var errNotFound = fmt.Errorf("not found")
 
-	crt, err := ioutil.ReadAll(resp.Body)
+func (c *Client) get(path string) (data []byte, err error) {
+	resp, err := http.Get(c.ServerURL + path)
 	if err != nil {
 		return
 	}
 
-	c.validationCrt = crt
-	return
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected HTTP status: %d (%s)", resp.StatusCode, resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
 }
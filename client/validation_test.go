@@ -0,0 +1,60 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestParseRejectsAudience(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validationCrt := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	sign := func(claims jwt.MapClaims) string {
+		claims["sub"] = "alice"
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+
+	plain := sign(jwt.MapClaims{})
+	forSvcA := sign(jwt.MapClaims{"aud": "svc-a"})
+
+	if _, err := Parse(validationCrt, plain); err != nil {
+		t.Errorf("Parse rejected a token without audience: %v", err)
+	}
+
+	if _, err := Parse(validationCrt, forSvcA); err == nil {
+		t.Error("Parse accepted a token restricted to an audience")
+	}
+
+	if _, err := ParseForAudience(validationCrt, forSvcA, "svc-a"); err != nil {
+		t.Errorf("ParseForAudience rejected a token for its audience: %v", err)
+	}
+
+	if _, err := ParseForAudience(validationCrt, forSvcA, "svc-b"); err == nil {
+		t.Error("ParseForAudience accepted a token for another audience")
+	}
+
+	if _, err := ParseForAudience(validationCrt, plain, "svc-a"); err == nil {
+		t.Error("ParseForAudience accepted a token without audience")
+	}
+}
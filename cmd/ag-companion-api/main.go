@@ -2,7 +2,7 @@ package main
 
 import (
 	"flag"
-
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
@@ -10,16 +10,21 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	restfulspec "github.com/emicklei/go-restful-openapi/v2"
 	restful "github.com/emicklei/go-restful/v3"
 
+	"github.com/isi-nc/autentigo/client"
 	companionapi "github.com/isi-nc/autentigo/pkg/companion-api/api"
 	"github.com/isi-nc/autentigo/pkg/companion-api/backend"
 	"github.com/isi-nc/autentigo/pkg/companion-api/backend/etcd"
 	"github.com/isi-nc/autentigo/pkg/companion-api/backend/sql"
 	"github.com/isi-nc/autentigo/pkg/companion-api/backend/users-file"
+	"github.com/isi-nc/autentigo/pkg/passhash"
 	"github.com/isi-nc/autentigo/pkg/rbac"
+	"github.com/isi-nc/autentigo/pkg/store"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
 )
 
 var (
@@ -28,6 +33,13 @@ var (
 	adminToken      = flag.String("admin-token", "", "Admin Token")
 	disableSecurity = flag.Bool("no-security", false, "Disable security, no auth required to call companion-api")
 	enableCors      = flag.Bool("cors", false, "Enable CORS support")
+	totpIssuer      = flag.String("totp-issuer", "autentigo", "Issuer name of TOTP secrets, shown by authenticator apps")
+	passwordScheme  = flag.String("password-scheme", passhash.Bcrypt, "Hash scheme of new passwords (BCRYPT, ARGON2, PBKDF2-SHA512, SSHA512, CRYPT...)")
+	webauthnRPID    = flag.String("webauthn-rp-id", "", "WebAuthn relying party ID: the domain passkeys are bound to (enables passkeys)")
+	webauthnRPName  = flag.String("webauthn-rp-name", "", "WebAuthn relying party name shown by authenticators (default: the RP ID)")
+	webauthnOrigins = flag.String("webauthn-origins", "", "Comma separated origins of the pages using WebAuthn (default: https://<RP ID>)")
+	webauthnUV      = flag.String("webauthn-user-verification", webauthn.VerificationPreferred, "WebAuthn user verification: required, preferred or discouraged")
+	apiKeyDuration  = flag.Duration("api-key-max-duration", 365*24*time.Hour, "Longest lifetime of API keys, and the default one (0 allows keys without expiry)")
 )
 
 func main() {
@@ -35,7 +47,11 @@ func main() {
 
 	var err error
 
-	crtData := requireEnv("TLS_CRT", "certificate used to validate tokens")
+	if !passhash.Supported(*passwordScheme) {
+		log.Fatal("unknown password hash scheme: ", *passwordScheme)
+	}
+
+	crtData := requireEnvData("TLS_CRT", "certificate used to validate tokens")
 
 	if os.Getenv("DISABLE_SECURITY") == "true" {
 		*disableSecurity = true
@@ -51,12 +67,24 @@ func main() {
 	if len(crtData) == 0 {
 		log.Fatal("Certificate empty, failed to load")
 	}
-	rbac.DefaultValidationCertificate = []byte(crtData)
+	rbac.DefaultValidationCertificate = crtData
 
 	cAPI := &companionapi.CompanionAPI{
 		Client:          getBackEndClient(),
 		AdminToken:      *adminToken,
 		DisableSecurity: *disableSecurity,
+		PasswordScheme:  *passwordScheme,
+		TOTPIssuer:      *totpIssuer,
+		WebAuthn:        webauthnConfig(),
+		Store:           store.NewMemory(),
+		Clients:         getClientRegistry(),
+
+		APIKeyMaxDuration: *apiKeyDuration,
+	}
+
+	if authServer := os.Getenv("AUTH_SERVER_URL"); authServer != "" {
+		cAPI.AuthServer = client.New(authServer)
+		cAPI.AuthServerAdminToken = requireEnv("AUTH_SERVER_ADMIN_TOKEN", "admin token of the auth server")
 	}
 
 	restful.DefaultRequestContentType(restful.MIME_JSON)
@@ -133,6 +161,53 @@ func getBackEndClient() backend.Client {
 	}
 }
 
+// getClientRegistry returns the registry of the OAuth2 clients, nil if disabled.
+func getClientRegistry() backend.ClientRegistry {
+	switch v := os.Getenv("CLIENTS_BACKEND"); v {
+	case "":
+		return nil
+	case "file":
+		return usersfile.NewClients(requireEnv("CLIENTS_FILE", "File containing the OAuth2 clients"))
+	case "etcd":
+		return etcd.NewClients(
+			envOr("CLIENTS_ETCD_PREFIX", "/autentigo/clients"),
+			strings.Split(requireEnv("ETCD_ENDPOINTS", "etcd endpoints"), ","))
+	case "sql":
+		return sql.NewClients(
+			requireEnv("SQL_DRIVER", "SQL driver (ex: postgres)"),
+			requireEnv("SQL_DSN", "SQL destination"),
+			envOr("SQL_CLIENT_TABLE", "clients"))
+	default:
+		log.Fatal("Unknown clients backend: ", v)
+		return nil
+	}
+}
+
+// webauthnConfig returns the WebAuthn configuration, if enabled.
+func webauthnConfig() *webauthn.Config {
+	if *webauthnRPID == "" {
+		return nil
+	}
+
+	switch *webauthnUV {
+	case webauthn.VerificationRequired, webauthn.VerificationPreferred, webauthn.VerificationDiscouraged:
+	default:
+		log.Fatal("invalid WebAuthn user verification: ", *webauthnUV)
+	}
+
+	config := &webauthn.Config{
+		RPID:             *webauthnRPID,
+		RPName:           *webauthnRPName,
+		UserVerification: *webauthnUV,
+	}
+
+	if *webauthnOrigins != "" {
+		config.Origins = strings.Split(*webauthnOrigins, ",")
+	}
+
+	return config
+}
+
 func requireEnv(name, description string) string {
 	v := os.Getenv(name)
 	if v == "" {
@@ -140,3 +215,25 @@ func requireEnv(name, description string) string {
 	}
 	return v
 }
+
+func envOr(name, defaultValue string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+// requireEnvData returns the PEM data given inline in the env, or read from the file it names.
+func requireEnvData(name, description string) []byte {
+	v := requireEnv(name, description+" (PEM data or file path)")
+
+	if strings.Contains(v, "-----BEGIN") {
+		return []byte(v)
+	}
+
+	ba, err := ioutil.ReadFile(v)
+	if err != nil {
+		log.Fatal("Env ", name, ": failed to read file: ", err)
+	}
+	return ba
+}
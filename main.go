@@ -1,7 +1,10 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
@@ -15,35 +18,103 @@ import (
 	restful "github.com/emicklei/go-restful/v3"
 	jwt "github.com/golang-jwt/jwt/v4"
 	"github.com/isi-nc/autentigo/api"
+	"github.com/isi-nc/autentigo/auth/cache"
+	"github.com/isi-nc/autentigo/auth/chain"
 	"github.com/isi-nc/autentigo/auth/etcd"
 	ldapbind "github.com/isi-nc/autentigo/auth/ldap-bind"
 	"github.com/isi-nc/autentigo/auth/mongo"
+	"github.com/isi-nc/autentigo/auth/router"
 	"github.com/isi-nc/autentigo/auth/sql"
 	stupidauth "github.com/isi-nc/autentigo/auth/stupid-auth"
 	usersfile "github.com/isi-nc/autentigo/auth/users-file"
+	"github.com/isi-nc/autentigo/pkg/certmap"
+	"github.com/isi-nc/autentigo/pkg/claimmap"
+	"github.com/isi-nc/autentigo/pkg/passhash"
+	"github.com/isi-nc/autentigo/pkg/ratelimit"
+	"github.com/isi-nc/autentigo/pkg/store"
+	etcdstore "github.com/isi-nc/autentigo/pkg/store/etcd"
+	sqlstore "github.com/isi-nc/autentigo/pkg/store/sql"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
 )
 
 var (
-	tokenDuration = flag.Duration("token-duration", 24*time.Hour, "Duration of emitted tokens")
-	bind          = flag.String("bind", ":8080", "HTTP bind specification")
-	tlsBind       = flag.String("tls-bind", ":8443", "HTTPS bind specification")
-	tlsKeyFile    = flag.String("tls-bind-key", "", "File containing the TLS listener's key")
-	tlsCertFile   = flag.String("tls-bind-cert", "", "File containing the TLS listener's certificate")
-	enableCors    = flag.Bool("cors", false, "Enable CORS support")
+	tokenDuration     = flag.Duration("token-duration", 24*time.Hour, "Duration of emitted tokens")
+	bind              = flag.String("bind", ":8080", "HTTP bind specification")
+	tlsBind           = flag.String("tls-bind", ":8443", "HTTPS bind specification")
+	tlsKeyFile        = flag.String("tls-bind-key", "", "File containing the TLS listener's key")
+	tlsCertFile       = flag.String("tls-bind-cert", "", "File containing the TLS listener's certificate")
+	tlsClientCAFile   = flag.String("tls-client-ca", "", "File containing the CA bundle of client certificates (enables the mtls route on the TLS listener)")
+	enableCors        = flag.Bool("cors", false, "Enable CORS support")
+	keyGracePeriod    = flag.Duration("key-grace-period", 24*time.Hour, "How long retired keys are still accepted to validate tokens")
+	keyReloadInterval = flag.Duration("key-reload-interval", time.Minute, "Interval between key directory (or signer key) checks (see KEYS_DIR and SIGNER_URL)")
+	signerTimeout     = flag.Duration("signer-timeout", 5*time.Second, "Timeout of requests to the external signer (see SIGNER_URL)")
+	x5cHeader         = flag.Bool("x5c-header", false, "Add the certificate chain of the signing key to tokens (x5c header)")
+	refreshDuration   = flag.Duration("refresh-token-duration", 0, "Duration of refresh tokens (0 disables them)")
+	exchangeDuration  = flag.Duration("exchange-token-duration", 15*time.Minute, "Maximum duration of tokens obtained by token exchange")
+	cacheTTL          = flag.Duration("auth-cache-ttl", 0, "Duration of cached successful authentications (0 disables the cache)")
+	cacheNegativeTTL  = flag.Duration("auth-cache-negative-ttl", 0, "Duration of cached failed authentications")
+	cacheSize         = flag.Int("auth-cache-size", 10000, "Maximum number of cached authentications")
+	lockoutUsers      = flag.Int("lockout-user-threshold", 0, "Failed authentications allowed per user before lockout (0 disables it)")
+	lockoutIPs        = flag.Int("lockout-ip-threshold", 0, "Failed authentications allowed per source IP before lockout (0 disables it)")
+	lockoutDelay      = flag.Duration("lockout-delay", time.Second, "First lockout delay, doubled on each new failure")
+	lockoutMaxDelay   = flag.Duration("lockout-max-delay", 15*time.Minute, "Maximum lockout delay")
+	lockoutWindow     = flag.Duration("lockout-window", time.Hour, "How long failures are remembered")
+	passwordRehash    = flag.String("password-rehash", "", "Password hash scheme (like BCRYPT) stored passwords are upgraded to on login (disabled if empty)")
+	issuer            = flag.String("issuer", os.Getenv("ISSUER"), "Issuer URL of emitted tokens (enables OpenID Connect discovery)")
+	webauthnRPID      = flag.String("webauthn-rp-id", "", "WebAuthn relying party ID: the domain passkeys are bound to (enables passkeys)")
+	webauthnRPName    = flag.String("webauthn-rp-name", "", "WebAuthn relying party name shown by authenticators (default: the RP ID)")
+	webauthnOrigins   = flag.String("webauthn-origins", "", "Comma separated origins of the pages using WebAuthn (default: https://<RP ID>)")
+	webauthnUV        = flag.String("webauthn-user-verification", webauthn.VerificationPreferred, "WebAuthn user verification: required, preferred or discouraged")
+	webauthnPending   = flag.Int("webauthn-max-pending", 100, "Passkey logins a client IP can start during the challenge timeout (0 disables the cap)")
 )
 
 func main() {
 	flag.Parse()
 
-	key, pubKey, sm, crtData := initJWT()
+	if *passwordRehash != "" && !passhash.Supported(*passwordRehash) {
+		log.Fatal("unknown password hash scheme: ", *passwordRehash)
+	}
+
+	keyRing, signer := initKeys()
 
 	hAPI := &api.API{
-		CRTData:       []byte(crtData),
 		Authenticator: getAuthenticator(),
-		PrivateKey:    key,
-		PublicKey:     pubKey,
-		SigningMethod: sm,
+		Keys:          keyRing,
+		Signer:        signer,
 		TokenDuration: *tokenDuration,
+		Issuer:        *issuer,
+		AdminToken:    os.Getenv("ADMIN_TOKEN"),
+		X5CHeader:     *x5cHeader,
+		Store:         getStore(),
+		WebAuthn:      webauthnConfig(),
+		Clients:       getClientRegistry(),
+
+		Lockout: api.LockoutPolicy{
+			UserThreshold: *lockoutUsers,
+			IPThreshold:   *lockoutIPs,
+			Delay:         *lockoutDelay,
+			MaxDelay:      *lockoutMaxDelay,
+			Window:        *lockoutWindow,
+		},
+
+		RefreshTokenDuration:  *refreshDuration,
+		ExchangeTokenDuration: *exchangeDuration,
+
+		WebAuthnMaxPending: *webauthnPending,
+	}
+
+	if mappingFile := os.Getenv("CLAIM_MAPPING_FILE"); mappingFile != "" {
+		mapping, err := claimmap.FromFile(mappingFile)
+		if err != nil {
+			log.Fatal("failed to load the claim mapping: ", err)
+		}
+		hAPI.ClaimMapping = mapping
+	}
+
+	clientCAs := clientCertificates(hAPI)
+
+	if clientsFile := os.Getenv("INTROSPECTION_CLIENTS_FILE"); clientsFile != "" {
+		hAPI.IntrospectionClients = usersfile.New(clientsFile, "")
 	}
 
 	restful.DefaultRequestContentType(restful.MIME_JSON)
@@ -52,6 +123,23 @@ func main() {
 
 	restful.Add(hAPI.Register())
 
+	if rateLimitFile := os.Getenv("RATE_LIMIT_FILE"); rateLimitFile != "" {
+		config, err := ratelimit.FromFile(rateLimitFile)
+		if err != nil {
+			log.Fatal("failed to load the rate limits: ", err)
+		}
+
+		limiter, err := ratelimit.New(config)
+		if err != nil {
+			log.Fatal("invalid rate limits: ", err)
+		}
+
+		restful.DefaultContainer.Filter(limiter.Filter)
+
+		// the IP lockout trusts the same proxies
+		hAPI.ClientIP = limiter.ClientIP
+	}
+
 	config := restfulspec.Config{
 		WebServices: restful.RegisteredWebServices(),
 		APIPath:     "/apidocs.json",
@@ -91,61 +179,100 @@ func main() {
 	}()
 
 	if *tlsKeyFile != "" && *tlsCertFile != "" {
+		server := &http.Server{
+			Addr:    *tlsBind,
+			Handler: restful.DefaultContainer,
+		}
+
+		if clientCAs != nil {
+			// other routes are still available without certificate
+			server.TLSConfig = &tls.Config{
+				ClientAuth: tls.VerifyClientCertIfGiven,
+				ClientCAs:  clientCAs,
+			}
+		}
+
 		go func() {
-			log.Print("TLS listening on ", *bind)
-			log.Fatal(http.ListenAndServeTLS(
-				*tlsBind, *tlsKeyFile, *tlsCertFile,
-				restful.DefaultContainer))
+			log.Print("TLS listening on ", *tlsBind)
+			log.Fatal(server.ListenAndServeTLS(*tlsCertFile, *tlsKeyFile))
 		}()
 
 	} else if *tlsKeyFile != "" || *tlsCertFile != "" {
 		log.Fatal("please specify both tls-key and tls-cert, or none.")
+	} else if clientCAs != nil {
+		log.Fatal("tls-client-ca requires the TLS listener (tls-bind-key and tls-bind-cert).")
 	}
 
 	log.Fatal(http.Serve(l, restful.DefaultContainer))
 }
 
-func initJWT() (key interface{}, cert interface{}, method jwt.SigningMethod, crtData string) {
-	crtData = requireEnv("TLS_CRT", "certificate used to sign/verify tokens")
-	keyData := requireEnv("TLS_KEY", "key used to sign tokens")
-	sm := requireEnv("SIGNING_METHOD", "signature method to use (must match the key)")
+func initKeys() (*api.KeyRing, api.Signer) {
+	var method jwt.SigningMethod
 
-	method = jwt.GetSigningMethod(sm)
+	if sm := os.Getenv("SIGNING_METHOD"); sm != "" {
+		method = jwt.GetSigningMethod(sm)
 
-	if method == nil {
-		log.Fatal("unknown signing method: ", sm)
+		if method == nil {
+			log.Fatal("unknown signing method: ", sm)
+		}
 	}
 
-	switch sm[:2] {
-	case "RS":
-		if x, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(keyData)); err == nil {
-			key = x
-		} else {
-			log.Fatal("Failed to load private key: ", err)
-		}
-		if x, err := jwt.ParseRSAPublicKeyFromPEM([]byte(crtData)); err == nil {
-			cert = x
-		} else {
-			log.Fatal("Failed to load public key: ", err)
+	if signerURL := os.Getenv("SIGNER_URL"); signerURL != "" {
+		signer, err := api.NewRemoteSigner(signerURL, *signerTimeout)
+		if err != nil {
+			log.Fatal(err)
 		}
 
-	case "ES":
-		if x, err := jwt.ParseECPrivateKeyFromPEM([]byte(keyData)); err == nil {
-			key = x
-		} else {
-			log.Fatal("Failed to load private key: ", err)
-		}
-		if x, err := jwt.ParseECPublicKeyFromPEM([]byte(crtData)); err == nil {
-			cert = x
-		} else {
-			log.Fatal("Failed to load public key: ", err)
+		key := signer.SigningKey()
+		log.Print("signing tokens with ", key.Method.Alg(), " through ", signerURL)
+
+		ring := api.NewKeyRing(key, *keyGracePeriod)
+
+		go signer.Watch(ring, *keyReloadInterval)
+
+		return ring, signer
+	}
+
+	if keysDir := os.Getenv("KEYS_DIR"); keysDir != "" {
+		active, verifyOnly, err := api.LoadKeyDir(keysDir, method)
+		if err != nil {
+			log.Fatal("Failed to load keys: ", err)
 		}
 
-	default:
-		log.Fatal("Invalid SIGNING_METHOD: ", sm)
+		ring := api.NewKeyRing(active, *keyGracePeriod)
+		ring.Update(active, verifyOnly)
+
+		go ring.WatchKeyDir(keysDir, method, *keyReloadInterval)
+
+		return ring, nil
 	}
 
-	return
+	crtData := requireEnvData("TLS_CRT", "certificate used to sign/verify tokens")
+	keyData := requireEnvData("TLS_KEY", "key used to sign tokens")
+
+	key, err := api.NewSigningKey(method, crtData, keyData)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Print("signing tokens with ", key.Method.Alg())
+
+	return api.NewKeyRing(key, *keyGracePeriod), nil
+}
+
+// requireEnvData returns the PEM data given inline in the env, or read from the file it names.
+func requireEnvData(name, description string) []byte {
+	v := requireEnv(name, description+" (PEM data or file path)")
+
+	if strings.Contains(v, "-----BEGIN") {
+		return []byte(v)
+	}
+
+	ba, err := ioutil.ReadFile(v)
+	if err != nil {
+		log.Fatal("Env ", name, ": failed to read file: ", err)
+	}
+	return ba
 }
 
 func requireEnv(name, description string) string {
@@ -157,38 +284,233 @@ func requireEnv(name, description string) string {
 }
 
 func getAuthenticator() api.Authenticator {
+	a := newAuthenticator(os.Getenv("AUTH_BACKEND"), "")
+
+	if *cacheTTL != 0 || *cacheNegativeTTL != 0 {
+		log.Printf("caching authentications (successes: %v, failures: %v)", *cacheTTL, *cacheNegativeTTL)
+		a = cache.New(a, *cacheTTL, *cacheNegativeTTL, *cacheSize)
+	}
 
-	switch v := os.Getenv("AUTH_BACKEND"); v {
+	return a
+}
+
+// newAuthenticator creates a backend of the given kind, configured by the env
+// variables with the given prefix.
+func newAuthenticator(kind, prefix string) api.Authenticator {
+	switch kind {
 	case "", "stupid":
 		return stupidauth.New()
 
 	case "file":
-		return usersfile.New(requireEnv("AUTH_FILE", "File containings users when using file auth"))
+		return usersfile.New(requireEnv(prefix+"AUTH_FILE", "File containings users when using file auth"), *passwordRehash)
 
 	case "ldap-bind":
+		var attributes []string
+		if v := os.Getenv(prefix + "LDAP_ATTRIBUTES"); v != "" {
+			attributes = strings.Split(v, ",")
+		}
+
 		return ldapbind.New(
-			requireEnv("LDAP_SERVER", "LDAP server"),
-			requireEnv("LDAP_USER", "LDAP user template (%s is substituted)"))
+			requireEnv(prefix+"LDAP_SERVER", "LDAP server"),
+			requireEnv(prefix+"LDAP_USER", "LDAP user template (%s is substituted)"),
+			attributes,
+			os.Getenv(prefix+"LDAP_SEARCH_DN"),
+			os.Getenv(prefix+"LDAP_SEARCH_PASSWORD"))
 
 	case "etcd":
 		return etcd.New(
-			requireEnv("ETCD_PREFIX", "etcd prefix"),
-			strings.Split(requireEnv("ETCD_ENDPOINTS", "etcd endpoints"), ","))
+			requireEnv(prefix+"ETCD_PREFIX", "etcd prefix"),
+			strings.Split(requireEnv(prefix+"ETCD_ENDPOINTS", "etcd endpoints"), ","),
+			*passwordRehash)
 
 	case "mongo":
 		return mongo.New(
+			requireEnv(prefix+"MONGO_DATABASE", "mongo database"),
+			requireEnv(prefix+"MONGO_COLLECTION", "mongo collection"),
+			requireEnv(prefix+"MONGO_FIELD", "field where to look the user (default: _id)"),
+			requireEnv(prefix+"MONGO_ENDPOINT", "mongo endpoint"),
+			*passwordRehash)
+
+	case "sql":
+		return sql.New(
+			requireEnv(prefix+"SQL_DRIVER", "SQL driver (ex: postgres)"),
+			requireEnv(prefix+"SQL_DSN", "SQL destination"),
+			requireEnv(prefix+"SQL_USER_TABLE", "sql table with stored users"),
+			*passwordRehash)
+
+	case "chain":
+		if prefix != "" {
+			log.Fatal("chains can't be nested")
+		}
+
+		backends := []chain.Backend{}
+		forEachNamedBackend("AUTH_CHAIN", func(name string, a api.Authenticator) {
+			backends = append(backends, chain.Backend{Name: name, Authenticator: a})
+		})
+
+		return chain.New(backends, os.Getenv("AUTH_CHAIN_MERGE_GROUPS") == "true")
+
+	case "router":
+		if prefix != "" {
+			log.Fatal("routers can't be nested")
+		}
+
+		config, err := router.FromFile(requireEnv("AUTH_ROUTES_FILE", "routes of the router"))
+		if err != nil {
+			log.Fatal("failed to load routes: ", err)
+		}
+
+		backends := map[string]api.Authenticator{}
+		forEachNamedBackend("AUTH_ROUTER_BACKENDS", func(name string, a api.Authenticator) {
+			backends[name] = a
+		})
+
+		a, err := router.New(config, backends)
+		if err != nil {
+			log.Fatal("invalid routes: ", err)
+		}
+		return a
+
+	default:
+		log.Fatal("Unknown authenticator: ", kind)
+		return nil
+	}
+}
+
+// forEachNamedBackend creates the backends listed in the env variable (name=kind,...),
+// each one configured by the env variables prefixed by its name.
+func forEachNamedBackend(envName string, f func(name string, a api.Authenticator)) {
+	for _, entry := range strings.Split(requireEnv(envName, "backends (name=kind,...)"), ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			log.Fatalf("invalid %s entry %q, expected name=kind", envName, entry)
+		}
+
+		name, kind := parts[0], parts[1]
+		f(name, newAuthenticator(kind, strings.ToUpper(strings.ReplaceAll(name, "-", "_"))+"_"))
+	}
+}
+
+// webauthnConfig returns the WebAuthn configuration, if enabled.
+func webauthnConfig() *webauthn.Config {
+	if *webauthnRPID == "" {
+		return nil
+	}
+
+	switch *webauthnUV {
+	case webauthn.VerificationRequired, webauthn.VerificationPreferred, webauthn.VerificationDiscouraged:
+	default:
+		log.Fatal("invalid WebAuthn user verification: ", *webauthnUV)
+	}
+
+	config := &webauthn.Config{
+		RPID:             *webauthnRPID,
+		RPName:           *webauthnRPName,
+		UserVerification: *webauthnUV,
+	}
+
+	if *webauthnOrigins != "" {
+		config.Origins = strings.Split(*webauthnOrigins, ",")
+	}
+
+	return config
+}
+
+// clientCertificates enables the mtls route, and returns the CAs of client
+// certificates (nil if disabled).
+func clientCertificates(hAPI *api.API) *x509.CertPool {
+	if *tlsClientCAFile == "" {
+		return nil
+	}
+
+	pem, err := ioutil.ReadFile(*tlsClientCAFile)
+	if err != nil {
+		log.Fatal("failed to read the client CAs: ", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		log.Fatal("no certificate found in ", *tlsClientCAFile)
+	}
+
+	hAPI.ClientCertificates = &certmap.Config{}
+
+	if mappingFile := os.Getenv("CLIENT_CERT_MAPPING_FILE"); mappingFile != "" {
+		if hAPI.ClientCertificates, err = certmap.FromFile(mappingFile); err != nil {
+			log.Fatal("failed to load the client certificate mapping: ", err)
+		}
+	}
+
+	backend := hAPI.Authenticator
+	if c, ok := backend.(*cache.Cache); ok {
+		// the cache forwards lookups
+		backend = c.Backend()
+	}
+
+	if _, ok := backend.(api.GroupsLookup); hAPI.ClientCertificates.LookupGroups() && !ok {
+		log.Fatal("client certificate groups: the auth backend can't look up groups")
+	}
+
+	return pool
+}
+
+func getStore() store.Store {
+	switch v := os.Getenv("STORE_BACKEND"); v {
+	case "", "memory":
+		return store.NewMemory()
+
+	case "etcd":
+		return etcdstore.New(
+			envOr("STORE_ETCD_PREFIX", "/autentigo"),
+			strings.Split(requireEnv("ETCD_ENDPOINTS", "etcd endpoints"), ","))
+
+	case "sql":
+		return sqlstore.New(
+			requireEnv("SQL_DRIVER", "SQL driver (ex: postgres)"),
+			requireEnv("SQL_DSN", "SQL destination"),
+			envOr("STORE_SQL_TABLE", "autentigo_state"))
+
+	default:
+		log.Fatal("Unknown store: ", v)
+		return nil
+	}
+}
+
+// getClientRegistry returns the registry of the client credentials grant, nil if disabled.
+func getClientRegistry() api.ClientRegistry {
+	switch v := os.Getenv("CLIENTS_BACKEND"); v {
+	case "":
+		return nil
+
+	case "file":
+		return usersfile.NewClients(requireEnv("CLIENTS_FILE", "File containing the OAuth2 clients"))
+
+	case "etcd":
+		return etcd.NewClients(
+			envOr("CLIENTS_ETCD_PREFIX", "/autentigo/clients"),
+			strings.Split(requireEnv("ETCD_ENDPOINTS", "etcd endpoints"), ","))
+
+	case "mongo":
+		return mongo.NewClients(
 			requireEnv("MONGO_DATABASE", "mongo database"),
-			requireEnv("MONGO_COLLECTION", "mongo collection"),
-			requireEnv("MONGO_FIELD", "field where to look the user (default: _id)"),
+			envOr("MONGO_CLIENT_COLLECTION", "clients"),
 			requireEnv("MONGO_ENDPOINT", "mongo endpoint"))
 
 	case "sql":
-		return sql.New(
+		return sql.NewClients(
 			requireEnv("SQL_DRIVER", "SQL driver (ex: postgres)"),
 			requireEnv("SQL_DSN", "SQL destination"),
-			requireEnv("SQL_USER_TABLE", "sql table with stored users"))
+			envOr("SQL_CLIENT_TABLE", "clients"))
+
 	default:
-		log.Fatal("Unknown authenticator: ", v)
+		log.Fatal("Unknown clients backend: ", v)
 		return nil
 	}
 }
+
+func envOr(name, defaultValue string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return defaultValue
+}
@@ -0,0 +1,122 @@
+// Package apikey implements API keys (personal access tokens): random secrets
+// given once to their user, and stored as SHA-256 hashes. Keys look like
+// agk_<user>.<id>.<secret>, the user being base64url encoded, so they can be
+// used without user name.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// Prefix starts every key, to tell them from passwords.
+const Prefix = "agk_"
+
+const (
+	idSize     = 8
+	secretSize = 32
+)
+
+var encoding = base64.RawURLEncoding
+
+// Key is a stored API key. The key itself is not kept, only its hash.
+type Key struct {
+	// ID identifies the key (hex), as used in URLs.
+	ID string `json:"id" bson:"id"`
+	// Name helps the user tell their keys apart (like "ci").
+	Name string `json:"name,omitempty" bson:"name,omitempty"`
+	// Hash is the SHA-256 of the key (hex).
+	Hash      string    `json:"hash" bson:"hash"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	// ExpiresAt is the end of validity of the key (nil if it doesn't expire).
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+}
+
+// Generate returns a new key of the user, and its stored form.
+func Generate(user, name string, expiresAt *time.Time) (key string, stored *Key, err error) {
+	id := make([]byte, idSize)
+	if _, err = rand.Read(id); err != nil {
+		return
+	}
+
+	secret := make([]byte, secretSize)
+	if _, err = rand.Read(secret); err != nil {
+		return
+	}
+
+	stored = &Key{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		ExpiresAt: expiresAt,
+	}
+
+	key = Prefix + encoding.EncodeToString([]byte(user)) + "." + stored.ID + "." + encoding.EncodeToString(secret)
+	stored.Hash = hash(key)
+
+	return
+}
+
+// IsKey tells if the string looks like an API key (it may still be invalid).
+func IsKey(s string) bool {
+	return strings.HasPrefix(s, Prefix)
+}
+
+// Parse returns the user and ID of a key, ok being false if it's not a well-formed key.
+func Parse(key string) (user, id string, ok bool) {
+	if !IsKey(key) {
+		return
+	}
+
+	parts := strings.Split(key[len(Prefix):], ".")
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return
+	}
+
+	ba, err := encoding.DecodeString(parts[0])
+	if err != nil || len(ba) == 0 {
+		return
+	}
+
+	return string(ba), parts[1], true
+}
+
+// Find returns the stored key matching the key, if it's valid at the given time.
+// The hashes are compared in constant time.
+func Find(keys []Key, key string, now time.Time) *Key {
+	_, id, ok := Parse(key)
+	if !ok {
+		return nil
+	}
+
+	h := hash(key)
+
+	for i, k := range keys {
+		if k.ID != id {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(h)) != 1 || k.Expired(now) {
+			return nil
+		}
+
+		return &keys[i]
+	}
+
+	return nil
+}
+
+// Expired tells if the key is expired at the given time.
+func (k *Key) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+func hash(key string) string {
+	ba := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(ba[:])
+}
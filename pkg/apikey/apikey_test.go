@@ -0,0 +1,83 @@
+package apikey
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateAndFind(t *testing.T) {
+	key, stored, err := Generate("jane.doe@example.com", "ci", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(stored.Hash, key) || stored.Name != "ci" {
+		t.Errorf("unexpected stored key: %+v", stored)
+	}
+
+	user, id, ok := Parse(key)
+	if !ok || user != "jane.doe@example.com" || id != stored.ID {
+		t.Fatalf("parse %q: %q %q %v", key, user, id, ok)
+	}
+
+	other, otherStored, err := Generate("jane.doe@example.com", "other", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []Key{*otherStored, *stored}
+	now := time.Now()
+
+	if k := Find(keys, key, now); k == nil || k.ID != stored.ID {
+		t.Errorf("key not found: %v", k)
+	}
+	if k := Find(keys, other, now); k == nil || k.ID != otherStored.ID {
+		t.Errorf("other key not found: %v", k)
+	}
+
+	// same ID, other secret
+	forged := key[:strings.LastIndexByte(key, '.')+1] + "AAAA"
+	if k := Find(keys, forged, now); k != nil {
+		t.Errorf("forged key found: %v", k)
+	}
+
+	if k := Find(keys[:1], key, now); k != nil {
+		t.Errorf("revoked key found: %v", k)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	exp := time.Now().Add(time.Hour)
+
+	key, stored, err := Generate("u", "", &exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []Key{*stored}
+
+	if Find(keys, key, exp.Add(-time.Minute)) == nil {
+		t.Error("key not found before its expiry")
+	}
+	if Find(keys, key, exp) != nil {
+		t.Error("expired key found")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, key := range []string{
+		"",
+		"password",
+		"agk_",
+		"agk_dQ.id",
+		"agk_dQ.id.",
+		"agk_.id.secret",
+		"agk_!!.id.secret",
+		"agk_dQ.id.secret.more",
+	} {
+		if _, _, ok := Parse(key); ok {
+			t.Errorf("%q should not parse", key)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Package certmap maps client certificates (mutual TLS) to token subjects and groups.
+package certmap
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	yaml "github.com/projectcalico/go-yaml-wrapper"
+)
+
+// Certificate fields giving the subject.
+const (
+	// SubjectCN is the subject's common name.
+	SubjectCN = "cn"
+	// SubjectDNS is the first DNS name SAN.
+	SubjectDNS = "dns"
+	// SubjectEmail is the first email address SAN.
+	SubjectEmail = "email"
+	// SubjectURI is the first URI SAN (like a SPIFFE ID).
+	SubjectURI = "uri"
+)
+
+// Sources of the groups.
+const (
+	// GroupsOU are the subject's organizational units.
+	GroupsOU = "ou"
+	// GroupsLookup are the groups given by the authentication backend, which must know the subject.
+	GroupsLookup = "lookup"
+	// GroupsNone gives no groups.
+	GroupsNone = "none"
+)
+
+// ErrNoSubject indicates a certificate without the field giving the subject, or matching no rule.
+var ErrNoSubject = errors.New("no subject in the client certificate")
+
+// Config tells how client certificates are mapped. The zero value maps the
+// common name to the subject and the organizational units to the groups.
+type Config struct {
+	// Subject is the field giving the subject: cn (default), dns, email or uri.
+	Subject string
+
+	// Rules map the certificate's subject DN (like CN=build-1,OU=ci,O=Example)
+	// to the subject instead. The first matching rule is used, and
+	// certificates matching none are refused.
+	Rules []Rule
+
+	// Groups is where the groups come from: ou (default), lookup or none.
+	Groups string
+}
+
+// Rule maps the subject DNs it matches.
+type Rule struct {
+	// Regexp matching the subject DN.
+	Regexp string
+	// Sub is the subject, where $1 or ${name} are replaced by the submatches
+	// (default: the first submatch).
+	Sub string
+
+	re *regexp.Regexp
+}
+
+// FromFile loads the configuration from a YAML file.
+func FromFile(path string) (config *Config, err error) {
+	ba, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	return FromBytes(ba)
+}
+
+// FromBytes loads the configuration from YAML data.
+func FromBytes(ba []byte) (config *Config, err error) {
+	config = &Config{}
+
+	if err = yaml.UnmarshalStrict(ba, config); err != nil {
+		return
+	}
+
+	if err = config.compile(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+func (c *Config) compile() error {
+	switch c.Subject {
+	case "", SubjectCN, SubjectDNS, SubjectEmail, SubjectURI:
+	default:
+		return fmt.Errorf("subject: unknown field %q", c.Subject)
+	}
+
+	switch c.Groups {
+	case "", GroupsOU, GroupsLookup, GroupsNone:
+	default:
+		return fmt.Errorf("groups: unknown source %q", c.Groups)
+	}
+
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+
+		re, err := regexp.Compile(rule.Regexp)
+		if err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+		if rule.Sub == "" && re.NumSubexp() == 0 {
+			return fmt.Errorf("rule %d: a sub or a submatch is required", i)
+		}
+		rule.re = re
+	}
+
+	return nil
+}
+
+// LookupGroups tells if the groups must be looked up in the authentication backend.
+func (c *Config) LookupGroups() bool {
+	return c.Groups == GroupsLookup
+}
+
+// MapSubject returns the subject of the certificate.
+func (c *Config) MapSubject(cert *x509.Certificate) (string, error) {
+	if len(c.Rules) != 0 {
+		dn := cert.Subject.String()
+
+		for _, rule := range c.Rules {
+			match := rule.re.FindStringSubmatchIndex(dn)
+			if match == nil {
+				continue
+			}
+
+			template := rule.Sub
+			if template == "" {
+				template = "$1"
+			}
+
+			if sub := string(rule.re.ExpandString(nil, template, dn, match)); sub != "" {
+				return sub, nil
+			}
+		}
+
+		return "", ErrNoSubject
+	}
+
+	var sub string
+	switch c.Subject {
+	case "", SubjectCN:
+		sub = cert.Subject.CommonName
+	case SubjectDNS:
+		if len(cert.DNSNames) != 0 {
+			sub = cert.DNSNames[0]
+		}
+	case SubjectEmail:
+		if len(cert.EmailAddresses) != 0 {
+			sub = cert.EmailAddresses[0]
+		}
+	case SubjectURI:
+		if len(cert.URIs) != 0 {
+			sub = cert.URIs[0].String()
+		}
+	}
+
+	if sub == "" {
+		return "", ErrNoSubject
+	}
+
+	return sub, nil
+}
+
+// MapGroups returns the groups of the certificate, unless they must be looked up.
+func (c *Config) MapGroups(cert *x509.Certificate) []string {
+	if c.Groups != "" && c.Groups != GroupsOU {
+		return nil
+	}
+
+	return cert.Subject.OrganizationalUnit
+}
+
+// Thumbprint returns the SHA-256 thumbprint of the certificate (base64url),
+// as in the x5t#S256 confirmation of certificate-bound tokens (RFC 8705).
+func Thumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
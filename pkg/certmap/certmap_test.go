@@ -0,0 +1,124 @@
+package certmap
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func testCert() *x509.Certificate {
+	spiffe, _ := url.Parse("spiffe://example.com/ci/build-1")
+
+	return &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:         "build-1",
+			OrganizationalUnit: []string{"ci", "deployers"},
+			Organization:       []string{"Example"},
+		},
+		DNSNames:       []string{"build-1.example.com", "build.example.com"},
+		EmailAddresses: []string{"ci@example.com"},
+		URIs:           []*url.URL{spiffe},
+	}
+}
+
+func TestMapSubject(t *testing.T) {
+	cert := testCert()
+
+	for _, tc := range []struct {
+		config, sub string
+	}{
+		{``, "build-1"},
+		{`subject: dns`, "build-1.example.com"},
+		{`subject: email`, "ci@example.com"},
+		{`subject: uri`, "spiffe://example.com/ci/build-1"},
+		{`
+rules:
+- regexp: '^CN=([^,]+),OU=admins'
+  sub: admin-$1
+- regexp: '^CN=([^,]+),OU=ci\+OU=deployers,O=Example$'
+  sub: machine-$1
+`, "machine-build-1"},
+		{`
+rules:
+- regexp: '^CN=(?P<host>[^,]+),'
+`, "build-1"},
+	} {
+		config, err := FromBytes([]byte(tc.config))
+		if err != nil {
+			t.Fatalf("%q: %v", tc.config, err)
+		}
+
+		sub, err := config.MapSubject(cert)
+		if err != nil {
+			t.Errorf("%q: %v", tc.config, err)
+		} else if sub != tc.sub {
+			t.Errorf("%q: got %q, expected %q", tc.config, sub, tc.sub)
+		}
+	}
+}
+
+func TestMapSubjectMissing(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "x"}}
+
+	for _, c := range []string{
+		`subject: dns`,
+		`subject: uri`,
+		`
+rules:
+- regexp: '^CN=y$'
+  sub: y
+`,
+	} {
+		config, err := FromBytes([]byte(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if sub, err := config.MapSubject(cert); err != ErrNoSubject {
+			t.Errorf("%q: expected no subject, got %q, %v", c, sub, err)
+		}
+	}
+}
+
+func TestMapGroups(t *testing.T) {
+	cert := testCert()
+
+	for _, tc := range []struct {
+		config string
+		groups []string
+		lookup bool
+	}{
+		{``, []string{"ci", "deployers"}, false},
+		{`groups: ou`, []string{"ci", "deployers"}, false},
+		{`groups: lookup`, nil, true},
+		{`groups: none`, nil, false},
+	} {
+		config, err := FromBytes([]byte(tc.config))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if groups := config.MapGroups(cert); !reflect.DeepEqual(groups, tc.groups) {
+			t.Errorf("%q: got %v, expected %v", tc.config, groups, tc.groups)
+		}
+		if config.LookupGroups() != tc.lookup {
+			t.Errorf("%q: expected lookup %v", tc.config, tc.lookup)
+		}
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for _, c := range []string{
+		`subject: serial`,
+		`groups: o`,
+		`rules: [{regexp: '('}]`,
+		`rules: [{regexp: '^CN=x'}]`,
+		`unknown: true`,
+	} {
+		if _, err := FromBytes([]byte(c)); err == nil {
+			t.Errorf("%q should be invalid", c)
+		}
+	}
+}
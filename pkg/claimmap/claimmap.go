@@ -0,0 +1,191 @@
+package claimmap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v4"
+	yaml "github.com/projectcalico/go-yaml-wrapper"
+)
+
+// AttributesClaim is the claim holding the backend's user attributes. It never
+// reaches tokens as is: only the attributes mapped by the configuration do.
+const AttributesClaim = "attributes"
+
+// reserved claims are managed by the server and can't be mapped to.
+var reserved = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true,
+	AttributesClaim: true,
+}
+
+// consumed claims are read back by the server (token reviews, keystone,
+// introspection, exchange...), so they can't be renamed away.
+var consumed = map[string]bool{
+	"groups": true, "amr": true,
+}
+
+// Config describes how the claims given by a backend are turned into token claims.
+// The steps are applied in the order of the fields.
+type Config struct {
+	// Attributes maps backend attributes to claims (attribute: claim).
+	Attributes map[string]string
+
+	// Copy copies claims to other claims (from: to).
+	Copy map[string]string
+
+	// Rename renames claims (from: to).
+	Rename map[string]string
+
+	// Static claims added to every token.
+	Static map[string]interface{}
+}
+
+// FromFile loads the configuration from a YAML file.
+func FromFile(path string) (config *Config, err error) {
+	ba, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	return FromBytes(ba)
+}
+
+// FromBytes loads the configuration from YAML data.
+func FromBytes(ba []byte) (config *Config, err error) {
+	config = &Config{}
+
+	if err = yaml.UnmarshalStrict(ba, config); err != nil {
+		return
+	}
+
+	if err = config.validate(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+func (c *Config) validate() error {
+	for _, to := range c.Attributes {
+		if reserved[to] {
+			return fmt.Errorf("attributes: can't map to reserved claim %q", to)
+		}
+	}
+
+	for _, to := range c.Copy {
+		if reserved[to] {
+			return fmt.Errorf("copy: can't copy to reserved claim %q", to)
+		}
+	}
+
+	for from, to := range c.Rename {
+		if reserved[from] || reserved[to] {
+			return fmt.Errorf("rename: can't rename %q to %q, reserved claim", from, to)
+		}
+		if consumed[from] {
+			return fmt.Errorf("rename: can't rename %q, the server reads it (copy it instead)", from)
+		}
+	}
+
+	for name := range c.Static {
+		if reserved[name] {
+			return fmt.Errorf("static: can't set reserved claim %q", name)
+		}
+	}
+
+	return nil
+}
+
+// Apply maps the claims in place. A nil configuration only removes the backend's attributes.
+func (c *Config) Apply(claims jwt.MapClaims) {
+	attributes, _ := claims[AttributesClaim].(map[string]interface{})
+	delete(claims, AttributesClaim)
+
+	if c == nil {
+		return
+	}
+
+	for attribute, to := range c.Attributes {
+		if v, ok := attributes[attribute]; ok {
+			claims[to] = v
+		}
+	}
+
+	// copies and renames read the claims as they were before the step, so the result doesn't depend on map order
+	copied := jwt.MapClaims{}
+	for from, to := range c.Copy {
+		if v, ok := claims[from]; ok {
+			copied[to] = v
+		}
+	}
+	for name, v := range copied {
+		claims[name] = v
+	}
+
+	renamed := jwt.MapClaims{}
+	for from, to := range c.Rename {
+		if v, ok := claims[from]; ok {
+			renamed[to] = v
+		}
+	}
+	for from := range c.Rename {
+		delete(claims, from)
+	}
+	for name, v := range renamed {
+		claims[name] = v
+	}
+
+	for name, v := range c.Static {
+		claims[name] = v
+	}
+}
+
+// Renames tells if the claim is renamed, and so never in tokens under its name.
+func (c *Config) Renames(claim string) bool {
+	if c == nil {
+		return false
+	}
+
+	_, ok := c.Rename[claim]
+	return ok
+}
+
+// Copies lists the claims the claim is copied to.
+func (c *Config) Copies(claim string) (claims []string) {
+	if c == nil {
+		return
+	}
+
+	for from, to := range c.Copy {
+		if from == claim {
+			claims = append(claims, to)
+		}
+	}
+
+	sort.Strings(claims)
+	return
+}
+
+// Claims lists the claims the configuration may add to tokens.
+func (c *Config) Claims() (claims []string) {
+	if c == nil {
+		return
+	}
+
+	for _, to := range c.Attributes {
+		claims = append(claims, to)
+	}
+	for _, to := range c.Copy {
+		claims = append(claims, to)
+	}
+	for _, to := range c.Rename {
+		claims = append(claims, to)
+	}
+	for name := range c.Static {
+		claims = append(claims, name)
+	}
+
+	sort.Strings(claims)
+	return
+}
@@ -0,0 +1,97 @@
+package claimmap
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const testConfig = `
+attributes:
+  department: department
+  employeeNumber: employee_id
+copy:
+  groups: roles
+rename:
+  display_name: name
+  email: mail
+static:
+  tenant: acme
+`
+
+func TestApply(t *testing.T) {
+	config, err := FromBytes([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{
+		"sub":          "bob",
+		"display_name": "Bob",
+		"groups":       []interface{}{"admin"},
+		"attributes": map[string]interface{}{
+			"department":     "IT",
+			"employeeNumber": "42",
+			"salary":         "secret",
+		},
+	}
+
+	config.Apply(claims)
+
+	expected := jwt.MapClaims{
+		"sub":         "bob",
+		"name":        "Bob",
+		"groups":      []interface{}{"admin"},
+		"roles":       []interface{}{"admin"},
+		"department":  "IT",
+		"employee_id": "42",
+		"tenant":      "acme",
+	}
+
+	if !reflect.DeepEqual(claims, expected) {
+		t.Errorf("got %v, expected %v", claims, expected)
+	}
+}
+
+func TestNilConfigRemovesAttributes(t *testing.T) {
+	claims := jwt.MapClaims{"sub": "bob", "attributes": map[string]interface{}{"a": "b"}}
+
+	var config *Config
+	config.Apply(claims)
+
+	if _, ok := claims["attributes"]; ok {
+		t.Error("attributes not removed")
+	}
+}
+
+func TestReservedClaims(t *testing.T) {
+	for _, config := range []string{
+		"static: {sub: admin}",
+		"copy: {email: exp}",
+		"rename: {jti: id}",
+		"attributes: {uid: iss}",
+		"rename: {groups: roles}",
+		"rename: {amr: methods}",
+	} {
+		if _, err := FromBytes([]byte(config)); err == nil {
+			t.Errorf("%q: expected an error", config)
+		}
+	}
+}
+
+func TestCopies(t *testing.T) {
+	config, err := FromBytes([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if copies := config.Copies("groups"); !reflect.DeepEqual(copies, []string{"roles"}) {
+		t.Errorf("got copies %v, expected [roles]", copies)
+	}
+
+	var none *Config
+	if copies := none.Copies("groups"); copies != nil {
+		t.Errorf("a nil configuration has no copies, got %v", copies)
+	}
+}
@@ -1,11 +1,16 @@
 package api
 
 import (
+	"log"
 	"net/http"
+	"time"
 
 	restful "github.com/emicklei/go-restful/v3"
+	"github.com/isi-nc/autentigo/client"
 	"github.com/isi-nc/autentigo/pkg/companion-api/backend"
 	"github.com/isi-nc/autentigo/pkg/rbac"
+	"github.com/isi-nc/autentigo/pkg/store"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
 )
 
 var (
@@ -26,6 +31,29 @@ type CompanionAPI struct {
 	Client     backend.Client
 	AdminToken string
 	DisableSecurity bool
+
+	// PasswordScheme is the hash scheme of new passwords (see passhash, default: BCRYPT).
+	PasswordScheme string
+
+	// TOTPIssuer is the issuer name shown by authenticator apps (default: autentigo).
+	TOTPIssuer string
+
+	// WebAuthn enables passkey registration (nil disables it). Its relying
+	// party must be the one of the auth server.
+	WebAuthn *webauthn.Config
+	// Store holds the pending WebAuthn registrations.
+	Store store.Store
+
+	// APIKeyMaxDuration is the longest lifetime of API keys (0 allows keys without expiry).
+	APIKeyMaxDuration time.Duration
+
+	// Clients is the registry of the OAuth2 clients (nil disables their management).
+	Clients backend.ClientRegistry
+
+	// AuthServer, if set, is told when users change, to drop its cached authentications.
+	AuthServer *client.Client
+	// AuthServerAdminToken is the admin token of the AuthServer.
+	AuthServerAdminToken string
 }
 
 // Register provide a restful.WebService from this API
@@ -34,6 +62,19 @@ func (cApi *CompanionAPI) WebServices() []*restful.WebService {
 		cApi.healthWS(),
 		cApi.meWS(),
 		cApi.usersWS(),
+		cApi.lockoutsWS(),
+		cApi.clientsWS(),
+	}
+}
+
+// userChanged tells the auth server to forget its cached authentications of the user.
+func (cApi *CompanionAPI) userChanged(id string) {
+	if cApi.AuthServer == nil {
+		return
+	}
+
+	if err := cApi.AuthServer.InvalidateCache(cApi.AuthServerAdminToken, id); err != nil {
+		log.Print("failed to invalidate the auth server's cache for user ", id, ": ", err)
 	}
 }
 
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/isi-nc/autentigo/pkg/apikey"
+	"github.com/isi-nc/autentigo/pkg/companion-api/backend"
+	"github.com/isi-nc/autentigo/pkg/rbac"
+)
+
+var (
+	// ErrMissingAPIKeyName indicates an API key without name.
+	ErrMissingAPIKeyName = restful.NewError(http.StatusUnprocessableEntity, "No API key name given")
+	// ErrInvalidAPIKeyExpiry indicates an API key expiring in the past, or after the maximum duration.
+	ErrInvalidAPIKeyExpiry = restful.NewError(http.StatusUnprocessableEntity, "Invalid API key expiry")
+	// ErrUnknownAPIKey indicates a key the user doesn't have.
+	ErrUnknownAPIKey = restful.NewError(http.StatusNotFound, "Unknown API key")
+)
+
+// APIKeyReq creates an API key.
+type APIKeyReq struct {
+	// Name helps the user tell their keys apart (like "ci").
+	Name string `json:"name"`
+	// ExpiresAt is the end of validity of the key (default: the maximum duration, if any).
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// APIKey describes an API key.
+type APIKey struct {
+	// ID is the key's ID, as used in URLs.
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	// Key is the API key itself, only given at creation.
+	Key string `json:"key,omitempty"`
+}
+
+func apiKeyInfo(k apikey.Key) APIKey {
+	return APIKey{
+		ID:        k.ID,
+		Name:      k.Name,
+		CreatedAt: k.CreatedAt,
+		ExpiresAt: k.ExpiresAt,
+	}
+}
+
+func (cApi *CompanionAPI) createMyAPIKey(request *restful.Request, response *restful.Response) {
+	u := request.Attribute("user").(*rbac.User)
+	cApi.createAPIKey(u.Name, request, response)
+}
+
+func (cApi *CompanionAPI) createUserAPIKey(request *restful.Request, response *restful.Response) {
+	cApi.createAPIKey(request.PathParameter("user-id"), request, response)
+}
+
+func (cApi *CompanionAPI) createAPIKey(id string, request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	r := &APIKeyReq{}
+	if err := request.ReadEntity(r); err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+
+	if r.Name == "" {
+		panic(ErrMissingAPIKeyName)
+	}
+
+	now := time.Now()
+	if r.ExpiresAt == nil && cApi.APIKeyMaxDuration != 0 {
+		exp := now.Add(cApi.APIKeyMaxDuration).UTC().Truncate(time.Second)
+		r.ExpiresAt = &exp
+	}
+
+	if r.ExpiresAt != nil && !r.ExpiresAt.After(now) ||
+		cApi.APIKeyMaxDuration != 0 && r.ExpiresAt.After(now.Add(cApi.APIKeyMaxDuration)) {
+		panic(ErrInvalidAPIKeyExpiry)
+	}
+
+	key, stored, err := apikey.Generate(id, r.Name, r.ExpiresAt)
+	if err != nil {
+		panic(err)
+	}
+
+	err = cApi.Client.UpdateUser(id, func(user *backend.UserData) error {
+		user.APIKeys = append(user.APIKeys, *stored)
+		return nil
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	cApi.userChanged(id)
+
+	info := apiKeyInfo(*stored)
+	info.Key = key
+
+	response.WriteHeaderAndEntity(http.StatusCreated, info)
+}
+
+func (cApi *CompanionAPI) listMyAPIKeys(request *restful.Request, response *restful.Response) {
+	u := request.Attribute("user").(*rbac.User)
+	cApi.listAPIKeys(u.Name, response)
+}
+
+func (cApi *CompanionAPI) listUserAPIKeys(request *restful.Request, response *restful.Response) {
+	cApi.listAPIKeys(request.PathParameter("user-id"), response)
+}
+
+func (cApi *CompanionAPI) listAPIKeys(id string, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	user, err := cApi.Client.GetUser(id)
+	if err != nil {
+		panic(err)
+	}
+
+	keys := []APIKey{}
+	ids := []string{}
+	for _, k := range user.APIKeys {
+		keys = append(keys, apiKeyInfo(k))
+		ids = append(ids, k.ID)
+	}
+
+	// uses are recorded by the auth server
+	if cApi.AuthServer != nil && len(ids) != 0 {
+		lastUsed, err := cApi.AuthServer.APIKeysLastUsed(cApi.AuthServerAdminToken, ids)
+		if err != nil {
+			log.Print("failed to get the API keys usage of user ", id, ": ", err)
+		}
+
+		for i := range keys {
+			if t, ok := lastUsed[keys[i].ID]; ok {
+				keys[i].LastUsedAt = &t
+			}
+		}
+	}
+
+	response.WriteEntity(keys)
+}
+
+func (cApi *CompanionAPI) deleteMyAPIKey(request *restful.Request, response *restful.Response) {
+	u := request.Attribute("user").(*rbac.User)
+	cApi.deleteAPIKey(u.Name, request, response)
+}
+
+func (cApi *CompanionAPI) deleteUserAPIKey(request *restful.Request, response *restful.Response) {
+	cApi.deleteAPIKey(request.PathParameter("user-id"), request, response)
+}
+
+func (cApi *CompanionAPI) deleteAPIKey(id string, request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	keyID := request.PathParameter("key-id")
+
+	err := cApi.Client.UpdateUser(id, func(user *backend.UserData) error {
+		for i, k := range user.APIKeys {
+			if k.ID == keyID {
+				user.APIKeys = append(user.APIKeys[:i], user.APIKeys[i+1:]...)
+				return nil
+			}
+		}
+		return ErrUnknownAPIKey
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	cApi.userChanged(id)
+
+	response.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,330 @@
+package api
+
+import (
+	"net/http"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/isi-nc/autentigo/pkg/oauthclient"
+	"github.com/isi-nc/autentigo/pkg/passhash"
+)
+
+var (
+	// ErrNoClientRegistry indicates a request on the OAuth2 clients, which are not configured.
+	ErrNoClientRegistry = restful.NewError(http.StatusNotImplemented, "No client registry configured")
+	// ErrMissingClient indicates an inexistent client.
+	ErrMissingClient = restful.NewError(http.StatusNotFound, "Missing client")
+	// ErrMissingClientId indicates a client without an id.
+	ErrMissingClientId = restful.NewError(http.StatusUnprocessableEntity, "No client id given")
+	// ErrClientAlreadyExist indicates an existing client that should not be.
+	ErrClientAlreadyExist = restful.NewError(http.StatusConflict, "Client already exist")
+	// ErrInvalidTokenLifetime indicates a negative token lifetime.
+	ErrInvalidTokenLifetime = restful.NewError(http.StatusUnprocessableEntity, "Invalid token lifetime")
+	// ErrInvalidRedirectURI indicates a redirect URI that can't be registered (relative, with a fragment...).
+	ErrInvalidRedirectURI = restful.NewError(http.StatusUnprocessableEntity, "Invalid redirect URI")
+)
+
+// ClientReq creates or updates an OAuth2 client.
+type ClientReq struct {
+	// ID is the client_id, only read at creation.
+	ID        string   `json:"id"`
+	Audiences []string `json:"audiences"`
+	Groups    []string `json:"groups"`
+	// TokenLifetime is the lifetime of the client's tokens, in seconds (0: the auth server's token duration).
+	TokenLifetime int64 `json:"token_lifetime"`
+	// RedirectURIs are where the authorization code flow sends the users back.
+	RedirectURIs []string `json:"redirect_uris"`
+	// Public creates a client without secret (like a browser app), only read
+	// at creation. Public clients can only use the authorization code flow.
+	Public bool `json:"public,omitempty"`
+}
+
+// Client describes an OAuth2 client.
+type Client struct {
+	ID            string   `json:"id"`
+	Audiences     []string `json:"audiences,omitempty"`
+	Groups        []string `json:"groups,omitempty"`
+	TokenLifetime int64    `json:"token_lifetime,omitempty"`
+	RedirectURIs  []string `json:"redirect_uris,omitempty"`
+	Public        bool     `json:"public,omitempty"`
+	// Secret is the client's secret, only given at creation and rotation.
+	Secret string `json:"secret,omitempty"`
+}
+
+func clientInfo(c *oauthclient.Client) Client {
+	return Client{
+		ID:            c.ID,
+		Audiences:     c.Audiences,
+		Groups:        c.Groups,
+		TokenLifetime: c.TokenLifetime,
+		RedirectURIs:  c.RedirectURIs,
+		Public:        c.Public(),
+	}
+}
+
+// checkClientReq panics if the request is invalid.
+func checkClientReq(r *ClientReq) {
+	if r.TokenLifetime < 0 {
+		panic(ErrInvalidTokenLifetime)
+	}
+
+	for _, uri := range r.RedirectURIs {
+		if !oauthclient.ValidRedirectURI(uri) {
+			panic(ErrInvalidRedirectURI)
+		}
+	}
+}
+
+func (cApi *CompanionAPI) clientsWS() (ws *restful.WebService) {
+	ws = &restful.WebService{}
+	ws.Path("/clients")
+	ws.Doc("Requires the admin role")
+
+	if !cApi.DisableSecurity {
+		ws.Filter(requireRole(cApi.AdminToken, "admin"))
+	}
+
+	ws.
+		Route(ws.GET("/").
+			To(cApi.listClients).
+			Doc("List the OAuth2 clients.").
+			Produces("application/json").
+			Writes([]Client{}))
+
+	ws.
+		Route(ws.POST("/").
+			To(cApi.createClient).
+			Doc("Register an OAuth2 client. Its secret, unless public, is only given in this response.").
+			Consumes("application/json").
+			Reads(ClientReq{}).
+			Writes(Client{}))
+
+	ws.
+		Route(ws.GET("/{client-id}").
+			To(cApi.getClient).
+			Doc("Get an OAuth2 client.").
+			Param(ws.PathParameter("client-id", "identifier of the client").DataType("string")).
+			Produces("application/json").
+			Writes(Client{}))
+
+	ws.
+		Route(ws.PUT("/{client-id}").
+			To(cApi.updateClient).
+			Doc("Update an OAuth2 client's audiences, groups, token lifetime and redirect URIs.").
+			Consumes("application/json").
+			Param(ws.PathParameter("client-id", "identifier of the client").DataType("string")).
+			Reads(ClientReq{}).
+			Writes(Client{}))
+
+	ws.
+		Route(ws.POST("/{client-id}/secret").
+			To(cApi.rotateClientSecret).
+			Doc("Replace an OAuth2 client's secret. The new secret is only given in this response.").
+			Param(ws.PathParameter("client-id", "identifier of the client").DataType("string")).
+			Writes(Client{}))
+
+	ws.
+		Route(ws.DELETE("/{client-id}").
+			To(cApi.deleteClient).
+			Doc("Delete an OAuth2 client.").
+			Param(ws.PathParameter("client-id", "identifier of the client").DataType("string")))
+
+	return
+}
+
+func (cApi *CompanionAPI) listClients(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	if cApi.Clients == nil {
+		panic(ErrNoClientRegistry)
+	}
+
+	clients, err := cApi.Clients.ListClients()
+	if err != nil {
+		panic(err)
+	}
+
+	infos := []Client{}
+	for _, c := range clients {
+		infos = append(infos, clientInfo(c))
+	}
+
+	response.WriteEntity(infos)
+}
+
+func (cApi *CompanionAPI) getClient(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	if cApi.Clients == nil {
+		panic(ErrNoClientRegistry)
+	}
+
+	client, err := cApi.Clients.GetClient(request.PathParameter("client-id"))
+	if err != nil {
+		panic(err)
+	}
+
+	response.WriteEntity(clientInfo(client))
+}
+
+func (cApi *CompanionAPI) createClient(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	if cApi.Clients == nil {
+		panic(ErrNoClientRegistry)
+	}
+
+	r := &ClientReq{}
+	if err := request.ReadEntity(r); err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+
+	if r.ID == "" {
+		panic(ErrMissingClientId)
+	}
+	checkClientReq(r)
+
+	secret, secretHash := "", ""
+	if !r.Public {
+		secret, secretHash = cApi.newClientSecret()
+	}
+
+	client := &oauthclient.Client{
+		ID:            r.ID,
+		SecretHash:    secretHash,
+		Audiences:     r.Audiences,
+		Groups:        r.Groups,
+		TokenLifetime: r.TokenLifetime,
+		RedirectURIs:  r.RedirectURIs,
+	}
+
+	if err := cApi.Clients.CreateClient(client); err != nil {
+		panic(err)
+	}
+
+	info := clientInfo(client)
+	info.Secret = secret
+
+	response.WriteHeaderAndEntity(http.StatusCreated, info)
+}
+
+func (cApi *CompanionAPI) updateClient(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	if cApi.Clients == nil {
+		panic(ErrNoClientRegistry)
+	}
+
+	r := &ClientReq{}
+	if err := request.ReadEntity(r); err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+
+	checkClientReq(r)
+
+	var updated *oauthclient.Client
+	err := cApi.Clients.UpdateClient(request.PathParameter("client-id"), func(client *oauthclient.Client) error {
+		client.Audiences = r.Audiences
+		client.Groups = r.Groups
+		client.TokenLifetime = r.TokenLifetime
+		client.RedirectURIs = r.RedirectURIs
+		updated = client
+		return nil
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	response.WriteEntity(clientInfo(updated))
+}
+
+func (cApi *CompanionAPI) rotateClientSecret(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	if cApi.Clients == nil {
+		panic(ErrNoClientRegistry)
+	}
+
+	secret, secretHash := cApi.newClientSecret()
+
+	var updated *oauthclient.Client
+	err := cApi.Clients.UpdateClient(request.PathParameter("client-id"), func(client *oauthclient.Client) error {
+		client.SecretHash = secretHash
+		updated = client
+		return nil
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	info := clientInfo(updated)
+	info.Secret = secret
+
+	response.WriteEntity(info)
+}
+
+func (cApi *CompanionAPI) deleteClient(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	if cApi.Clients == nil {
+		panic(ErrNoClientRegistry)
+	}
+
+	if err := cApi.Clients.DeleteClient(request.PathParameter("client-id")); err != nil {
+		panic(err)
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// newClientSecret generates a client secret, and hashes it like passwords.
+func (cApi *CompanionAPI) newClientSecret() (secret, secretHash string) {
+	secret, err := oauthclient.GenerateSecret()
+	if err != nil {
+		panic(err)
+	}
+
+	scheme := cApi.PasswordScheme
+	if scheme == "" {
+		scheme = passhash.Bcrypt
+	}
+
+	if secretHash, err = passhash.Hash(scheme, secret); err != nil {
+		panic(err)
+	}
+
+	return
+}
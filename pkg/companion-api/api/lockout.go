@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/isi-nc/autentigo/client"
+)
+
+// ErrNoAuthServer indicates a request needing the auth server, which is not configured.
+var ErrNoAuthServer = restful.NewError(http.StatusNotImplemented, "No auth server configured")
+
+func (cApi *CompanionAPI) lockoutsWS() (ws *restful.WebService) {
+	ws = &restful.WebService{}
+	ws.Path("/lockouts")
+	ws.Doc("Requires the admin role")
+
+	if !cApi.DisableSecurity {
+		ws.Filter(requireRole(cApi.AdminToken, "admin"))
+	}
+
+	ws.
+		Route(ws.GET("/").
+			To(cApi.listLockouts).
+			Doc("List the users and IPs with recent authentication failures.").
+			Produces("application/json").
+			Writes([]client.Lockout{}))
+
+	ws.
+		Route(ws.DELETE("/{kind}/{key}").
+			To(cApi.clearLockout).
+			Doc("Clear the authentication failures of a user or IP.").
+			Param(ws.PathParameter("kind", "user or ip").DataType("string")).
+			Param(ws.PathParameter("key", "the user name or IP").DataType("string")))
+
+	return
+}
+
+func (cApi *CompanionAPI) listLockouts(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	if cApi.AuthServer == nil {
+		panic(ErrNoAuthServer)
+	}
+
+	lockouts, err := cApi.AuthServer.Lockouts(cApi.AuthServerAdminToken)
+	if err != nil {
+		panic(err)
+	}
+
+	response.WriteEntity(lockouts)
+}
+
+func (cApi *CompanionAPI) clearLockout(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	if cApi.AuthServer == nil {
+		panic(ErrNoAuthServer)
+	}
+
+	kind := request.PathParameter("kind")
+	if kind != "user" && kind != "ip" {
+		panic(restful.NewError(http.StatusBadRequest, "kind must be user or ip"))
+	}
+
+	if err := cApi.AuthServer.ClearLockout(cApi.AuthServerAdminToken, kind, request.PathParameter("key")); err != nil {
+		panic(err)
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
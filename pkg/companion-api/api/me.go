@@ -1,14 +1,14 @@
 package api
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"log"
 	"net/http"
 
 	restful "github.com/emicklei/go-restful/v3"
 	"github.com/isi-nc/autentigo/pkg/companion-api/backend"
+	"github.com/isi-nc/autentigo/pkg/passhash"
 	"github.com/isi-nc/autentigo/pkg/rbac"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
 )
 
 // Register provide a restful.WebService from this API
@@ -32,6 +32,69 @@ func (cApi *CompanionAPI) meWS() (ws *restful.WebService) {
 			Doc("Update the authenticated user's password.").
 			Reads(UpdatePasswordReq{}))
 
+	ws.
+		Route(ws.POST("/totp").
+			To(cApi.newMyTOTP).
+			Doc("Generate a TOTP secret for the authenticated user, enabled once confirmed.").
+			Writes(TOTPEnrolment{}))
+
+	ws.
+		Route(ws.PUT("/totp").
+			To(cApi.confirmMyTOTP).
+			Doc("Enable a TOTP secret with a code it generated. Replacing a secret requires a code of the current one.").
+			Param(restful.HeaderParameter("X-OTP", "Code of the current secret, if any")).
+			Reads(TOTPConfirmReq{}))
+
+	ws.
+		Route(ws.DELETE("/totp").
+			To(cApi.deleteMyTOTP).
+			Doc("Disable the TOTP second factor of the authenticated user.").
+			Param(restful.HeaderParameter("X-OTP", "Code of the current secret")))
+
+	ws.
+		Route(ws.GET("/webauthn").
+			To(cApi.listMyWebAuthn).
+			Doc("List the WebAuthn credentials (passkeys) of the authenticated user.").
+			Writes([]WebAuthnCredential{}))
+
+	ws.
+		Route(ws.POST("/webauthn/options").
+			To(cApi.newMyWebAuthn).
+			Doc("Start a WebAuthn registration: returns the options of navigator.credentials.create.").
+			Writes(webauthn.CreationOptions{}))
+
+	ws.
+		Route(ws.POST("/webauthn").
+			To(cApi.registerMyWebAuthn).
+			Doc("Finish a WebAuthn registration with the authenticator's response.").
+			Reads(WebAuthnRegistrationReq{}).
+			Writes(WebAuthnCredential{}))
+
+	ws.
+		Route(ws.DELETE("/webauthn/{credential-id}").
+			To(cApi.deleteMyWebAuthn).
+			Doc("Remove a WebAuthn credential of the authenticated user.").
+			Param(ws.PathParameter("credential-id", "identifier of the credential").DataType("string")))
+
+	ws.
+		Route(ws.GET("/api-keys").
+			To(cApi.listMyAPIKeys).
+			Doc("List the API keys of the authenticated user.").
+			Writes([]APIKey{}))
+
+	ws.
+		Route(ws.POST("/api-keys").
+			To(cApi.createMyAPIKey).
+			Doc("Create an API key for the authenticated user. The key is only given in this response.").
+			Reads(APIKeyReq{}).
+			Writes(APIKey{}))
+
+	ws.
+		Route(ws.DELETE("/api-keys/{key-id}").
+			To(cApi.deleteMyAPIKey).
+			Doc("Revoke an API key of the authenticated user.").
+			Param(ws.PathParameter("key-id", "identifier of the key").DataType("string")))
+
 	return ws
 }
 
@@ -61,10 +124,20 @@ func (cApi *CompanionAPI) updatePassword(userName string, request *restful.Reque
 		return
 	}
 
-	h := sha256.Sum256([]byte(r.NewPassword))
-	passwordHash := hex.EncodeToString(h[:])
+	scheme := cApi.PasswordScheme
+	if scheme == "" {
+		scheme = passhash.Bcrypt
+	}
+
+	passwordHash, err := passhash.Hash(scheme, r.NewPassword)
+	if err != nil {
+		log.Print("failed to hash the password of user ", userName, ": ", err)
+		sc := http.StatusInternalServerError
+		response.WriteErrorString(sc, http.StatusText(sc))
+		return
+	}
 
-	err := cApi.Client.UpdateUser(userName, func(user *backend.UserData) error {
+	err = cApi.Client.UpdateUser(userName, func(user *backend.UserData) error {
 		user.PasswordHash = passwordHash
 		return nil
 	})
@@ -75,4 +148,6 @@ func (cApi *CompanionAPI) updatePassword(userName string, request *restful.Reque
 		response.WriteErrorString(sc, http.StatusText(sc))
 		return
 	}
+
+	cApi.userChanged(userName)
 }
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/isi-nc/autentigo/pkg/companion-api/backend"
+	"github.com/isi-nc/autentigo/pkg/rbac"
+	"github.com/isi-nc/autentigo/pkg/totp"
+)
+
+var (
+	// ErrInvalidOTP indicates a wrong one-time password.
+	ErrInvalidOTP = restful.NewError(http.StatusForbidden, "Invalid one-time password")
+	// ErrMissingTOTPSecret indicates a TOTP confirmation without secret.
+	ErrMissingTOTPSecret = restful.NewError(http.StatusUnprocessableEntity, "No TOTP secret given")
+)
+
+// TOTPEnrolment is a new TOTP secret, to add to an authenticator app then confirm.
+type TOTPEnrolment struct {
+	Secret string `json:"secret"`
+	// URI is the otpauth:// URI of the secret (usually shown as a QR code).
+	URI string `json:"uri"`
+}
+
+// TOTPConfirmReq enables a TOTP secret, proving the authenticator app was set up.
+type TOTPConfirmReq struct {
+	Secret string `json:"secret"`
+	Code   string `json:"code"`
+}
+
+func (cApi *CompanionAPI) newMyTOTP(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	u := request.Attribute("user").(*rbac.User)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		panic(err)
+	}
+
+	issuer := cApi.TOTPIssuer
+	if issuer == "" {
+		issuer = "autentigo"
+	}
+
+	// nothing is stored until confirmed
+	response.WriteEntity(TOTPEnrolment{
+		Secret: secret,
+		URI:    totp.URI(issuer, u.Name, secret),
+	})
+}
+
+func (cApi *CompanionAPI) confirmMyTOTP(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	u := request.Attribute("user").(*rbac.User)
+
+	r := &TOTPConfirmReq{}
+	if err := request.ReadEntity(r); err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+
+	if r.Secret == "" {
+		panic(ErrMissingTOTPSecret)
+	}
+
+	if !totp.Validate(r.Secret, r.Code, time.Now()) {
+		panic(ErrInvalidOTP)
+	}
+
+	err := cApi.Client.UpdateUser(u.Name, func(user *backend.UserData) error {
+		// replacing a secret requires the current one
+		if user.TOTPSecret != "" && !totp.Validate(user.TOTPSecret, request.HeaderParameter("X-OTP"), time.Now()) {
+			return ErrInvalidOTP
+		}
+
+		user.TOTPSecret = r.Secret
+		return nil
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	cApi.userChanged(u.Name)
+}
+
+func (cApi *CompanionAPI) deleteMyTOTP(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	u := request.Attribute("user").(*rbac.User)
+
+	err := cApi.Client.UpdateUser(u.Name, func(user *backend.UserData) error {
+		if user.TOTPSecret != "" && !totp.Validate(user.TOTPSecret, request.HeaderParameter("X-OTP"), time.Now()) {
+			return ErrInvalidOTP
+		}
+
+		user.TOTPSecret = ""
+		return nil
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	cApi.userChanged(u.Name)
+}
+
+func (cApi *CompanionAPI) deleteUserTOTP(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	id := request.PathParameter("user-id")
+
+	err := cApi.Client.UpdateUser(id, func(user *backend.UserData) error {
+		user.TOTPSecret = ""
+		return nil
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	cApi.userChanged(id)
+
+	response.WriteHeader(http.StatusOK)
+}
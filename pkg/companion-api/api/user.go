@@ -5,13 +5,21 @@ import (
 	"net/http"
 
 	restful "github.com/emicklei/go-restful/v3"
+	"github.com/isi-nc/autentigo/auth"
 	"github.com/isi-nc/autentigo/pkg/companion-api/backend"
 )
 
 // CreateUserReq is a request to create a new UserData
 type CreateUserReq struct {
-	ID   string           `json:"id"`
-	User backend.UserData `json:"user"`
+	ID   string  `json:"id"`
+	User UserReq `json:"user"`
+}
+
+// UserReq is the part of a user set by the admins. Second factors and API
+// keys are only enrolled through their own routes.
+type UserReq struct {
+	PasswordHash string           `json:"password"`
+	ExtraClaims  auth.ExtraClaims `json:"claims"`
 }
 
 // Register provide a restful.WebService from this API
@@ -37,7 +45,7 @@ func (cApi *CompanionAPI) usersWS() (ws *restful.WebService) {
 			Doc("Update an existing user.").
 			Consumes("application/json").
 			Param(ws.PathParameter("user-id", "identifier of the user").DataType("string")).
-			Reads(backend.UserData{}))
+			Reads(UserReq{}))
 
 	ws.
 		Route(ws.PATCH("/{user-id}").
@@ -61,6 +69,56 @@ func (cApi *CompanionAPI) usersWS() (ws *restful.WebService) {
 			Param(ws.PathParameter("user-id", "identifier of the user").DataType("string")).
 			Reads(backend.UserData{}))
 
+	ws.
+		Route(ws.PUT("/{user-id}/attributes").
+			To(cApi.updateUserAttributes).
+			Doc("Replace an existing user's attributes.").
+			Consumes("application/json").
+			Param(ws.PathParameter("user-id", "identifier of the user").DataType("string")).
+			Reads(map[string]interface{}{}))
+
+	ws.
+		Route(ws.DELETE("/{user-id}/totp").
+			To(cApi.deleteUserTOTP).
+			Doc("Disable an existing user's TOTP second factor (lost device).").
+			Param(ws.PathParameter("user-id", "identifier of the user").DataType("string")))
+
+	ws.
+		Route(ws.GET("/{user-id}/webauthn").
+			To(cApi.listUserWebAuthn).
+			Doc("List an existing user's WebAuthn credentials (passkeys).").
+			Param(ws.PathParameter("user-id", "identifier of the user").DataType("string")).
+			Writes([]WebAuthnCredential{}))
+
+	ws.
+		Route(ws.DELETE("/{user-id}/webauthn/{credential-id}").
+			To(cApi.deleteUserWebAuthn).
+			Doc("Remove an existing user's WebAuthn credential (lost device).").
+			Param(ws.PathParameter("user-id", "identifier of the user").DataType("string")).
+			Param(ws.PathParameter("credential-id", "identifier of the credential").DataType("string")))
+
+	ws.
+		Route(ws.GET("/{user-id}/api-keys").
+			To(cApi.listUserAPIKeys).
+			Doc("List an existing user's API keys.").
+			Param(ws.PathParameter("user-id", "identifier of the user").DataType("string")).
+			Writes([]APIKey{}))
+
+	ws.
+		Route(ws.POST("/{user-id}/api-keys").
+			To(cApi.createUserAPIKey).
+			Doc("Create an API key for an existing user (like a service account). The key is only given in this response.").
+			Param(ws.PathParameter("user-id", "identifier of the user").DataType("string")).
+			Reads(APIKeyReq{}).
+			Writes(APIKey{}))
+
+	ws.
+		Route(ws.DELETE("/{user-id}/api-keys/{key-id}").
+			To(cApi.deleteUserAPIKey).
+			Doc("Revoke an existing user's API key.").
+			Param(ws.PathParameter("user-id", "identifier of the user").DataType("string")).
+			Param(ws.PathParameter("key-id", "identifier of the key").DataType("string")))
+
 	return
 }
 
@@ -85,10 +143,18 @@ func (cApi *CompanionAPI) createUser(request *restful.Request, response *restful
 		panic(ErrMissingUserPassword)
 	}
 
-	if err := cApi.Client.CreateUser(userReq.ID, &userReq.User); err != nil {
+	user := &backend.UserData{
+		PasswordHash: userReq.User.PasswordHash,
+		ExtraClaims:  userReq.User.ExtraClaims,
+	}
+
+	if err := cApi.Client.CreateUser(userReq.ID, user); err != nil {
 		panic(err)
 	}
 
+	// forget the user's cached failed authentications
+	cApi.userChanged(userReq.ID)
+
 	response.WriteHeader(http.StatusCreated)
 }
 
@@ -102,13 +168,15 @@ func (cApi *CompanionAPI) updateUser(request *restful.Request, response *restful
 
 	id := request.PathParameter("user-id")
 
-	userData := &backend.UserData{}
-	if err := request.ReadEntity(userData); err != nil {
+	userReq := &UserReq{}
+	if err := request.ReadEntity(userReq); err != nil {
 		panic(err)
 	}
 
+	// the second factors and API keys are kept
 	err := cApi.Client.UpdateUser(id, func(user *backend.UserData) error {
-		*user = *userData
+		user.PasswordHash = userReq.PasswordHash
+		user.ExtraClaims = userReq.ExtraClaims
 		return nil
 	})
 
@@ -116,6 +184,8 @@ func (cApi *CompanionAPI) updateUser(request *restful.Request, response *restful
 		panic(err)
 	}
 
+	cApi.userChanged(id)
+
 	response.WriteHeader(http.StatusOK)
 }
 
@@ -138,6 +208,8 @@ func (cApi *CompanionAPI) deleteUser(request *restful.Request, response *restful
 		panic(err)
 	}
 
+	cApi.userChanged(id)
+
 	response.WriteHeader(http.StatusOK)
 }
 
@@ -145,3 +217,32 @@ func (cApi *CompanionAPI) updateUserPassword(request *restful.Request, response
 	id := request.PathParameter("user-id")
 	cApi.updatePassword(id, request, response)
 }
+
+func (cApi *CompanionAPI) updateUserAttributes(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	id := request.PathParameter("user-id")
+
+	attributes := map[string]interface{}{}
+	if err := request.ReadEntity(&attributes); err != nil {
+		panic(err)
+	}
+
+	err := cApi.Client.UpdateUser(id, func(user *backend.UserData) error {
+		user.ExtraClaims.Attributes = attributes
+		return nil
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	cApi.userChanged(id)
+
+	response.WriteHeader(http.StatusOK)
+}
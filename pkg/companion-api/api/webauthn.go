@@ -0,0 +1,233 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/isi-nc/autentigo/pkg/companion-api/backend"
+	"github.com/isi-nc/autentigo/pkg/rbac"
+	"github.com/isi-nc/autentigo/pkg/store"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
+)
+
+var (
+	// ErrWebAuthnDisabled indicates WebAuthn is not configured.
+	ErrWebAuthnDisabled = restful.NewError(http.StatusNotFound, "WebAuthn is not enabled")
+	// ErrInvalidWebAuthn indicates a registration that failed verification.
+	ErrInvalidWebAuthn = restful.NewError(http.StatusForbidden, "Invalid WebAuthn registration")
+	// ErrUnknownCredential indicates a credential the user doesn't have.
+	ErrUnknownCredential = restful.NewError(http.StatusNotFound, "Unknown credential")
+)
+
+// WebAuthnRegistrationReq registers a new credential.
+type WebAuthnRegistrationReq struct {
+	// Name helps the user tell their credentials apart (like "work laptop").
+	Name string `json:"name"`
+	// Credential is the result of navigator.credentials.create, in its JSON form.
+	Credential webauthn.RegistrationResponse `json:"credential"`
+}
+
+// WebAuthnCredential describes a registered credential.
+type WebAuthnCredential struct {
+	// ID is the credential's ID (base64url), as used in URLs.
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func credentialInfos(credentials []webauthn.Credential) []WebAuthnCredential {
+	infos := []WebAuthnCredential{}
+	for _, c := range credentials {
+		infos = append(infos, WebAuthnCredential{
+			ID:        c.CredentialID(),
+			Name:      c.Name,
+			CreatedAt: c.CreatedAt,
+		})
+	}
+	return infos
+}
+
+func (cApi *CompanionAPI) requireWebAuthn() {
+	if cApi.WebAuthn == nil || cApi.Store == nil {
+		panic(ErrWebAuthnDisabled)
+	}
+}
+
+func (cApi *CompanionAPI) newMyWebAuthn(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	cApi.requireWebAuthn()
+
+	u := request.Attribute("user").(*rbac.User)
+
+	user, err := cApi.Client.GetUser(u.Name)
+	if err != nil {
+		panic(err)
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		panic(err)
+	}
+
+	ctx := request.Request.Context()
+	if err := cApi.Store.Put(ctx, "webauthn/registrations/"+challenge, []byte(u.Name), cApi.WebAuthn.ChallengeTTL()); err != nil {
+		panic(err)
+	}
+
+	response.WriteEntity(cApi.WebAuthn.CreationOptions(challenge, u.Name, user.ExtraClaims.DisplayName, user.WebAuthnCredentials))
+}
+
+func (cApi *CompanionAPI) registerMyWebAuthn(request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	cApi.requireWebAuthn()
+
+	u := request.Attribute("user").(*rbac.User)
+
+	r := &WebAuthnRegistrationReq{}
+	if err := request.ReadEntity(r); err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+
+	challenge, err := cApi.spendRegistration(request.Request.Context(), u.Name, r.Credential.Response.ClientDataJSON)
+	if err != nil {
+		panic(err)
+	}
+
+	credential, err := cApi.WebAuthn.VerifyRegistration(challenge, &r.Credential)
+	if errors.Is(err, webauthn.ErrInvalid) {
+		panic(ErrInvalidWebAuthn)
+	} else if err != nil {
+		panic(err)
+	}
+
+	credential.Name = r.Name
+
+	err = cApi.Client.UpdateUser(u.Name, func(user *backend.UserData) error {
+		for _, c := range user.WebAuthnCredentials {
+			if c.CredentialID() == credential.CredentialID() {
+				return ErrInvalidWebAuthn
+			}
+		}
+
+		user.WebAuthnCredentials = append(user.WebAuthnCredentials, *credential)
+		return nil
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	cApi.userChanged(u.Name)
+
+	response.WriteHeaderAndEntity(http.StatusCreated, credentialInfos([]webauthn.Credential{*credential})[0])
+}
+
+// spendRegistration returns the challenge of a registration started by the user, which can only be finished once.
+func (cApi *CompanionAPI) spendRegistration(ctx context.Context, user, clientDataJSON string) (string, error) {
+	challenge, err := webauthn.Challenge(clientDataJSON)
+	if err != nil {
+		return "", ErrInvalidWebAuthn
+	}
+
+	key := "webauthn/registrations/" + challenge
+
+	ba, err := cApi.Store.Get(ctx, key)
+	if err == store.ErrNotFound {
+		return "", ErrInvalidWebAuthn
+	} else if err != nil {
+		return "", err
+	}
+
+	if err := cApi.Store.Delete(ctx, key); err != nil {
+		return "", err
+	}
+
+	if string(ba) != user {
+		return "", ErrInvalidWebAuthn
+	}
+
+	return challenge, nil
+}
+
+func (cApi *CompanionAPI) listMyWebAuthn(request *restful.Request, response *restful.Response) {
+	u := request.Attribute("user").(*rbac.User)
+	cApi.listWebAuthn(u.Name, response)
+}
+
+func (cApi *CompanionAPI) listUserWebAuthn(request *restful.Request, response *restful.Response) {
+	cApi.listWebAuthn(request.PathParameter("user-id"), response)
+}
+
+func (cApi *CompanionAPI) listWebAuthn(id string, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	cApi.requireWebAuthn()
+
+	user, err := cApi.Client.GetUser(id)
+	if err != nil {
+		panic(err)
+	}
+
+	response.WriteEntity(credentialInfos(user.WebAuthnCredentials))
+}
+
+func (cApi *CompanionAPI) deleteMyWebAuthn(request *restful.Request, response *restful.Response) {
+	u := request.Attribute("user").(*rbac.User)
+	cApi.deleteWebAuthn(u.Name, request, response)
+}
+
+func (cApi *CompanionAPI) deleteUserWebAuthn(request *restful.Request, response *restful.Response) {
+	cApi.deleteWebAuthn(request.PathParameter("user-id"), request, response)
+}
+
+func (cApi *CompanionAPI) deleteWebAuthn(id string, request *restful.Request, response *restful.Response) {
+	defer func() {
+		if err := recover(); err != nil {
+			// unhandled error
+			writeError(err.(error), response)
+		}
+	}()
+
+	cApi.requireWebAuthn()
+
+	credentialID := request.PathParameter("credential-id")
+
+	err := cApi.Client.UpdateUser(id, func(user *backend.UserData) error {
+		for i, c := range user.WebAuthnCredentials {
+			if c.CredentialID() == credentialID {
+				user.WebAuthnCredentials = append(user.WebAuthnCredentials[:i], user.WebAuthnCredentials[i+1:]...)
+				return nil
+			}
+		}
+		return ErrUnknownCredential
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
+	cApi.userChanged(id)
+
+	response.WriteHeader(http.StatusOK)
+}
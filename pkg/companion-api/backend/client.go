@@ -2,36 +2,69 @@ package backend
 
 import (
 	"github.com/isi-nc/autentigo/auth"
+	"github.com/isi-nc/autentigo/pkg/apikey"
+	"github.com/isi-nc/autentigo/pkg/oauthclient"
+	"github.com/isi-nc/autentigo/pkg/webauthn"
 )
 
 // UserData is a simple user struct with paswordhash and claims
 type UserData struct {
 	PasswordHash string           `json:"password"`
 	ExtraClaims  auth.ExtraClaims `json:"claims"`
+	// TOTPSecret is the user's second factor secret (base32), if enrolled.
+	TOTPSecret string `json:"totp_secret,omitempty"`
+	// WebAuthnCredentials are the user's passkeys.
+	WebAuthnCredentials []webauthn.Credential `json:"webauthn_credentials,omitempty"`
+	// APIKeys are the user's API keys (hashed).
+	APIKeys []apikey.Key `json:"api_keys,omitempty"`
 }
 
 type User struct {
-	PasswordHash string `json:"password_hash"`
+	PasswordHash        string                `json:"password_hash"`
+	TOTPSecret          string                `json:"totp_secret,omitempty"`
+	WebAuthnCredentials []webauthn.Credential `json:"webauthn_credentials,omitempty"`
+	APIKeys             []apikey.Key          `json:"api_keys,omitempty"`
 	auth.ExtraClaims
 }
 
 func (u *UserData) ToUser() *User {
 	return &User{
 		PasswordHash: u.PasswordHash,
+		TOTPSecret:   u.TOTPSecret,
 		ExtraClaims:  u.ExtraClaims,
+
+		WebAuthnCredentials: u.WebAuthnCredentials,
+		APIKeys:             u.APIKeys,
 	}
 }
 
 func (u *User) ToUserData() *UserData {
 	return &UserData{
 		PasswordHash: u.PasswordHash,
+		TOTPSecret:   u.TOTPSecret,
 		ExtraClaims:  u.ExtraClaims,
+
+		WebAuthnCredentials: u.WebAuthnCredentials,
+		APIKeys:             u.APIKeys,
 	}
 }
 
 // Client is the interface for all backends clients
 type Client interface {
+	// GetUser returns the user, or api.ErrMissingUser.
+	GetUser(id string) (*UserData, error)
 	CreateUser(id string, user *UserData) error
 	UpdateUser(id string, update func(user *UserData) error) error
 	DeleteUser(id string) error
 }
+
+// ClientRegistry is the interface for the backends of the OAuth2 clients
+type ClientRegistry interface {
+	// ListClients returns all the clients.
+	ListClients() ([]*oauthclient.Client, error)
+	// GetClient returns the client, or api.ErrMissingClient.
+	GetClient(id string) (*oauthclient.Client, error)
+	CreateClient(client *oauthclient.Client) error
+	UpdateClient(id string, update func(client *oauthclient.Client) error) error
+	DeleteClient(id string) error
+}
@@ -0,0 +1,156 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/isi-nc/autentigo/pkg/companion-api/api"
+	"github.com/isi-nc/autentigo/pkg/companion-api/backend"
+	"github.com/isi-nc/autentigo/pkg/oauthclient"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+type etcdClients struct {
+	prefix  string
+	client  *clientv3.Client
+	timeout time.Duration
+}
+
+// NewClients returns a registry to manage OAuth2 clients with an etcd backend
+func NewClients(prefix string, endpoints []string) backend.ClientRegistry {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints: endpoints,
+	})
+
+	if err != nil {
+		log.Fatal("failed to connect to etcd: ", err)
+	}
+
+	timeout := 5 * time.Second
+	if timeoutEnv := os.Getenv("ETCD_TIMEOUT"); timeoutEnv != "" {
+		timeout, err = time.ParseDuration(timeoutEnv)
+		if err != nil {
+			log.Fatalf("invalid ETCD_TIMEOUT %q: %v", timeoutEnv, timeout)
+		}
+	}
+
+	return &etcdClients{
+		prefix:  prefix,
+		client:  client,
+		timeout: timeout,
+	}
+}
+
+var _ backend.ClientRegistry = &etcdClients{}
+
+func (e *etcdClients) ListClients() (clients []*oauthclient.Client, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	keyPrefix := path.Join(e.prefix) + "/"
+	resp, err := e.client.Get(ctx, keyPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return
+	}
+
+	clients = []*oauthclient.Client{}
+	for _, kv := range resp.Kvs {
+		client := &oauthclient.Client{}
+		if err = json.Unmarshal(kv.Value, client); err != nil {
+			return nil, err
+		}
+		client.ID = strings.TrimPrefix(string(kv.Key), keyPrefix)
+		clients = append(clients, client)
+	}
+
+	return
+}
+
+func (e *etcdClients) GetClient(id string) (client *oauthclient.Client, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	resp, err := e.client.Get(ctx, path.Join(e.prefix, id))
+	if err != nil {
+		return
+	}
+
+	if len(resp.Kvs) == 0 {
+		err = api.ErrMissingClient
+		return
+	}
+
+	client = &oauthclient.Client{}
+	if err = json.Unmarshal(resp.Kvs[0].Value, client); err != nil {
+		return nil, err
+	}
+	client.ID = id
+	return
+}
+
+func (e *etcdClients) CreateClient(client *oauthclient.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	ba, err := json.Marshal(client)
+	if err != nil {
+		return err
+	}
+
+	key := path.Join(e.prefix, client.ID)
+	resp, err := e.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(ba))).
+		Commit()
+	if err != nil {
+		return err
+	}
+
+	if !resp.Succeeded {
+		return api.ErrClientAlreadyExist
+	}
+	return nil
+}
+
+func (e *etcdClients) UpdateClient(id string, update func(client *oauthclient.Client) error) error {
+	client, err := e.GetClient(id)
+	if err != nil {
+		return err
+	}
+
+	if err = update(client); err != nil {
+		return err
+	}
+	client.ID = id
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	ba, err := json.Marshal(client)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.client.Put(ctx, path.Join(e.prefix, id), string(ba))
+	return err
+}
+
+func (e *etcdClients) DeleteClient(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	resp, err := e.client.Delete(ctx, path.Join(e.prefix, id))
+	if err != nil {
+		return err
+	}
+
+	if resp.Deleted == 0 {
+		return api.ErrMissingClient
+	}
+	return nil
+}
@@ -47,6 +47,10 @@ func New(prefix string, endpoints []string) backend.Client {
 
 var _ backend.Client = &etcdClient{}
 
+func (e *etcdClient) GetUser(id string) (*backend.UserData, error) {
+	return e.getUser(id)
+}
+
 func (e *etcdClient) CreateUser(id string, user *backend.UserData) (err error) {
 	oldUser := &backend.UserData{}
 	oldUser, err = e.getUser(id)
@@ -0,0 +1,156 @@
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/isi-nc/autentigo/pkg/companion-api/api"
+	"github.com/isi-nc/autentigo/pkg/companion-api/backend"
+	"github.com/isi-nc/autentigo/pkg/oauthclient"
+)
+
+type sqlClients struct {
+	db    *sql.DB
+	table string
+}
+
+// NewClients returns a registry to manage OAuth2 clients with an SQL backend
+func NewClients(driver, dsn, table string) backend.ClientRegistry {
+	db := DbConnect(driver, dsn)
+
+	if err := CreateClientsTableIfNotExists(db, table); err != nil {
+		panic(err)
+	}
+
+	return &sqlClients{
+		db:    db,
+		table: table,
+	}
+}
+
+var _ backend.ClientRegistry = &sqlClients{}
+
+// CreateClientsTableIfNotExists creates the table of the OAuth2 clients.
+func CreateClientsTableIfNotExists(db *sql.DB, table string) (err error) {
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s("+
+		"id VARCHAR PRIMARY KEY NOT NULL,"+
+		"secret_hash VARCHAR NOT NULL,"+
+		"audiences VARCHAR NOT NULL,"+
+		"groups VARCHAR NOT NULL,"+
+		"token_lifetime BIGINT NOT NULL DEFAULT 0,"+
+		"redirect_uris VARCHAR NOT NULL DEFAULT ''"+
+		");", table)
+
+	if _, err = db.Exec(query); err != nil {
+		return
+	}
+
+	// tables created before redirect URIs
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS redirect_uris VARCHAR NOT NULL DEFAULT '';", table))
+	return
+}
+
+func (s *sqlClients) ListClients() ([]*oauthclient.Client, error) {
+	query := fmt.Sprintf("select id, secret_hash, audiences, groups, token_lifetime, redirect_uris from %s order by id;", s.table)
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*oauthclient.Client{}
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+func (s *sqlClients) GetClient(id string) (*oauthclient.Client, error) {
+	query := fmt.Sprintf("select id, secret_hash, audiences, groups, token_lifetime, redirect_uris from %s where id=$1;", s.table)
+
+	client, err := scanClient(s.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, api.ErrMissingClient
+	}
+
+	return client, err
+}
+
+func (s *sqlClients) CreateClient(client *oauthclient.Client) error {
+	query := fmt.Sprintf("INSERT INTO %s(id, secret_hash, audiences, groups, token_lifetime, redirect_uris) VALUES($1,$2,$3,$4,$5,$6) ON CONFLICT (id) DO NOTHING", s.table)
+
+	res, err := s.db.Exec(query, client.ID, client.SecretHash, strings.Join(client.Audiences, ","), strings.Join(client.Groups, ","), client.TokenLifetime, strings.Join(client.RedirectURIs, ","))
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return api.ErrClientAlreadyExist
+	}
+
+	return nil
+}
+
+func (s *sqlClients) UpdateClient(id string, update func(client *oauthclient.Client) error) error {
+	client, err := s.GetClient(id)
+	if err != nil {
+		return err
+	}
+
+	if err = update(client); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET secret_hash=$2, audiences=$3, groups=$4, token_lifetime=$5, redirect_uris=$6 WHERE id=$1", s.table)
+
+	_, err = s.db.Exec(query, id, client.SecretHash, strings.Join(client.Audiences, ","), strings.Join(client.Groups, ","), client.TokenLifetime, strings.Join(client.RedirectURIs, ","))
+	return err
+}
+
+func (s *sqlClients) DeleteClient(id string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE id=$1", s.table)
+
+	res, err := s.db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return api.ErrMissingClient
+	}
+
+	return nil
+}
+
+// scanClient reads a client's row.
+func scanClient(row interface{ Scan(...interface{}) error }) (*oauthclient.Client, error) {
+	client := &oauthclient.Client{}
+	audiences, groups, redirectURIs := "", "", ""
+
+	if err := row.Scan(&client.ID, &client.SecretHash, &audiences, &groups, &client.TokenLifetime, &redirectURIs); err != nil {
+		return nil, err
+	}
+
+	if audiences != "" {
+		client.Audiences = strings.Split(audiences, ",")
+	}
+	if groups != "" {
+		client.Groups = strings.Split(groups, ",")
+	}
+	if redirectURIs != "" {
+		client.RedirectURIs = strings.Split(redirectURIs, ",")
+	}
+
+	return client, nil
+}
@@ -2,6 +2,7 @@ package sql
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"github.com/google/go-cmp/cmp"
 	"log"
@@ -102,10 +103,28 @@ func CreateUsersTableIfNotExists(db *sql.DB, table string) (err error) {
 		"display_name VARCHAR NOT NULL," +
 		"email VARCHAR NOT NULL," +
 		"email_verified BOOLEAN," +
-		"groups VARCHAR NOT NULL" +
+		"groups VARCHAR NOT NULL," +
+		"attributes JSONB," +
+		"totp_secret VARCHAR," +
+		"webauthn_credentials JSONB," +
+		"api_keys JSONB" +
 		");", table)
 
-	_, err = db.Exec(query)
+	if _, err = db.Exec(query); err != nil {
+		return
+	}
+
+	// tables created before attributes, TOTP secrets, WebAuthn credentials and API keys
+	if _, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS attributes JSONB;", table)); err != nil {
+		return
+	}
+	if _, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS totp_secret VARCHAR;", table)); err != nil {
+		return
+	}
+	if _, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS webauthn_credentials JSONB;", table)); err != nil {
+		return
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS api_keys JSONB;", table))
 
 	return
 }
@@ -115,35 +134,122 @@ func (s *sqlClient) getUser(id string) (u *backend.UserData, err error) {
 	//defer cancel()
 
 	groups := ""
-	query := fmt.Sprintf("select password_hash, display_name, email, email_verified, groups from %s where id=$1;", s.table)
+	var attributes []byte
+	var totpSecret sql.NullString
+	var credentials, apiKeys []byte
+	query := fmt.Sprintf("select password_hash, display_name, email, email_verified, groups, attributes, totp_secret, webauthn_credentials, api_keys from %s where id=$1;", s.table)
 
 	u = &backend.UserData{}
 	//xtraClaims := auth.ExtraClaims{}
 
 	err = s.db.
 		QueryRow(query, id).
-		Scan(&u.PasswordHash, &u.ExtraClaims.DisplayName, &u.ExtraClaims.Email, &u.ExtraClaims.EmailVerified, &groups)
+		Scan(&u.PasswordHash, &u.ExtraClaims.DisplayName, &u.ExtraClaims.Email, &u.ExtraClaims.EmailVerified, &groups, &attributes, &totpSecret, &credentials, &apiKeys)
 	if err != nil {
 		return nil, api.ErrMissingUser
 	}
 
 	u.ExtraClaims.Groups = strings.Split(groups, ",")
+	u.TOTPSecret = totpSecret.String
+
+	if len(attributes) != 0 {
+		if err = json.Unmarshal(attributes, &u.ExtraClaims.Attributes); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(credentials) != 0 {
+		if err = json.Unmarshal(credentials, &u.WebAuthnCredentials); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(apiKeys) != 0 {
+		if err = json.Unmarshal(apiKeys, &u.APIKeys); err != nil {
+			return nil, err
+		}
+	}
 
 	return
 }
 
+// credentialsValue returns the JSON value of the webauthn_credentials column (NULL when empty).
+func credentialsValue(user *backend.UserData) (interface{}, error) {
+	if len(user.WebAuthnCredentials) == 0 {
+		return nil, nil
+	}
+
+	ba, err := json.Marshal(user.WebAuthnCredentials)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(ba), nil
+}
+
+// apiKeysValue returns the JSON value of the api_keys column (NULL when empty).
+func apiKeysValue(user *backend.UserData) (interface{}, error) {
+	if len(user.APIKeys) == 0 {
+		return nil, nil
+	}
+
+	ba, err := json.Marshal(user.APIKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(ba), nil
+}
+
+// totpSecretValue returns the value of the totp_secret column (NULL when empty).
+func totpSecretValue(user *backend.UserData) interface{} {
+	if user.TOTPSecret == "" {
+		return nil
+	}
+	return user.TOTPSecret
+}
+
+// attributesValue returns the JSON value of the attributes column (NULL when empty).
+func attributesValue(user *backend.UserData) (interface{}, error) {
+	if len(user.ExtraClaims.Attributes) == 0 {
+		return nil, nil
+	}
+
+	ba, err := json.Marshal(user.ExtraClaims.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(ba), nil
+}
+
 func (s *sqlClient) createUser(id string, user *backend.UserData) (err error) {
 	//ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
 	//defer cancel()
 
-	preparedQuery := fmt.Sprintf("INSERT INTO %s(id, password_hash, display_name,email, email_verified, groups) VALUES($1,$2,$3,$4,$5,$6)", s.table)
+	attributes, err := attributesValue(user)
+	if err != nil {
+		return
+	}
+
+	credentials, err := credentialsValue(user)
+	if err != nil {
+		return
+	}
+
+	apiKeys, err := apiKeysValue(user)
+	if err != nil {
+		return
+	}
+
+	preparedQuery := fmt.Sprintf("INSERT INTO %s(id, password_hash, display_name,email, email_verified, groups, attributes, totp_secret, webauthn_credentials, api_keys) VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)", s.table)
 	var stmt *sql.Stmt
 	stmt, err = s.db.Prepare(preparedQuery)
 	if err != nil {
 		return
 	}
 
-	_, err = stmt.Exec(id, user.PasswordHash, user.ExtraClaims.DisplayName, user.ExtraClaims.Email, user.ExtraClaims.EmailVerified, strings.Join(user.ExtraClaims.Groups,","))
+	_, err = stmt.Exec(id, user.PasswordHash, user.ExtraClaims.DisplayName, user.ExtraClaims.Email, user.ExtraClaims.EmailVerified, strings.Join(user.ExtraClaims.Groups,","), attributes, totpSecretValue(user), credentials, apiKeys)
 
 	return
 }
@@ -152,14 +258,29 @@ func (s *sqlClient) updateUser(id string, user *backend.UserData) (err error) {
 	//ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
 	//defer cancel()
 
-	preparedQuery := fmt.Sprintf("UPDATE %s SET password_hash=$2, display_name=$3, email=$4, email_verified=$5, groups=$6 WHERE id=$1", s.table)
+	attributes, err := attributesValue(user)
+	if err != nil {
+		return
+	}
+
+	credentials, err := credentialsValue(user)
+	if err != nil {
+		return
+	}
+
+	apiKeys, err := apiKeysValue(user)
+	if err != nil {
+		return
+	}
+
+	preparedQuery := fmt.Sprintf("UPDATE %s SET password_hash=$2, display_name=$3, email=$4, email_verified=$5, groups=$6, attributes=$7, totp_secret=$8, webauthn_credentials=$9, api_keys=$10 WHERE id=$1", s.table)
 	var stmt *sql.Stmt
 	stmt, err = s.db.Prepare(preparedQuery)
 	if err != nil {
 		return
 	}
 
-	_, err = stmt.Exec(id, user.PasswordHash, user.ExtraClaims.DisplayName, user.ExtraClaims.Email, user.ExtraClaims.EmailVerified, strings.Join(user.ExtraClaims.Groups,","))
+	_, err = stmt.Exec(id, user.PasswordHash, user.ExtraClaims.DisplayName, user.ExtraClaims.Email, user.ExtraClaims.EmailVerified, strings.Join(user.ExtraClaims.Groups,","), attributes, totpSecretValue(user), credentials, apiKeys)
 
 	return
 }
@@ -137,6 +137,7 @@ func TestSqlClient_UpdateUser(t *testing.T) {
 		Email:         "newtoto@test.net",
 		EmailVerified: true,
 		Groups:        []string{"group1","newgroup"},
+		Attributes:    map[string]interface{}{"department": "IT", "employee_id": "42"},
 	}
 	user1b := &backend.UserData{
 		PasswordHash: "newhash",
@@ -0,0 +1,196 @@
+package usersfile
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/isi-nc/autentigo/pkg/companion-api/api"
+	"github.com/isi-nc/autentigo/pkg/companion-api/backend"
+	"github.com/isi-nc/autentigo/pkg/oauthclient"
+)
+
+type fileClients struct {
+	filePath string
+}
+
+// NewClients returns a registry to manage OAuth2 clients with a csv file backend
+func NewClients(filePath string) backend.ClientRegistry {
+	return &fileClients{
+		filePath: filePath,
+	}
+}
+
+var _ backend.ClientRegistry = &fileClients{}
+
+func (fc *fileClients) ListClients() ([]*oauthclient.Client, error) {
+	reader, err := newUsersFileReader(fc.filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.close()
+
+	clients := []*oauthclient.Client{}
+	for {
+		record, err := reader.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(record) < 2 {
+			// record too short
+			continue
+		}
+
+		client, err := parseClient(record)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, nil
+}
+
+func (fc *fileClients) GetClient(id string) (*oauthclient.Client, error) {
+	clients, err := fc.ListClients()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, client := range clients {
+		if client.ID == id {
+			return client, nil
+		}
+	}
+
+	return nil, api.ErrMissingClient
+}
+
+func (fc *fileClients) CreateClient(client *oauthclient.Client) error {
+	_, err := fc.GetClient(client.ID)
+	if err == nil {
+		return api.ErrClientAlreadyExist
+	} else if !cmp.Equal(err, api.ErrMissingClient) {
+		return err
+	}
+
+	return fc.putClient(client.ID, clientRecord(client))
+}
+
+func (fc *fileClients) UpdateClient(id string, update func(client *oauthclient.Client) error) error {
+	client, err := fc.GetClient(id)
+	if err != nil {
+		return err
+	}
+
+	if err = update(client); err != nil {
+		return err
+	}
+
+	return fc.putClient(id, clientRecord(client))
+}
+
+func (fc *fileClients) DeleteClient(id string) error {
+	if _, err := fc.GetClient(id); err != nil {
+		return err
+	}
+
+	return fc.putClient(id, nil)
+}
+
+// putClient replaces the client's line by the record, or removes it if the
+// record is nil. The record is appended if the client has no line.
+func (fc *fileClients) putClient(id string, record []string) error {
+	reader, err := newUsersFileReader(fc.filePath)
+	if err != nil {
+		return err
+	}
+	defer reader.close()
+
+	writer, err := newUsersFileWriter()
+	if err != nil {
+		return err
+	}
+	defer writer.save(fc.filePath)
+
+	recordExist := false
+	for {
+		r, err := reader.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if r[0] == id {
+			recordExist = true
+			if record == nil {
+				continue
+			}
+			r = record
+		}
+
+		writer.write(r)
+	}
+
+	if !recordExist && record != nil {
+		writer.write(record)
+	}
+
+	return nil
+}
+
+// clientRecord returns the client's line: the id, the secret hash, the
+// audiences and groups (comma separated), the token lifetime in seconds, and
+// the redirect URIs (comma separated) in an optional 6th column.
+func clientRecord(client *oauthclient.Client) []string {
+	record := []string{
+		client.ID,
+		client.SecretHash,
+		strings.Join(client.Audiences, ","),
+		strings.Join(client.Groups, ","),
+		strconv.FormatInt(client.TokenLifetime, 10),
+	}
+
+	if len(client.RedirectURIs) != 0 {
+		record = append(record, strings.Join(client.RedirectURIs, ","))
+	}
+
+	return record
+}
+
+func parseClient(record []string) (client *oauthclient.Client, err error) {
+	client = &oauthclient.Client{ID: record[0], SecretHash: record[1]}
+
+	l := len(record)
+	switch {
+	case l >= 6:
+		client.RedirectURIs = splitList(record[5])
+		fallthrough
+	case l == 5:
+		if record[4] != "" {
+			if client.TokenLifetime, err = strconv.ParseInt(record[4], 10, 64); err != nil {
+				return nil, err
+			}
+		}
+		fallthrough
+	case l == 4:
+		client.Groups = splitList(record[3])
+		fallthrough
+	case l == 3:
+		client.Audiences = splitList(record[2])
+	}
+
+	return
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
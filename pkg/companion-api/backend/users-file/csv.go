@@ -75,6 +75,7 @@ func newUsersFileReader(fileName string) (ufr *usersFileReader, err error) {
 
 	reader := csv.NewReader(f)
 	reader.Comma = ':'
+	reader.FieldsPerRecord = -1
 
 	ufr = &usersFileReader{
 		reader: reader,
@@ -1,13 +1,13 @@
 package usersfile
 
 import (
+	"encoding/json"
 	"github.com/google/go-cmp/cmp"
 	"io"
 	"strconv"
 	"strings"
 	"sync"
 
-	"github.com/isi-nc/autentigo/auth"
 	"github.com/isi-nc/autentigo/pkg/companion-api/api"
 	"github.com/isi-nc/autentigo/pkg/companion-api/backend"
 )
@@ -34,6 +34,10 @@ func New(filePath string) backend.Client {
 
 var _ backend.Client = &fileClient{}
 
+func (fc *fileClient) GetUser(id string) (*backend.UserData, error) {
+	return fc.getUser(id)
+}
+
 func (fc *fileClient) CreateUser(id string, user *backend.UserData) (err error) {
 
 	oldUser := &backend.UserData{}
@@ -42,7 +46,7 @@ func (fc *fileClient) CreateUser(id string, user *backend.UserData) (err error)
 	if oldUser != nil {
 		err = api.ErrUserAlreadyExist
 	} else if cmp.Equal(err, api.ErrMissingUser) {
-		err = fc.putUser(id, user.PasswordHash, user.ExtraClaims)
+		err = fc.putUser(id, user)
 	}
 
 	return
@@ -55,7 +59,7 @@ func (fc *fileClient) UpdateUser(id string, update func(user *backend.UserData)
 	if err == nil && user != nil {
 		err = update(user)
 		if err == nil {
-			err = fc.putUser(id, user.PasswordHash, user.ExtraClaims)
+			err = fc.putUser(id, user)
 		}
 	}
 
@@ -107,7 +111,7 @@ func (fc *fileClient) DeleteUser(id string) error {
 	return nil
 }
 
-func (fc *fileClient) putUser(id, passwordHash string, claims auth.ExtraClaims) error {
+func (fc *fileClient) putUser(id string, user *backend.UserData) error {
 	var wg sync.WaitGroup
 
 	reader, err := newUsersFileReader(fc.filePath)
@@ -132,11 +136,9 @@ func (fc *fileClient) putUser(id, passwordHash string, claims auth.ExtraClaims)
 		}
 
 		if id == record[0] {
-			record[1] = passwordHash
-			record[2] = claims.DisplayName
-			record[3] = claims.Email
-			record[4] = strconv.FormatBool(claims.EmailVerified)
-			record[5] = strings.Join(claims.Groups, ",")
+			if record, err = userRecord(id, user); err != nil {
+				return err
+			}
 			recordExist = true
 		}
 
@@ -150,19 +152,68 @@ func (fc *fileClient) putUser(id, passwordHash string, claims auth.ExtraClaims)
 
 	wg.Wait()
 	if !recordExist {
-		writer.write([]string{
-			id,
-			passwordHash,
-			claims.DisplayName,
-			claims.Email,
-			strconv.FormatBool(claims.EmailVerified),
-			strings.Join(claims.Groups, ","),
-		})
+		record, err := userRecord(id, user)
+		if err != nil {
+			return err
+		}
+		writer.write(record)
 	}
 
 	return nil
 }
 
+// userRecord returns the user's line. Attributes are in an optional 7th column,
+// as JSON, the TOTP secret in an optional 8th column, the WebAuthn credentials
+// in an optional 9th column and the API keys in an optional 10th column, as JSON.
+func userRecord(id string, user *backend.UserData) ([]string, error) {
+	claims := user.ExtraClaims
+	record := []string{
+		id,
+		user.PasswordHash,
+		claims.DisplayName,
+		claims.Email,
+		strconv.FormatBool(claims.EmailVerified),
+		strings.Join(claims.Groups, ","),
+	}
+
+	if len(claims.Attributes) != 0 {
+		ba, err := json.Marshal(claims.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		record = append(record, string(ba))
+	}
+
+	if user.TOTPSecret != "" || len(user.WebAuthnCredentials) != 0 || len(user.APIKeys) != 0 {
+		if len(record) == 6 {
+			record = append(record, "")
+		}
+		record = append(record, user.TOTPSecret)
+	}
+
+	if len(user.WebAuthnCredentials) != 0 || len(user.APIKeys) != 0 {
+		credentials := ""
+		if len(user.WebAuthnCredentials) != 0 {
+			ba, err := json.Marshal(user.WebAuthnCredentials)
+			if err != nil {
+				return nil, err
+			}
+			credentials = string(ba)
+		}
+		record = append(record, credentials)
+	}
+
+	if len(user.APIKeys) != 0 {
+		ba, err := json.Marshal(user.APIKeys)
+		if err != nil {
+			return nil, err
+		}
+		record = append(record, string(ba))
+	}
+
+	return record, nil
+}
+
 func (fc *fileClient) getUser(id string) (*backend.UserData, error) {
 
 	reader, err := newUsersFileReader(fc.filePath)
@@ -194,7 +245,31 @@ func (fc *fileClient) getUser(id string) (*backend.UserData, error) {
 
 		l := len(record)
 		switch {
-		case l >= 6:
+		case l >= 10:
+			if record[9] != "" {
+				if err := json.Unmarshal([]byte(record[9]), &user.APIKeys); err != nil {
+					return nil, err
+				}
+			}
+			fallthrough
+		case l == 9:
+			if record[8] != "" {
+				if err := json.Unmarshal([]byte(record[8]), &user.WebAuthnCredentials); err != nil {
+					return nil, err
+				}
+			}
+			fallthrough
+		case l == 8:
+			user.TOTPSecret = record[7]
+			fallthrough
+		case l == 7:
+			if record[6] != "" {
+				if err := json.Unmarshal([]byte(record[6]), &user.ExtraClaims.Attributes); err != nil {
+					return nil, err
+				}
+			}
+			fallthrough
+		case l == 6:
 			user.ExtraClaims.Groups = strings.Split(record[5], ",")
 			fallthrough
 		case l == 5:
@@ -0,0 +1,243 @@
+package keys
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	// ErrUnsupportedKey indicates a key type that can't be represented as a JWK.
+	ErrUnsupportedKey = errors.New("unsupported key type")
+)
+
+// JWK is a JSON Web Key (RFC 7517) holding a public key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP (Ed25519) keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+
+	// X5c is the certificate chain of the key, if any
+	X5c []string `json:"x5c,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set, as served on /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Lookup returns the key with the given id, or nil if there's none.
+func (s *JWKSet) Lookup(kid string) *JWK {
+	for i := range s.Keys {
+		if s.Keys[i].Kid == kid {
+			return &s.Keys[i]
+		}
+	}
+	return nil
+}
+
+// ParseJWKSet parses a JSON encoded key set.
+func ParseJWKSet(data []byte) (set *JWKSet, err error) {
+	set = &JWKSet{}
+	err = json.Unmarshal(data, set)
+	return
+}
+
+// NewJWK builds the JWK of the given public key, using its thumbprint as kid.
+func NewJWK(pub interface{}, alg string) (jwk JWK, err error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		jwk = JWK{
+			Kty: "RSA",
+			N:   encode(k.N.Bytes()),
+			E:   encode(big.NewInt(int64(k.E)).Bytes()),
+		}
+
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk = JWK{
+			Kty: "EC",
+			Crv: k.Curve.Params().Name,
+			X:   encode(k.X.FillBytes(make([]byte, size))),
+			Y:   encode(k.Y.FillBytes(make([]byte, size))),
+		}
+
+	case ed25519.PublicKey:
+		jwk = JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   encode(k),
+		}
+
+	default:
+		err = ErrUnsupportedKey
+		return
+	}
+
+	jwk.Use = "sig"
+	jwk.Alg = alg
+	jwk.Kid, err = jwk.Thumbprint()
+	return
+}
+
+// Thumbprint returns the RFC 7638 thumbprint of the key (SHA-256, base64url encoded).
+func (k JWK) Thumbprint() (string, error) {
+	var members interface{}
+
+	// required members only, in lexicographic order
+	switch k.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.Kty, k.N}
+
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{k.Crv, k.Kty, k.X, k.Y}
+
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{k.Crv, k.Kty, k.X}
+
+	default:
+		return "", ErrUnsupportedKey
+	}
+
+	ba, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.Sum256(ba)
+	return encode(h[:]), nil
+}
+
+// Thumbprint returns the RFC 7638 thumbprint of the given public key.
+func Thumbprint(pub interface{}) (string, error) {
+	jwk, err := NewJWK(pub, "")
+	if err != nil {
+		return "", err
+	}
+	return jwk.Kid, nil
+}
+
+// PublicKey returns the public key described by this JWK.
+func (k JWK) PublicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %q", k.Crv)
+		}
+
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve: %q", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key size: %d", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedKey, k.Kty)
+	}
+}
+
+// EncodeChain encodes a certificate chain for x5c headers and members (RFC 7515 section 4.1.6).
+func EncodeChain(chain []*x509.Certificate) (x5c []string) {
+	for _, cert := range chain {
+		x5c = append(x5c, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+	return
+}
+
+// DecodeChain decodes a x5c header or member.
+func DecodeChain(x5c []string) (chain []*x509.Certificate, err error) {
+	for _, s := range x5c {
+		der, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+
+		chain = append(chain, cert)
+	}
+	return
+}
+
+func encode(ba []byte) string {
+	return base64.RawURLEncoding.EncodeToString(ba)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	ba, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(ba), nil
+}
@@ -0,0 +1,67 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestJWKRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pub := range []crypto.PublicKey{&rsaKey.PublicKey, &ecKey.PublicKey, edKey} {
+		jwk, err := NewJWK(pub, "")
+		if err != nil {
+			t.Fatalf("NewJWK(%T) failed: %v", pub, err)
+		}
+
+		if jwk.Kid == "" {
+			t.Fatalf("no kid for %T", pub)
+		}
+
+		back, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatalf("PublicKey() failed: %v", err)
+		}
+
+		if !pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(back) {
+			t.Fatalf("key %T changed after round trip", pub)
+		}
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	// example from RFC 7638 section 3.1
+	jwk := JWK{
+		Kty: "RSA",
+		E:   "AQAB",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn6" +
+			"4tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91Cb" +
+			"OpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+
+	tp, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; tp != expected {
+		t.Fatalf("thumbprint is %s, expected %s", tp, expected)
+	}
+}
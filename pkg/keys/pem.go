@@ -0,0 +1,177 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNotPEM indicates data not being PEM encoded.
+	ErrNotPEM = errors.New("invalid key: must be PEM encoded")
+	// ErrNotEd25519 indicates a key of another type.
+	ErrNotEd25519 = errors.New("key is not a valid Ed25519 key")
+	// ErrNoKey indicates PEM data without any usable key.
+	ErrNoKey = errors.New("no key found in PEM data")
+)
+
+// ParsePublicKey parses PEM encoded public key data. It accepts PKIX and
+// PKCS#1 public keys, and certificates, in which case the public key is the
+// first certificate's one and the chain is returned.
+func ParsePublicKey(data []byte) (pub crypto.PublicKey, chain []*x509.Certificate, err error) {
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			chain = append(chain, cert)
+
+		case "PUBLIC KEY":
+			if pub == nil {
+				if pub, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+					return nil, nil, err
+				}
+			}
+
+		case "RSA PUBLIC KEY":
+			if pub == nil {
+				if pub, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+
+	if len(chain) != 0 {
+		pub = chain[0].PublicKey
+	}
+
+	if pub == nil {
+		if !strings.Contains(string(data), "-----BEGIN") {
+			return nil, nil, ErrNotPEM
+		}
+		return nil, nil, ErrNoKey
+	}
+
+	return
+}
+
+// ParsePrivateKey parses a PEM encoded private key, in PKCS#1, PKCS#8 or SEC1 format.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		var (
+			key interface{}
+			err error
+		)
+
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, errors.New("encrypted private keys are not supported")
+		default:
+			// EC PARAMETERS, certificates...
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type: %T", key)
+		}
+		return signer, nil
+	}
+
+	if !strings.Contains(string(data), "-----BEGIN") {
+		return nil, ErrNotPEM
+	}
+	return nil, ErrNoKey
+}
+
+// ParseEd25519PublicKeyFromPEM parses a PEM encoded Ed25519 public key or certificate.
+func ParseEd25519PublicKeyFromPEM(data []byte) (ed25519.PublicKey, error) {
+	pub, _, err := ParsePublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	k, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return nil, ErrNotEd25519
+	}
+
+	return k, nil
+}
+
+// KeyPairMatches checks that the private key is the one of the public key.
+func KeyPairMatches(private crypto.Signer, public crypto.PublicKey) bool {
+	pub, ok := private.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(public)
+}
+
+// DefaultAlgorithm returns the JWS algorithm to use with the given key.
+func DefaultAlgorithm(pub crypto.PublicKey) (string, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "RS256", nil
+
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return "ES256", nil
+		case 384:
+			return "ES384", nil
+		case 521:
+			return "ES512", nil
+		}
+
+	case ed25519.PublicKey:
+		return "EdDSA", nil
+	}
+
+	return "", fmt.Errorf("%w: %T", ErrUnsupportedKey, pub)
+}
+
+// AlgorithmMatches checks that the JWS algorithm can be used with the given key.
+func AlgorithmMatches(alg string, pub crypto.PublicKey) bool {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+
+	case *ecdsa.PublicKey:
+		expected, _ := DefaultAlgorithm(k)
+		return alg == expected
+
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+
+	return false
+}
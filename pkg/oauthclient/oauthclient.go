@@ -0,0 +1,138 @@
+// Package oauthclient describes the registered OAuth2 clients: services
+// getting tokens for themselves with the client credentials grant, and apps
+// getting tokens for their users with the authorization code flow.
+package oauthclient
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/isi-nc/autentigo/pkg/passhash"
+)
+
+// ErrUnknownClient indicates a client missing from the registry.
+var ErrUnknownClient = errors.New("unknown client")
+
+// ErrAudienceRequired indicates a token request without audience, for a client allowed several.
+var ErrAudienceRequired = errors.New("an audience is required")
+
+// ErrAudienceNotAllowed indicates a token request for an audience the client isn't allowed.
+var ErrAudienceNotAllowed = errors.New("audience not allowed")
+
+// ErrRedirectURIRequired indicates an authorization request without redirect URI, for a client having several.
+var ErrRedirectURIRequired = errors.New("a redirect_uri is required")
+
+// ErrRedirectURINotAllowed indicates a redirect URI the client didn't register.
+var ErrRedirectURINotAllowed = errors.New("redirect_uri not registered")
+
+const secretSize = 32
+
+// Client is a registered client.
+type Client struct {
+	// ID is the client_id, and the sub claim of its tokens.
+	ID string `json:"id" bson:"_id"`
+	// SecretHash is the hash of the client's secret (see passhash). Public
+	// clients, like browser apps, have none.
+	SecretHash string `json:"secret_hash" bson:"secret_hash"`
+	// Audiences are the audiences the client can get tokens for. Clients
+	// without audiences get tokens without aud claim.
+	Audiences []string `json:"audiences,omitempty" bson:"audiences,omitempty"`
+	// Groups of the client, in its tokens.
+	Groups []string `json:"groups,omitempty" bson:"groups,omitempty"`
+	// TokenLifetime is the lifetime of the client's tokens, in seconds (0: the server's token duration).
+	TokenLifetime int64 `json:"token_lifetime,omitempty" bson:"token_lifetime,omitempty"`
+	// RedirectURIs are where the authorization code flow can send the
+	// client's users back (exact match).
+	RedirectURIs []string `json:"redirect_uris,omitempty" bson:"redirect_uris,omitempty"`
+}
+
+// GenerateSecret returns a new random secret.
+func GenerateSecret() (string, error) {
+	ba := make([]byte, secretSize)
+	if _, err := rand.Read(ba); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(ba), nil
+}
+
+// CheckSecret tells if the secret is the client's.
+func (c *Client) CheckSecret(secret string) (bool, error) {
+	if c.SecretHash == "" {
+		return false, nil
+	}
+
+	return passhash.Verify(c.SecretHash, secret)
+}
+
+// Public tells if the client has no secret: it can only use the authorization code flow.
+func (c *Client) Public() bool {
+	return c.SecretHash == ""
+}
+
+// Audience returns the audience of a token requested for the given audience
+// (empty if none requested): the client's only audience is the default.
+func (c *Client) Audience(requested string) (string, error) {
+	if requested == "" {
+		switch len(c.Audiences) {
+		case 0:
+			return "", nil
+		case 1:
+			return c.Audiences[0], nil
+		default:
+			return "", ErrAudienceRequired
+		}
+	}
+
+	for _, aud := range c.Audiences {
+		if aud == requested {
+			return aud, nil
+		}
+	}
+
+	return "", ErrAudienceNotAllowed
+}
+
+// Lifetime returns the lifetime of the client's tokens, the given default if not set.
+func (c *Client) Lifetime(defaultLifetime time.Duration) time.Duration {
+	if c.TokenLifetime <= 0 {
+		return defaultLifetime
+	}
+
+	return time.Duration(c.TokenLifetime) * time.Second
+}
+
+// RedirectURI returns the URI where the users are sent back for the given URI
+// (empty if none requested): the client's only redirect URI is the default.
+func (c *Client) RedirectURI(requested string) (string, error) {
+	if requested == "" {
+		if len(c.RedirectURIs) != 1 {
+			return "", ErrRedirectURIRequired
+		}
+		return c.RedirectURIs[0], nil
+	}
+
+	for _, uri := range c.RedirectURIs {
+		if uri == requested {
+			return uri, nil
+		}
+	}
+
+	return "", ErrRedirectURINotAllowed
+}
+
+// ValidRedirectURI tells if the URI can be registered: it must be absolute,
+// without fragment (RFC 6749 section 3.1.2), and without comma (lists of URIs
+// are comma separated in some backends).
+func ValidRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+
+	return u.IsAbs() && u.Fragment == "" && !strings.Contains(uri, ",")
+}
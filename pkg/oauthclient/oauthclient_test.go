@@ -0,0 +1,100 @@
+package oauthclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/isi-nc/autentigo/pkg/passhash"
+)
+
+func TestCheckSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := passhash.Hash(passhash.SSHA512, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Client{ID: "billing", SecretHash: hash}
+
+	if ok, err := c.CheckSecret(secret); err != nil || !ok {
+		t.Errorf("secret not verified: %v", err)
+	}
+	if ok, _ := c.CheckSecret(secret + "x"); ok {
+		t.Error("wrong secret verified")
+	}
+	if ok, _ := (&Client{ID: "no-secret"}).CheckSecret(""); ok {
+		t.Error("client without secret verified")
+	}
+}
+
+func TestAudience(t *testing.T) {
+	for _, tc := range []struct {
+		audiences           []string
+		requested, expected string
+		err                 error
+	}{
+		{nil, "", "", nil},
+		{nil, "app", "", ErrAudienceNotAllowed},
+		{[]string{"app"}, "", "app", nil},
+		{[]string{"app", "api"}, "", "", ErrAudienceRequired},
+		{[]string{"app", "api"}, "api", "api", nil},
+		{[]string{"app", "api"}, "other", "", ErrAudienceNotAllowed},
+	} {
+		c := &Client{Audiences: tc.audiences}
+
+		aud, err := c.Audience(tc.requested)
+		if aud != tc.expected || err != tc.err {
+			t.Errorf("%v, %q: got %q, %v; expected %q, %v", tc.audiences, tc.requested, aud, err, tc.expected, tc.err)
+		}
+	}
+}
+
+func TestLifetime(t *testing.T) {
+	if d := (&Client{}).Lifetime(time.Hour); d != time.Hour {
+		t.Errorf("expected the default lifetime, got %v", d)
+	}
+	if d := (&Client{TokenLifetime: 300}).Lifetime(time.Hour); d != 5*time.Minute {
+		t.Errorf("expected 5m, got %v", d)
+	}
+}
+
+func TestRedirectURI(t *testing.T) {
+	for _, tc := range []struct {
+		uris                []string
+		requested, expected string
+		err                 error
+	}{
+		{nil, "", "", ErrRedirectURIRequired},
+		{nil, "https://app/cb", "", ErrRedirectURINotAllowed},
+		{[]string{"https://app/cb"}, "", "https://app/cb", nil},
+		{[]string{"https://app/cb"}, "https://app/cb/", "", ErrRedirectURINotAllowed},
+		{[]string{"https://app/cb", "http://localhost/cb"}, "", "", ErrRedirectURIRequired},
+		{[]string{"https://app/cb", "http://localhost/cb"}, "http://localhost/cb", "http://localhost/cb", nil},
+	} {
+		c := &Client{RedirectURIs: tc.uris}
+
+		uri, err := c.RedirectURI(tc.requested)
+		if uri != tc.expected || err != tc.err {
+			t.Errorf("%v, %q: got %q, %v; expected %q, %v", tc.uris, tc.requested, uri, err, tc.expected, tc.err)
+		}
+	}
+}
+
+func TestValidRedirectURI(t *testing.T) {
+	for uri, valid := range map[string]bool{
+		"https://app.example.com/cb":   true,
+		"http://localhost:3000/cb?a=b": true,
+		"com.example.app:/cb":          true,
+		"/cb":                          false,
+		"https://app/cb#fragment":      false,
+		"https://app/cb?a=b,c":         false,
+	} {
+		if ValidRedirectURI(uri) != valid {
+			t.Errorf("%q: expected valid %v", uri, valid)
+		}
+	}
+}
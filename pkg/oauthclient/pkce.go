@@ -0,0 +1,47 @@
+package oauthclient
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+)
+
+// CodeChallengeS256 is the only supported PKCE code challenge method (RFC 7636):
+// the challenge is the base64url SHA-256 of the verifier.
+const CodeChallengeS256 = "S256"
+
+// ValidCodeChallenge tells if the S256 code challenge is well-formed.
+func ValidCodeChallenge(challenge string) bool {
+	ba, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil && len(ba) == sha256.Size
+}
+
+// VerifyCodeVerifier tells if the code verifier is the one of the S256 challenge.
+func VerifyCodeVerifier(challenge, verifier string) bool {
+	if !validCodeVerifier(verifier) {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// validCodeVerifier checks the verifier is 43 to 128 unreserved characters (RFC 7636 section 4.1).
+func validCodeVerifier(verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	for _, c := range verifier {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,25 @@
+package oauthclient
+
+import "testing"
+
+func TestVerifyCodeVerifier(t *testing.T) {
+	// RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if !ValidCodeChallenge(challenge) {
+		t.Error("challenge should be valid")
+	}
+	if !VerifyCodeVerifier(challenge, verifier) {
+		t.Error("verifier not verified")
+	}
+	if VerifyCodeVerifier(challenge, verifier[1:]+"A") {
+		t.Error("wrong verifier verified")
+	}
+	if VerifyCodeVerifier(challenge, "short") {
+		t.Error("short verifier verified")
+	}
+	if ValidCodeChallenge("plain-challenge") {
+		t.Error("malformed challenge should be invalid")
+	}
+}
@@ -0,0 +1,151 @@
+package passhash
+
+import (
+	"crypto/sha512"
+	"strconv"
+	"strings"
+)
+
+// crypt(3) SHA-512 (https://www.akkadia.org/drepper/SHA-crypt.txt)
+
+const (
+	cryptAlphabet      = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	cryptMaxSalt       = 16
+	cryptDefaultRounds = 5000
+	cryptMinRounds     = 1000
+	cryptMaxRounds     = 999999999
+)
+
+// parseCrypt reads the parameters of a $6$[rounds=N$]salt$hash string.
+func parseCrypt(value string) (salt string, rounds int, custom bool, ok bool) {
+	if !strings.HasPrefix(value, "$6$") {
+		return
+	}
+
+	parts := strings.Split(value[3:], "$")
+	rounds = cryptDefaultRounds
+
+	if len(parts) == 3 && strings.HasPrefix(parts[0], "rounds=") {
+		n, err := strconv.Atoi(strings.TrimPrefix(parts[0], "rounds="))
+		if err != nil {
+			return
+		}
+		rounds, custom = n, true
+		parts = parts[1:]
+	}
+
+	if len(parts) != 2 {
+		return
+	}
+
+	return parts[0], rounds, custom, true
+}
+
+// cryptSalt encodes random bytes as a crypt salt.
+func cryptSalt(random []byte) string {
+	salt := make([]byte, len(random))
+	for i, b := range random {
+		salt[i] = cryptAlphabet[int(b)%len(cryptAlphabet)]
+	}
+	return string(salt)
+}
+
+func sha512Crypt(password, salt []byte, rounds int, custom bool) string {
+	if len(salt) > cryptMaxSalt {
+		salt = salt[:cryptMaxSalt]
+	}
+
+	if rounds < cryptMinRounds {
+		rounds = cryptMinRounds
+	} else if rounds > cryptMaxRounds {
+		rounds = cryptMaxRounds
+	}
+
+	// digest B
+	d := sha512.New()
+	d.Write(password)
+	d.Write(salt)
+	d.Write(password)
+	b := d.Sum(nil)
+
+	// digest A
+	d.Reset()
+	d.Write(password)
+	d.Write(salt)
+	d.Write(repeat(b, len(password)))
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			d.Write(b)
+		} else {
+			d.Write(password)
+		}
+	}
+	a := d.Sum(nil)
+
+	// sequences P and S
+	d.Reset()
+	for range password {
+		d.Write(password)
+	}
+	p := repeat(d.Sum(nil), len(password))
+
+	d.Reset()
+	for i := 0; i < 16+int(a[0]); i++ {
+		d.Write(salt)
+	}
+	s := repeat(d.Sum(nil), len(salt))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		d.Reset()
+		if i&1 != 0 {
+			d.Write(p)
+		} else {
+			d.Write(c)
+		}
+		if i%3 != 0 {
+			d.Write(s)
+		}
+		if i%7 != 0 {
+			d.Write(p)
+		}
+		if i&1 != 0 {
+			d.Write(c)
+		} else {
+			d.Write(p)
+		}
+		c = d.Sum(nil)
+	}
+
+	out := &strings.Builder{}
+	out.WriteString("$6$")
+	if custom {
+		out.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+	out.Write(salt)
+	out.WriteByte('$')
+
+	for i := 0; i < 21; i++ {
+		encode24(out, c[i*22%63], c[(i*22+21)%63], c[(i*22+42)%63], 4)
+	}
+	encode24(out, 0, 0, c[63], 2)
+
+	return out.String()
+}
+
+// repeat the data until it is n bytes long.
+func repeat(data []byte, n int) []byte {
+	out := make([]byte, 0, n+len(data))
+	for len(out) < n {
+		out = append(out, data...)
+	}
+	return out[:n]
+}
+
+func encode24(out *strings.Builder, b2, b1, b0 byte, n int) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for i := 0; i < n; i++ {
+		out.WriteByte(cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
@@ -0,0 +1,273 @@
+// Package passhash hashes and verifies passwords. Hashes are prefixed by their
+// scheme, like {BCRYPT}$2a$10$..., except legacy unsalted SHA256 hex digests.
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Schemes.
+const (
+	// Bcrypt is {BCRYPT} followed by a bcrypt hash ($2a$...).
+	Bcrypt = "BCRYPT"
+	// Argon2 is {ARGON2} followed by an argon2id PHC string ($argon2id$v=19$m=...,t=...,p=...$salt$hash).
+	Argon2 = "ARGON2"
+	// PBKDF2 is {PBKDF2} followed by <iterations>$<salt>$<hash> (adapted base64, HMAC-SHA1).
+	PBKDF2 = "PBKDF2"
+	// PBKDF2SHA256 is PBKDF2 with HMAC-SHA256.
+	PBKDF2SHA256 = "PBKDF2-SHA256"
+	// PBKDF2SHA512 is PBKDF2 with HMAC-SHA512.
+	PBKDF2SHA512 = "PBKDF2-SHA512"
+	// SSHA is {SSHA} followed by base64(sha1(password+salt)+salt).
+	SSHA = "SSHA"
+	// SSHA256 is SSHA with SHA256.
+	SSHA256 = "SSHA256"
+	// SSHA512 is SSHA with SHA512.
+	SSHA512 = "SSHA512"
+	// Crypt is {CRYPT} followed by a crypt(3) SHA-512 hash ($6$...).
+	Crypt = "CRYPT"
+	// SHA256 is the legacy unsalted hex digest, without prefix. Don't use it for new passwords.
+	SHA256 = "SHA256"
+)
+
+var (
+	// ErrUnknownScheme indicates a hash with an unsupported scheme.
+	ErrUnknownScheme = errors.New("unknown password hash scheme")
+	// ErrMalformed indicates a hash not matching its scheme's format.
+	ErrMalformed = errors.New("malformed password hash")
+)
+
+// parameters of new hashes
+const (
+	saltSize = 16
+
+	argon2Time    = 2
+	argon2Memory  = 19 * 1024
+	argon2Threads = 1
+	argon2KeySize = 32
+
+	cryptRounds = 100000
+)
+
+var pbkdf2Iterations = map[string]int{
+	PBKDF2:       1300000,
+	PBKDF2SHA256: 600000,
+	PBKDF2SHA512: 210000,
+}
+
+var pbkdf2Hashes = map[string]func() hash.Hash{
+	PBKDF2:       sha1.New,
+	PBKDF2SHA256: sha256.New,
+	PBKDF2SHA512: sha512.New,
+}
+
+var sshaHashes = map[string]func() hash.Hash{
+	SSHA:    sha1.New,
+	SSHA256: sha256.New,
+	SSHA512: sha512.New,
+}
+
+// ab64 is the adapted base64 of PBKDF2 hashes (. instead of +, no padding).
+var ab64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789./").WithPadding(base64.NoPadding)
+
+// Supported tells if the scheme can be used to hash passwords.
+func Supported(scheme string) bool {
+	switch strings.ToUpper(scheme) {
+	case Bcrypt, Argon2, PBKDF2, PBKDF2SHA256, PBKDF2SHA512, SSHA, SSHA256, SSHA512, Crypt, SHA256:
+		return true
+	}
+	return false
+}
+
+// Scheme returns the scheme of a hash.
+func Scheme(hash string) string {
+	if strings.HasPrefix(hash, "{") {
+		if end := strings.IndexByte(hash, '}'); end > 0 {
+			return strings.ToUpper(hash[1:end])
+		}
+	}
+	return SHA256
+}
+
+// NeedsRehash tells if the hash isn't of the preferred scheme.
+func NeedsRehash(hash, preferred string) bool {
+	return Scheme(hash) != strings.ToUpper(preferred)
+}
+
+// Hash the password with the scheme and a random salt.
+func Hash(scheme, password string) (string, error) {
+	scheme = strings.ToUpper(scheme)
+
+	if scheme == SHA256 {
+		ba := sha256.Sum256([]byte(password))
+		return hex.EncodeToString(ba[:]), nil
+	}
+
+	if scheme == Bcrypt {
+		ba, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
+		return "{" + Bcrypt + "}" + string(ba), nil
+	}
+
+	if !Supported(scheme) {
+		return "", ErrUnknownScheme
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	var value string
+	switch scheme {
+	case Argon2:
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeySize)
+		value = fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+	case PBKDF2, PBKDF2SHA256, PBKDF2SHA512:
+		iterations := pbkdf2Iterations[scheme]
+		h := pbkdf2Hashes[scheme]
+		key := pbkdf2.Key([]byte(password), salt, iterations, h().Size(), h)
+		value = strconv.Itoa(iterations) + "$" + ab64.EncodeToString(salt) + "$" + ab64.EncodeToString(key)
+
+	case SSHA, SSHA256, SSHA512:
+		value = base64.StdEncoding.EncodeToString(append(saltedHash(sshaHashes[scheme], password, salt), salt...))
+
+	case Crypt:
+		value = sha512Crypt([]byte(password), []byte(cryptSalt(salt)), cryptRounds, true)
+	}
+
+	return "{" + scheme + "}" + value, nil
+}
+
+// Verify tells if the password matches the hash. The comparison is constant
+// time. An error is returned for unknown schemes and malformed hashes.
+func Verify(hash, password string) (bool, error) {
+	scheme := Scheme(hash)
+	value := hash
+	if strings.HasPrefix(hash, "{") {
+		if scheme == SHA256 {
+			// only the legacy bare hex is supported
+			return false, ErrUnknownScheme
+		}
+		value = hash[len(scheme)+2:]
+	}
+
+	var expected, actual []byte
+
+	switch scheme {
+	case SHA256:
+		ba := sha256.Sum256([]byte(password))
+		expected, actual = []byte(strings.ToLower(value)), []byte(hex.EncodeToString(ba[:]))
+
+	case Bcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(value), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		} else if err != nil {
+			return false, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		return true, nil
+
+	case Argon2:
+		var version, memory, time, threads int
+		parts := strings.Split(value, "$")
+		if len(parts) != 6 || parts[1] != "argon2id" {
+			return false, ErrMalformed
+		}
+		if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+			return false, ErrMalformed
+		}
+		if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil ||
+			memory <= 0 || time <= 0 || threads <= 0 || threads > 255 {
+			return false, ErrMalformed
+		}
+
+		salt, err1 := base64.RawStdEncoding.DecodeString(parts[4])
+		key, err2 := base64.RawStdEncoding.DecodeString(parts[5])
+		if err1 != nil || err2 != nil || len(key) == 0 {
+			return false, ErrMalformed
+		}
+
+		expected = key
+		actual = argon2.IDKey([]byte(password), salt, uint32(time), uint32(memory), uint8(threads), uint32(len(key)))
+
+	case PBKDF2, PBKDF2SHA256, PBKDF2SHA512:
+		parts := strings.Split(value, "$")
+		if len(parts) != 3 {
+			return false, ErrMalformed
+		}
+
+		iterations, err := strconv.Atoi(parts[0])
+		salt, err1 := ab64.DecodeString(parts[1])
+		key, err2 := ab64.DecodeString(parts[2])
+		if err != nil || err1 != nil || err2 != nil || iterations <= 0 || len(key) == 0 {
+			return false, ErrMalformed
+		}
+
+		expected = key
+		actual = pbkdf2.Key([]byte(password), salt, iterations, len(key), pbkdf2Hashes[scheme])
+
+	case SSHA, SSHA256, SSHA512:
+		h := sshaHashes[scheme]
+		ba, err := base64.StdEncoding.DecodeString(value)
+		size := h().Size()
+		if err != nil || len(ba) <= size {
+			return false, ErrMalformed
+		}
+
+		expected = ba[:size]
+		actual = saltedHash(h, password, ba[size:])
+
+	case Crypt:
+		salt, rounds, custom, ok := parseCrypt(value)
+		if !ok {
+			return false, ErrMalformed
+		}
+
+		expected, actual = []byte(value), []byte(sha512Crypt([]byte(password), []byte(salt), rounds, custom))
+
+	default:
+		return false, ErrUnknownScheme
+	}
+
+	return subtle.ConstantTimeCompare(expected, actual) == 1, nil
+}
+
+func saltedHash(h func() hash.Hash, password string, salt []byte) []byte {
+	d := h()
+	d.Write([]byte(password))
+	d.Write(salt)
+	return d.Sum(nil)
+}
+
+// Check verifies the password like Verify. If it matches and rehash is a
+// scheme other than the hash's, newHash is the password hashed with it, to
+// store instead.
+func Check(hash, password, rehash string) (ok bool, newHash string, err error) {
+	ok, err = Verify(hash, password)
+	if !ok || err != nil || rehash == "" || !NeedsRehash(hash, rehash) {
+		return
+	}
+
+	newHash, err = Hash(rehash, password)
+	return
+}
@@ -0,0 +1,73 @@
+package passhash
+
+import (
+	"testing"
+)
+
+func TestVerifyKnownHashes(t *testing.T) {
+	for _, tc := range []struct {
+		hash, password string
+	}{
+		// sha256("secret")
+		{"2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", "secret"},
+		{"{SSHA}FTtLy4UHGF6CE6rK0obse+lIYuYwMTIzNDU2Nzg5YWJjZGVm", "secret"},
+		{"{PBKDF2-SHA256}1000$MDEyMzQ1Njc4OWFiY2RlZg$tiKWHy4FAGCWE8gn6GtKhaxD2OeeAUUWXFT/p1aaNl8", "secret"},
+		// from the SHA-crypt specification
+		{"{CRYPT}$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "Hello world!"},
+		{"{CRYPT}$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.", "Hello world!"},
+	} {
+		ok, err := Verify(tc.hash, tc.password)
+		if err != nil {
+			t.Errorf("%s: %v", tc.hash, err)
+		} else if !ok {
+			t.Errorf("%s: password not verified", tc.hash)
+		}
+
+		if ok, _ := Verify(tc.hash, tc.password+"x"); ok {
+			t.Errorf("%s: wrong password verified", tc.hash)
+		}
+	}
+}
+
+func TestHashAndVerify(t *testing.T) {
+	for _, scheme := range []string{Bcrypt, Argon2, PBKDF2SHA512, SSHA, SSHA512, Crypt, SHA256, "pbkdf2-sha256"} {
+		hash, err := Hash(scheme, "p4ss")
+		if err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+
+		if NeedsRehash(hash, scheme) {
+			t.Errorf("%s: %s should not need a rehash", scheme, hash)
+		}
+
+		if ok, err := Verify(hash, "p4ss"); err != nil || !ok {
+			t.Errorf("%s: %s not verified: %v", scheme, hash, err)
+		}
+
+		if ok, err := Verify(hash, "pass"); err != nil || ok {
+			t.Errorf("%s: %s verified a wrong password: %v", scheme, hash, err)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := Hash("MD5", "p"); err != ErrUnknownScheme {
+		t.Error("expected an unknown scheme, got", err)
+	}
+
+	for _, hash := range []string{"{MD5}abc", "{SHA256}abc"} {
+		if _, err := Verify(hash, "p"); err != ErrUnknownScheme {
+			t.Errorf("%s: expected an unknown scheme, got %v", hash, err)
+		}
+	}
+
+	for _, hash := range []string{"{SSHA}!!", "{CRYPT}$1$abc$def", "{ARGON2}$argon2i$v=19$m=1,t=1,p=1$YQ$YQ", "{PBKDF2}x$y"} {
+		if _, err := Verify(hash, "p"); err != ErrMalformed {
+			t.Errorf("%s: expected a malformed hash, got %v", hash, err)
+		}
+	}
+
+	if !NeedsRehash("2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", Bcrypt) {
+		t.Error("legacy hash should need a rehash")
+	}
+}
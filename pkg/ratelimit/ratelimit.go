@@ -0,0 +1,377 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	yaml "github.com/projectcalico/go-yaml-wrapper"
+)
+
+// Keys of the rules.
+const (
+	// KeyGlobal shares one bucket between every client.
+	KeyGlobal = "global"
+	// KeyIP gives a bucket to each client IP.
+	KeyIP = "ip"
+	// KeyUser gives a bucket to each user name.
+	KeyUser = "user"
+)
+
+// Rejected counts the rejected requests, by rule (published as the ratelimit_rejected expvar).
+var Rejected = expvar.NewMap("ratelimit_rejected")
+
+// maxBodyPeek is the size of the request body read to find the user name.
+const maxBodyPeek = 64 << 10
+
+// Config is the rate limiting configuration.
+type Config struct {
+	// Rules are all applied: a request must be allowed by every matching rule.
+	Rules []Rule
+
+	// TrustedProxies are the networks (CIDR) of the proxies whose X-Forwarded-For header is trusted.
+	TrustedProxies []string
+}
+
+// Rule is a token bucket limit.
+type Rule struct {
+	// Name of the rule in metrics (default: routes and key).
+	Name string
+
+	// Routes are the request paths limited (like /basic), every path if empty.
+	Routes []string
+
+	// Key tells how clients are told apart: global (default), ip or user.
+	// Requests without a user name are not limited by user rules.
+	Key string
+
+	// Rate is the number of requests allowed per second.
+	Rate float64
+
+	// Burst is the number of requests allowed at once (default: 1 or the rate).
+	Burst int
+}
+
+// FromFile loads the configuration from a YAML file.
+func FromFile(path string) (config *Config, err error) {
+	ba, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	return FromBytes(ba)
+}
+
+// FromBytes loads the configuration from YAML data.
+func FromBytes(ba []byte) (config *Config, err error) {
+	config = &Config{}
+
+	if err = yaml.UnmarshalStrict(ba, config); err != nil {
+		return
+	}
+
+	return
+}
+
+// New Limiter applying the configuration.
+func New(config *Config) (*Limiter, error) {
+	l := &Limiter{}
+
+	for _, cidr := range config.TrustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxies: %w", err)
+		}
+		l.trusted = append(l.trusted, network)
+	}
+
+	for i, rule := range config.Rules {
+		switch rule.Key {
+		case "":
+			rule.Key = KeyGlobal
+		case KeyGlobal, KeyIP, KeyUser:
+		default:
+			return nil, fmt.Errorf("rule %d: unknown key %q", i, rule.Key)
+		}
+
+		if rule.Rate <= 0 {
+			return nil, fmt.Errorf("rule %d: the rate must be positive", i)
+		}
+
+		if rule.Burst <= 0 {
+			rule.Burst = int(math.Max(1, math.Ceil(rule.Rate)))
+		}
+
+		if rule.Name == "" {
+			rule.Name = strings.Join(rule.Routes, ",") + ":" + rule.Key
+		}
+
+		routes := map[string]bool{}
+		for _, route := range rule.Routes {
+			routes[route] = true
+		}
+
+		l.rules = append(l.rules, &limit{
+			Rule:    rule,
+			routes:  routes,
+			buckets: map[string]*bucket{},
+		})
+	}
+
+	return l, nil
+}
+
+// Limiter limits requests rates.
+type Limiter struct {
+	rules   []*limit
+	trusted []*net.IPNet
+
+	// now is time.Now, except in tests
+	now func() time.Time
+}
+
+type limit struct {
+	Rule
+	routes map[string]bool
+
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Filter is a go-restful container filter rejecting requests over the limits
+// with a 429 Too Many Requests status.
+func (l *Limiter) Filter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	if retryAfter, ok := l.Allow(req.Request); !ok {
+		resp.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		resp.WriteErrorString(http.StatusTooManyRequests, "Too many requests.\n")
+		return
+	}
+
+	chain.ProcessFilter(req, resp)
+}
+
+// Allow takes a token from the buckets of the request. When not allowed, it
+// gives the time before the request could be, and no token is taken: a
+// request rejected by a rule doesn't use the budget of the others.
+func (l *Limiter) Allow(r *http.Request) (retryAfter time.Duration, ok bool) {
+	now := time.Now()
+	if l.now != nil {
+		now = l.now()
+	}
+
+	var ip, user string
+	var userDone bool
+
+	var rules []*limit
+	var keys []string
+
+	for _, rule := range l.rules {
+		if len(rule.routes) != 0 && !rule.routes[r.URL.Path] {
+			continue
+		}
+
+		var key string
+		switch rule.Key {
+		case KeyIP:
+			if ip == "" {
+				ip = l.ClientIP(r)
+			}
+			key = ip
+
+		case KeyUser:
+			if !userDone {
+				user, userDone = userName(r), true
+			}
+			if user == "" {
+				continue
+			}
+			key = user
+		}
+
+		rules = append(rules, rule)
+		keys = append(keys, key)
+	}
+
+	// check every bucket before taking from any. The rules are always locked
+	// in the same order, so concurrent requests can't deadlock.
+	for _, rule := range rules {
+		rule.mutex.Lock()
+		defer rule.mutex.Unlock()
+	}
+
+	buckets := make([]*bucket, len(rules))
+	for i, rule := range rules {
+		buckets[i] = rule.bucket(keys[i], now)
+
+		if wait := buckets[i].wait(rule.Rule); wait > 0 {
+			Rejected.Add(rule.Name, 1)
+			if wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+	}
+
+	if retryAfter > 0 {
+		return retryAfter, false
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+
+	return 0, true
+}
+
+// bucket returns the key's bucket, refilled. The mutex must be held.
+func (rule *limit) bucket(key string, now time.Time) *bucket {
+	rule.sweep(now)
+
+	b, ok := rule.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rule.Burst), last: now}
+		rule.buckets[key] = b
+	}
+
+	b.refill(rule.Rule, now)
+	return b
+}
+
+// wait returns the time before a token is available (0 if there is one).
+func (b *bucket) wait(rule Rule) time.Duration {
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rule.Rate * float64(time.Second))
+	}
+	return 0
+}
+
+func (b *bucket) refill(rule Rule, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(rule.Burst), b.tokens+elapsed.Seconds()*rule.Rate)
+		b.last = now
+	}
+}
+
+// sweep drops the full buckets, they are the same as new ones. The mutex must be held.
+func (rule *limit) sweep(now time.Time) {
+	if now.Sub(rule.lastSweep) < time.Minute {
+		return
+	}
+	rule.lastSweep = now
+
+	for key, b := range rule.buckets {
+		b.refill(rule.Rule, now)
+		if b.tokens >= float64(rule.Burst) {
+			delete(rule.buckets, key)
+		}
+	}
+}
+
+// ClientIP returns the IP of the client. When the request comes from a trusted
+// proxy, it is the last address of X-Forwarded-For that is not a trusted proxy.
+func (l *Limiter) ClientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	if !l.isTrusted(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if addr == "" {
+			continue
+		}
+
+		ip = addr
+		if !l.isTrusted(ip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+func (l *Limiter) isTrusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range l.trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// userName finds the user of an authentication request: the basic auth user,
+// or the user given in a JSON body (simple or keystone request).
+func userName(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok {
+		return user
+	}
+
+	if r.Body == nil || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return ""
+	}
+
+	// read the start of the body, and put it back for the handler
+	peek, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodyPeek))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peek), r.Body), r.Body}
+	if err != nil {
+		return ""
+	}
+
+	body := struct {
+		User string `json:"user"`
+		Auth struct {
+			Identity struct {
+				Password struct {
+					User struct {
+						ID   string `json:"id"`
+						Name string `json:"name"`
+					} `json:"user"`
+				} `json:"password"`
+			} `json:"identity"`
+		} `json:"auth"`
+	}{}
+
+	if json.Unmarshal(peek, &body) != nil {
+		return ""
+	}
+
+	keystone := body.Auth.Identity.Password.User
+	switch {
+	case body.User != "":
+		return body.User
+	case keystone.ID != "":
+		return keystone.ID
+	default:
+		return keystone.Name
+	}
+}
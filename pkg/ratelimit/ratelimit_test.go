@@ -0,0 +1,195 @@
+package ratelimit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+trustedProxies:
+- 10.0.0.0/8
+rules:
+- routes: [/basic, /simple]
+  key: ip
+  rate: 1
+  burst: 2
+- name: per-user
+  key: user
+  rate: 0.5
+`
+
+func newTestLimiter(t *testing.T) (*Limiter, *time.Time) {
+	config, err := FromBytes([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+
+	return l, &now
+}
+
+func TestIPBucket(t *testing.T) {
+	l, now := newTestLimiter(t)
+
+	req := func() *http.Request {
+		r := httptest.NewRequest("GET", "/basic", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		return r
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, ok := l.Allow(req()); !ok {
+			t.Fatalf("request %d should be allowed (burst)", i)
+		}
+	}
+
+	wait, ok := l.Allow(req())
+	if ok {
+		t.Fatal("request over the burst should be rejected")
+	}
+	if wait != time.Second {
+		t.Error("expected to wait 1s, got", wait)
+	}
+
+	// other clients and routes are not affected
+	other := req()
+	other.RemoteAddr = "192.0.2.2:1234"
+	if _, ok := l.Allow(other); !ok {
+		t.Error("other IP should be allowed")
+	}
+	if _, ok := l.Allow(httptest.NewRequest("GET", "/jwks", nil)); !ok {
+		t.Error("other route should be allowed")
+	}
+
+	*now = now.Add(time.Second)
+	if _, ok := l.Allow(req()); !ok {
+		t.Error("request should be allowed after refill")
+	}
+}
+
+func TestUserBucket(t *testing.T) {
+	l, now := newTestLimiter(t)
+
+	req := func(body string) *http.Request {
+		r := httptest.NewRequest("POST", "/token", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		return r
+	}
+
+	if _, ok := l.Allow(req(`{"user":"bob","password":"x"}`)); !ok {
+		t.Fatal("first request should be allowed")
+	}
+
+	r := req(`{"auth":{"identity":{"password":{"user":{"name":"bob","password":"x"}}}}}`)
+	if wait, ok := l.Allow(r); ok || wait != 2*time.Second {
+		t.Fatal("second request of bob should be rejected for 2s, got", ok, wait)
+	}
+
+	// the handler still gets the body
+	body, _ := ioutil.ReadAll(r.Body)
+	if !strings.Contains(string(body), `"bob"`) {
+		t.Error("body not restored:", string(body))
+	}
+
+	if _, ok := l.Allow(req(`{"user":"alice"}`)); !ok {
+		t.Error("alice should be allowed")
+	}
+
+	// no user name, no user limit
+	if _, ok := l.Allow(req(`{}`)); !ok {
+		t.Error("request without user should be allowed")
+	}
+
+	*now = now.Add(2 * time.Second)
+	basic := httptest.NewRequest("GET", "/token", nil)
+	basic.SetBasicAuth("bob", "x")
+	if _, ok := l.Allow(basic); !ok {
+		t.Error("bob should be allowed after refill")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	l, _ := newTestLimiter(t)
+
+	for _, tc := range []struct {
+		remote, forwarded, ip string
+	}{
+		{"192.0.2.1:1", "203.0.113.1", "192.0.2.1"},                // untrusted, header ignored
+		{"10.0.0.1:1", "203.0.113.1", "203.0.113.1"},               // trusted proxy
+		{"10.0.0.1:1", "203.0.113.1, 10.1.1.1", "203.0.113.1"},     // chain of trusted proxies
+		{"10.0.0.1:1", "198.51.100.1, 203.0.113.1", "203.0.113.1"}, // spoofed first entry
+		{"10.0.0.1:1", "", "10.0.0.1"},
+	} {
+		r := httptest.NewRequest("GET", "/basic", nil)
+		r.RemoteAddr = tc.remote
+		if tc.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+
+		if ip := l.ClientIP(r); ip != tc.ip {
+			t.Errorf("%s / %q: expected %s, got %s", tc.remote, tc.forwarded, tc.ip, ip)
+		}
+	}
+}
+
+func TestRejectedRequestsTakeNoToken(t *testing.T) {
+	config, err := FromBytes([]byte(`
+rules:
+- routes: [/basic]
+  rate: 1
+  burst: 3
+- routes: [/basic]
+  key: ip
+  rate: 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+
+	req := func(remoteAddr string) *http.Request {
+		r := httptest.NewRequest("GET", "/basic", nil)
+		r.RemoteAddr = remoteAddr
+		return r
+	}
+
+	if _, ok := l.Allow(req("192.0.2.1:1")); !ok {
+		t.Fatal("first request should be allowed")
+	}
+
+	// rejected by the IP rule, these must not drain the global bucket
+	for i := 0; i < 5; i++ {
+		if _, ok := l.Allow(req("192.0.2.1:1")); ok {
+			t.Fatalf("request %d over the IP limit should be rejected", i)
+		}
+	}
+
+	for _, remoteAddr := range []string{"192.0.2.2:1", "192.0.2.3:1"} {
+		if _, ok := l.Allow(req(remoteAddr)); !ok {
+			t.Errorf("%s should be allowed by the global rule", remoteAddr)
+		}
+	}
+
+	// now the global bucket is empty, the longest wait is given
+	if wait, ok := l.Allow(req("192.0.2.4:1")); ok || wait != time.Second {
+		t.Errorf("expected a rejection for 1s, got %v %v", ok, wait)
+	}
+}
@@ -0,0 +1,160 @@
+package etcd
+
+import (
+	"context"
+	"log"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/isi-nc/autentigo/pkg/store"
+)
+
+type etcdStore struct {
+	prefix  string
+	client  *clientv3.Client
+	timeout time.Duration
+}
+
+// New Store with etcd backend, expirations are handled with leases.
+func New(prefix string, endpoints []string) store.Store {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints: endpoints,
+	})
+
+	if err != nil {
+		log.Fatal("failed to connect to etcd: ", err)
+	}
+
+	timeout := 5 * time.Second
+	if timeoutEnv := os.Getenv("ETCD_TIMEOUT"); timeoutEnv != "" {
+		timeout, err = time.ParseDuration(timeoutEnv)
+		if err != nil {
+			log.Fatalf("invalid ETCD_TIMEOUT %q: %v", timeoutEnv, timeout)
+		}
+	}
+
+	return &etcdStore{
+		prefix:  prefix,
+		client:  client,
+		timeout: timeout,
+	}
+}
+
+var _ store.Store = &etcdStore{}
+
+func (e *etcdStore) Get(ctx context.Context, key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	resp, err := e.client.Get(ctx, e.key(key))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, store.ErrNotFound
+	}
+
+	return resp.Kvs[0].Value, nil
+}
+
+func (e *etcdStore) Put(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	opts, err := e.leaseOpts(ctx, ttl)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.client.Put(ctx, e.key(key), string(value), opts...)
+	return err
+}
+
+func (e *etcdStore) Create(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return e.txn(ctx, key, value, ttl,
+		clientv3.Compare(clientv3.CreateRevision(e.key(key)), "=", 0),
+		store.ErrExists)
+}
+
+func (e *etcdStore) Swap(ctx context.Context, key string, old, value []byte, ttl time.Duration) error {
+	return e.txn(ctx, key, value, ttl,
+		clientv3.Compare(clientv3.Value(e.key(key)), "=", string(old)),
+		store.ErrConflict)
+}
+
+func (e *etcdStore) Delete(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	_, err := e.client.Delete(ctx, e.key(key))
+	return err
+}
+
+func (e *etcdStore) List(ctx context.Context, prefix string) (map[string][]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	fullPrefix := e.key(prefix)
+	if strings.HasSuffix(prefix, "/") {
+		fullPrefix += "/"
+	}
+
+	resp, err := e.client.Get(ctx, fullPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string][]byte, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		values[strings.TrimPrefix(string(kv.Key), e.prefix+"/")] = kv.Value
+	}
+
+	return values, nil
+}
+
+func (e *etcdStore) key(key string) string {
+	return path.Join(e.prefix, key)
+}
+
+func (e *etcdStore) txn(ctx context.Context, key string, value []byte, ttl time.Duration, cmp clientv3.Cmp, failErr error) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	opts, err := e.leaseOpts(ctx, ttl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Txn(ctx).
+		If(cmp).
+		Then(clientv3.OpPut(e.key(key), string(value), opts...)).
+		Commit()
+	if err != nil {
+		return err
+	}
+
+	if !resp.Succeeded {
+		return failErr
+	}
+
+	return nil
+}
+
+func (e *etcdStore) leaseOpts(ctx context.Context, ttl time.Duration) ([]clientv3.OpOption, error) {
+	if ttl == 0 {
+		return nil, nil
+	}
+
+	lease, err := e.client.Grant(ctx, int64(math.Ceil(ttl.Seconds())))
+	if err != nil {
+		return nil, err
+	}
+
+	return []clientv3.OpOption{clientv3.WithLease(lease.ID)}, nil
+}
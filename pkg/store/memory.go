@@ -0,0 +1,134 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+type memoryStore struct {
+	mutex   sync.Mutex
+	entries map[string]memoryEntry
+}
+
+// NewMemory returns a Store keeping everything in memory, for single instance deployments.
+func NewMemory() Store {
+	s := &memoryStore{
+		entries: map[string]memoryEntry{},
+	}
+	go s.purgeLoop()
+	return s
+}
+
+var _ Store = &memoryStore{}
+
+func (s *memoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.get(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return e.value, nil
+}
+
+func (s *memoryStore) Put(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.put(key, value, ttl)
+	return nil
+}
+
+func (s *memoryStore) Create(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.get(key); ok {
+		return ErrExists
+	}
+
+	s.put(key, value, ttl)
+	return nil
+}
+
+func (s *memoryStore) Swap(ctx context.Context, key string, old, value []byte, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if e, ok := s.get(key); !ok || !bytes.Equal(e.value, old) {
+		return ErrConflict
+	}
+
+	s.put(key, value, ttl)
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *memoryStore) List(ctx context.Context, prefix string) (map[string][]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	values := map[string][]byte{}
+
+	for key, e := range s.entries {
+		if strings.HasPrefix(key, prefix) && !e.expired(now) {
+			values[key] = e.value
+		}
+	}
+
+	return values, nil
+}
+
+func (s *memoryStore) get(key string) (e memoryEntry, ok bool) {
+	e, ok = s.entries[key]
+	if ok && e.expired(time.Now()) {
+		delete(s.entries, key)
+		return memoryEntry{}, false
+	}
+	return
+}
+
+func (s *memoryStore) put(key string, value []byte, ttl time.Duration) {
+	e := memoryEntry{value: value}
+	if ttl != 0 {
+		e.expiresAt = time.Now().Add(ttl)
+	}
+	s.entries[key] = e
+}
+
+func (s *memoryStore) purgeLoop() {
+	for range time.Tick(time.Minute) {
+		s.mutex.Lock()
+
+		now := time.Now()
+		for key, e := range s.entries {
+			if e.expired(now) {
+				delete(s.entries, key)
+			}
+		}
+
+		s.mutex.Unlock()
+	}
+}
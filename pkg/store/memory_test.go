@@ -0,0 +1,112 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryCreate(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+
+	if err := s.Create(ctx, "a", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Create(ctx, "a", []byte("2"), 0); err != ErrExists {
+		t.Fatalf("creating an existing key should fail with ErrExists, got %v", err)
+	}
+
+	value, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "1" {
+		t.Errorf("a = %q, want the first value", value)
+	}
+}
+
+func TestMemorySwap(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+
+	if err := s.Swap(ctx, "a", []byte("1"), []byte("2"), 0); err != ErrConflict {
+		t.Fatalf("swapping a missing key should fail with ErrConflict, got %v", err)
+	}
+
+	if err := s.Put(ctx, "a", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Swap(ctx, "a", []byte("1"), []byte("2"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the value changed since it was read
+	if err := s.Swap(ctx, "a", []byte("1"), []byte("3"), 0); err != ErrConflict {
+		t.Fatalf("swapping a changed value should fail with ErrConflict, got %v", err)
+	}
+
+	value, err := s.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "2" {
+		t.Errorf("a = %q, want the swapped value", value)
+	}
+}
+
+func TestMemoryTTL(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "short", []byte("1"), 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, "long", []byte("2"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, "forever", []byte("3"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := s.Get(ctx, "short"); err != ErrNotFound {
+		t.Errorf("expired key should be ErrNotFound, got %v", err)
+	}
+
+	// an expired key can be created again
+	if err := s.Create(ctx, "short", []byte("4"), 0); err != nil {
+		t.Errorf("creating an expired key failed: %v", err)
+	}
+
+	values, err := s.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || string(values["long"]) != "2" || string(values["forever"]) != "3" {
+		t.Errorf("unexpected values: %q", values)
+	}
+}
+
+func TestMemoryDelete(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "a", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "a"); err != nil {
+		t.Errorf("deleting a missing key should not fail, got %v", err)
+	}
+
+	if _, err := s.Get(ctx, "a"); err != ErrNotFound {
+		t.Errorf("deleted key should be ErrNotFound, got %v", err)
+	}
+}
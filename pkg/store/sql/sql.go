@@ -0,0 +1,168 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/isi-nc/autentigo/pkg/store"
+
+	_ "github.com/lib/pq"
+)
+
+type sqlStore struct {
+	db    *sql.DB
+	table string
+}
+
+// New Store with SQL backend, in the given table (created if needed).
+func New(driver, dsn, table string) store.Store {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		panic(err)
+	}
+
+	// try to connect
+	if err := db.Ping(); err != nil {
+		panic(err)
+	}
+
+	if err := CreateTableIfNotExists(db, table); err != nil {
+		panic(err)
+	}
+
+	s := &sqlStore{
+		db:    db,
+		table: table,
+	}
+
+	go s.purgeLoop()
+
+	return s
+}
+
+var _ store.Store = &sqlStore{}
+
+// CreateTableIfNotExists creates the table holding the store's values.
+func CreateTableIfNotExists(db *sql.DB, table string) (err error) {
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s("+
+		"key VARCHAR PRIMARY KEY NOT NULL,"+
+		"value BYTEA NOT NULL,"+
+		"expires_at TIMESTAMP WITH TIME ZONE"+
+		");", table)
+
+	_, err = db.Exec(query)
+
+	return
+}
+
+func (s *sqlStore) Get(ctx context.Context, key string) (value []byte, err error) {
+	query := fmt.Sprintf("SELECT value FROM %s WHERE key=$1 AND (expires_at IS NULL OR expires_at > $2)", s.table)
+
+	err = s.db.QueryRowContext(ctx, query, key, time.Now()).Scan(&value)
+	if err == sql.ErrNoRows {
+		err = store.ErrNotFound
+	}
+
+	return
+}
+
+func (s *sqlStore) Put(ctx context.Context, key string, value []byte, ttl time.Duration) (err error) {
+	query := fmt.Sprintf("INSERT INTO %s(key, value, expires_at) VALUES($1,$2,$3) "+
+		"ON CONFLICT (key) DO UPDATE SET value=$2, expires_at=$3", s.table)
+
+	_, err = s.db.ExecContext(ctx, query, key, value, expiresAt(ttl))
+
+	return
+}
+
+func (s *sqlStore) Create(ctx context.Context, key string, value []byte, ttl time.Duration) (err error) {
+	// an expired value can be replaced
+	query := fmt.Sprintf("INSERT INTO %[1]s(key, value, expires_at) VALUES($1,$2,$3) "+
+		"ON CONFLICT (key) DO UPDATE SET value=$2, expires_at=$3 WHERE %[1]s.expires_at <= $4", s.table)
+
+	res, err := s.db.ExecContext(ctx, query, key, value, expiresAt(ttl), time.Now())
+
+	return checkAffected(res, err, store.ErrExists)
+}
+
+func (s *sqlStore) Swap(ctx context.Context, key string, old, value []byte, ttl time.Duration) (err error) {
+	query := fmt.Sprintf("UPDATE %s SET value=$3, expires_at=$4 "+
+		"WHERE key=$1 AND value=$2 AND (expires_at IS NULL OR expires_at > $5)", s.table)
+
+	res, err := s.db.ExecContext(ctx, query, key, old, value, expiresAt(ttl), time.Now())
+
+	return checkAffected(res, err, store.ErrConflict)
+}
+
+func (s *sqlStore) Delete(ctx context.Context, key string) (err error) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE key=$1", s.table)
+
+	_, err = s.db.ExecContext(ctx, query, key)
+
+	return
+}
+
+func (s *sqlStore) List(ctx context.Context, prefix string) (values map[string][]byte, err error) {
+	query := fmt.Sprintf("SELECT key, value FROM %s WHERE key LIKE $1 ESCAPE '\\' AND (expires_at IS NULL OR expires_at > $2)", s.table)
+
+	rows, err := s.db.QueryContext(ctx, query, likeEscaper.Replace(prefix)+"%", time.Now())
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	values = map[string][]byte{}
+	for rows.Next() {
+		var (
+			key   string
+			value []byte
+		)
+		if err = rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+
+	err = rows.Err()
+	return
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func expiresAt(ttl time.Duration) interface{} {
+	if ttl == 0 {
+		return nil
+	}
+	return time.Now().Add(ttl)
+}
+
+func checkAffected(res sql.Result, err error, noRowErr error) error {
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return noRowErr
+	}
+
+	return nil
+}
+
+func (s *sqlStore) purgeLoop() {
+	query := fmt.Sprintf("DELETE FROM %s WHERE expires_at <= $1", s.table)
+
+	for range time.Tick(10 * time.Minute) {
+		if _, err := s.db.Exec(query, time.Now()); err != nil {
+			log.Print("failed to purge expired values: ", err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound indicates a missing (or expired) key.
+	ErrNotFound = errors.New("key not found")
+	// ErrExists indicates a key that already exists.
+	ErrExists = errors.New("key already exists")
+	// ErrConflict indicates a value that changed since it was read.
+	ErrConflict = errors.New("value changed")
+)
+
+// Store is a key-value store with expiration, holding the server's state
+// (refresh tokens, revocations...). A zero ttl means no expiration.
+type Store interface {
+	// Get returns the value of the key, or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Put sets the value of the key.
+	Put(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Create sets the value of the key, only if it doesn't exist (ErrExists otherwise).
+	Create(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Swap replaces the value of the key, only if it's still old (ErrConflict otherwise).
+	Swap(ctx context.Context, key string, old, value []byte, ttl time.Duration) error
+	// Delete removes the key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns the values of the keys having the given prefix.
+	List(ctx context.Context, prefix string) (map[string][]byte, error)
+}
@@ -0,0 +1,100 @@
+// Package totp implements time-based one-time passwords (RFC 6238), with the
+// parameters authenticator apps expect: HMAC-SHA1, 6 digits, 30s steps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits of the codes.
+	Digits = 6
+	// Period is the time step.
+	Period = 30 * time.Second
+	// Skew is the number of steps accepted before and after the current one, for clock drifts.
+	Skew = 1
+
+	secretSize = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret, base32 encoded.
+func GenerateSecret() (string, error) {
+	ba := make([]byte, secretSize)
+	if _, err := rand.Read(ba); err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(ba), nil
+}
+
+// URI returns the otpauth:// URI of the secret, to give to authenticator apps (usually as a QR code).
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Code returns the code of the secret at the given time.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decode(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return code(key, uint64(t.Unix()/int64(Period.Seconds()))), nil
+}
+
+// Validate tells if the code is valid at the given time, accepting Skew steps
+// of clock drift. The comparison is constant time.
+func Validate(secret, passcode string, t time.Time) bool {
+	key, err := decode(secret)
+	if err != nil || len(passcode) != Digits {
+		return false
+	}
+
+	step := t.Unix() / int64(Period.Seconds())
+
+	valid := 0
+	for i := -Skew; i <= Skew; i++ {
+		valid |= subtle.ConstantTimeCompare([]byte(code(key, uint64(step+int64(i)))), []byte(passcode))
+	}
+
+	return valid == 1
+}
+
+func decode(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	return encoding.DecodeString(strings.TrimRight(secret, "="))
+}
+
+// code is the HOTP value (RFC 4226) of the counter.
+func code(key []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%1000000)
+}
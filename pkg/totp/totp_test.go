@@ -0,0 +1,59 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 SHA1 key ("12345678901234567890")
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// last 6 digits of the RFC 6238 test vectors
+	for unix, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := Code(rfcSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Errorf("at %d: expected %s, got %s", unix, expected, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1600000000, 0)
+	code, _ := Code(secret, now)
+
+	if !Validate(secret, code, now) {
+		t.Error("current code should be valid")
+	}
+	if !Validate(strings.ToLower(secret), code, now.Add(Period)) {
+		t.Error("previous code should be valid (skew, case insensitive secret)")
+	}
+	if Validate(secret, code, now.Add(3*Period)) {
+		t.Error("old code should be invalid")
+	}
+	if Validate(secret, "", now) || Validate("not base32!", code, now) {
+		t.Error("invalid inputs should be rejected")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("ACME Corp", "bob", "ABC")
+	expected := "otpauth://totp/ACME%20Corp:bob?algorithm=SHA1&digits=6&issuer=ACME+Corp&period=30&secret=ABC"
+	if uri != expected {
+		t.Errorf("expected %s, got %s", expected, uri)
+	}
+}
@@ -0,0 +1,199 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxDepth limits the nesting of decoded CBOR items.
+const maxDepth = 16
+
+var errTruncated = errors.New("cbor: truncated data")
+
+// decodeCBOR decodes the first CBOR item (RFC 8949) of data, and returns the
+// bytes following it. It only supports what authenticators send: definite
+// lengths, integers (as int64), byte and text strings, arrays, maps keyed by
+// integers or strings, simple values and floats. Tags are skipped.
+func decodeCBOR(data []byte) (v interface{}, rest []byte, err error) {
+	return decodeItem(data, 0)
+}
+
+func decodeItem(data []byte, depth int) (v interface{}, rest []byte, err error) {
+	if depth > maxDepth {
+		return nil, nil, errors.New("cbor: too deeply nested")
+	}
+
+	if len(data) == 0 {
+		return nil, nil, errTruncated
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+
+	if major == 7 {
+		return decodeSimple(data, info)
+	}
+
+	n, data, err := decodeArgument(data[1:], info)
+	if err != nil {
+		return
+	}
+
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflow")
+		}
+		return int64(n), data, nil
+
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflow")
+		}
+		return -1 - int64(n), data, nil
+
+	case 2, 3:
+		if uint64(len(data)) < n {
+			return nil, nil, errTruncated
+		}
+		if major == 2 {
+			return append([]byte(nil), data[:n]...), data[n:], nil
+		}
+		return string(data[:n]), data[n:], nil
+
+	case 4:
+		if n > uint64(len(data)) {
+			// each item is at least one byte
+			return nil, nil, errTruncated
+		}
+
+		items := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			var item interface{}
+			if item, data, err = decodeItem(data, depth+1); err != nil {
+				return
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+
+	case 5:
+		if n > uint64(len(data))/2 {
+			return nil, nil, errTruncated
+		}
+
+		m := make(map[interface{}]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			var key, value interface{}
+			if key, data, err = decodeItem(data, depth+1); err != nil {
+				return
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("cbor: unsupported map key type %T", key)
+			}
+
+			if _, dup := m[key]; dup {
+				return nil, nil, fmt.Errorf("cbor: duplicate map key %v", key)
+			}
+
+			if value, data, err = decodeItem(data, depth+1); err != nil {
+				return
+			}
+			m[key] = value
+		}
+		return m, data, nil
+
+	default: // 6: tag, the tagged item is returned as is
+		return decodeItem(data, depth+1)
+	}
+}
+
+// decodeArgument decodes the argument of an item (its value or length).
+func decodeArgument(data []byte, info byte) (n uint64, rest []byte, err error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		if len(data) < 1 {
+			return 0, nil, errTruncated
+		}
+		return uint64(data[0]), data[1:], nil
+	case info == 25:
+		if len(data) < 2 {
+			return 0, nil, errTruncated
+		}
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26:
+		if len(data) < 4 {
+			return 0, nil, errTruncated
+		}
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27:
+		if len(data) < 8 {
+			return 0, nil, errTruncated
+		}
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	case info == 31:
+		return 0, nil, errors.New("cbor: indefinite lengths are not supported")
+	default:
+		return 0, nil, fmt.Errorf("cbor: invalid additional information %d", info)
+	}
+}
+
+// decodeSimple decodes major type 7: booleans, null, undefined and floats.
+func decodeSimple(data []byte, info byte) (v interface{}, rest []byte, err error) {
+	switch info {
+	case 20:
+		return false, data[1:], nil
+	case 21:
+		return true, data[1:], nil
+	case 22, 23:
+		return nil, data[1:], nil
+	}
+
+	n, rest, err := decodeArgument(data[1:], info)
+	if err != nil {
+		return
+	}
+
+	switch info {
+	case 25:
+		return halfToFloat(uint16(n)), rest, nil
+	case 26:
+		return float64(math.Float32frombits(uint32(n))), rest, nil
+	case 27:
+		return math.Float64frombits(n), rest, nil
+	default:
+		return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+	}
+}
+
+// halfToFloat converts an IEEE 754 half-precision float.
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
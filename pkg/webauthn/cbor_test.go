@@ -0,0 +1,81 @@
+package webauthn
+
+import (
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// RFC 8949 appendix A
+	for input, expected := range map[string]interface{}{
+		"00":                 int64(0),
+		"17":                 int64(23),
+		"1818":               int64(24),
+		"1903e8":             int64(1000),
+		"1b000000e8d4a51000": int64(1000000000000),
+		"20":                 int64(-1),
+		"3903e7":             int64(-1000),
+		"f4":                 false,
+		"f5":                 true,
+		"f6":                 nil,
+		"f93c00":             float64(1),
+		"f9c400":             float64(-4),
+		"fa47c35000":         float64(100000),
+		"fb3ff199999999999a": 1.1,
+		"4401020304":         []byte{1, 2, 3, 4},
+		"6449455446":         "IETF",
+		"83010203":           []interface{}{int64(1), int64(2), int64(3)},
+		"8301820203820405":   []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}},
+		"a201020304":         map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)},
+		"a26161016162820203": map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}},
+		"c11a514b67b0":       int64(1363896240), // tag 1 (epoch date), skipped
+	} {
+		data, _ := hex.DecodeString(input)
+
+		v, rest, err := decodeCBOR(data)
+		if err != nil {
+			t.Errorf("%s: %v", input, err)
+			continue
+		}
+		if len(rest) != 0 {
+			t.Errorf("%s: %d bytes left", input, len(rest))
+		}
+		if !reflect.DeepEqual(v, expected) {
+			t.Errorf("%s: expected %#v, got %#v", input, expected, v)
+		}
+	}
+
+	if v, _, _ := decodeCBOR([]byte{0xf9, 0x7c, 0x00}); v != math.Inf(1) {
+		t.Errorf("expected +Inf, got %v", v)
+	}
+}
+
+func TestDecodeCBORRest(t *testing.T) {
+	v, rest, err := decodeCBOR([]byte{0x01, 0x02, 0x03})
+	if err != nil || v != int64(1) || len(rest) != 2 {
+		t.Errorf("unexpected result: %v %v %v", v, rest, err)
+	}
+}
+
+func TestDecodeCBORInvalid(t *testing.T) {
+	for _, input := range []string{
+		"",                   // empty
+		"18",                 // truncated argument
+		"4401",               // truncated byte string
+		"8301",               // truncated array
+		"5f42010243030405ff", // indefinite length
+		"a20102",             // truncated map
+		"a2010201",           // duplicate key
+		"a1400102",           // byte string key
+		"1bffffffffffffffff", // overflow
+		"f8ff",               // unsupported simple value
+		"818181818181818181818181818181818181818100", // too deep
+	} {
+		data, _ := hex.DecodeString(input)
+		if _, _, err := decodeCBOR(data); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithms (RFC 9053) of the supported credential keys.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// COSE key parameters
+const (
+	coseKty = 1
+	coseAlg = 3
+
+	// EC2 and OKP
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+
+	// RSA
+	coseN = -1
+	coseE = -2
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// publicKey is a parsed COSE public key.
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey parses a COSE_Key (RFC 9052) of a supported algorithm.
+func parsePublicKey(cose []byte) (*publicKey, error) {
+	v, rest, err := decodeCBOR(cose)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("cose: trailing data after the key")
+	}
+
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("cose: the key is not a map")
+	}
+
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+
+	switch {
+	case alg == AlgES256 && kty == ktyEC2:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("cose: invalid P-256 key")
+		}
+
+		// checks the point is on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("cose: invalid P-256 key: %w", err)
+		}
+
+		return &publicKey{alg, &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}}, nil
+
+	case alg == AlgEdDSA && kty == ktyOKP:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("cose: invalid Ed25519 key")
+		}
+		return &publicKey{alg, ed25519.PublicKey(x)}, nil
+
+	case alg == AlgRS256 && kty == ktyRSA:
+		n, _ := m[int64(coseN)].([]byte)
+		e, _ := m[int64(coseE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("cose: invalid RSA key (at least 2048 bits are required)")
+		}
+
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+
+		return &publicKey{alg, &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: exponent,
+		}}, nil
+
+	default:
+		return nil, fmt.Errorf("cose: unsupported key (kty %d, alg %d)", kty, alg)
+	}
+}
+
+// verify checks the signature of the data.
+func (k *publicKey) verify(data, sig []byte) error {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(key, digest[:], sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, data, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+}
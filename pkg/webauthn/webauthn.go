@@ -0,0 +1,525 @@
+// Package webauthn implements the relying party side of WebAuthn (passkeys):
+// the options given to the browser's navigator.credentials calls, and the
+// verification of their results.
+//
+// Attestation statements are not verified (attestation "none" is requested):
+// credentials are trusted because they are registered by an authenticated
+// user, not because of their authenticator's model. Supported keys are ES256,
+// EdDSA and RS256.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalid indicates a registration or assertion that failed verification.
+	ErrInvalid = errors.New("invalid webauthn response")
+	// ErrUnknownCredential indicates an assertion of a credential not registered for the user.
+	// It is an ErrInvalid (see errors.Is).
+	ErrUnknownCredential = fmt.Errorf("%w: unknown credential", ErrInvalid)
+)
+
+// User verification requirements
+const (
+	VerificationRequired    = "required"
+	VerificationPreferred   = "preferred"
+	VerificationDiscouraged = "discouraged"
+)
+
+// authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// Config is the relying party configuration.
+type Config struct {
+	// RPID is the relying party ID, a domain (like example.com). Credentials are bound to it.
+	RPID string
+	// RPName is the name shown by authenticators (default: RPID).
+	RPName string
+	// Origins are the allowed origins of the pages calling the WebAuthn API (default: https://RPID).
+	Origins []string
+	// UserVerification is required, preferred (default) or discouraged.
+	// Only required makes the verification mandatory.
+	UserVerification string
+	// Timeout is the time given to users to complete a ceremony (default: 5 minutes).
+	Timeout time.Duration
+}
+
+// Credential is a registered public key credential.
+type Credential struct {
+	ID []byte `json:"id" bson:"id"`
+	// PublicKey is the credential's COSE key.
+	PublicKey []byte    `json:"public_key" bson:"public_key"`
+	Name      string    `json:"name,omitempty" bson:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// CredentialID returns the credential's ID, as used in URLs (base64url).
+func (c Credential) CredentialID() string {
+	return base64.RawURLEncoding.EncodeToString(c.ID)
+}
+
+// CreationOptions are the options of navigator.credentials.create, in their JSON
+// form (see PublicKeyCredential.parseCreationOptionsFromJSON): binary values are base64url.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingParty           `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout,omitempty"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the options of navigator.credentials.get, in their JSON
+// form (see PublicKeyCredential.parseRequestOptionsFromJSON).
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout,omitempty"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RelyingParty describes the relying party to authenticators.
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserEntity describes the user to authenticators.
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialParameter is an accepted credential type.
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// CredentialDescriptor identifies a credential.
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// AuthenticatorSelection are the requirements on authenticators.
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// RegistrationResponse is the result of navigator.credentials.create, in its JSON form (see PublicKeyCredential.toJSON).
+type RegistrationResponse struct {
+	ID       string                  `json:"id"`
+	RawID    string                  `json:"rawId"`
+	Type     string                  `json:"type"`
+	Response AttestationResponseData `json:"response"`
+}
+
+// AttestationResponseData is the authenticator's response to a registration.
+type AttestationResponseData struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+}
+
+// AssertionResponse is the result of navigator.credentials.get, in its JSON form (see PublicKeyCredential.toJSON).
+type AssertionResponse struct {
+	ID       string                `json:"id"`
+	RawID    string                `json:"rawId"`
+	Type     string                `json:"type"`
+	Response AssertionResponseData `json:"response"`
+}
+
+// AssertionResponseData is the authenticator's response to an authentication.
+type AssertionResponseData struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
+
+// Assertion is a verified assertion.
+type Assertion struct {
+	Credential *Credential
+	// SignCount is the authenticator's signature counter (0 if it doesn't have one).
+	SignCount uint32
+	// UserVerified tells if the authenticator verified the user (PIN, biometrics...).
+	UserVerified bool
+}
+
+// clientData is the data the browser signs with the authenticator (CollectedClientData).
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin,omitempty"`
+}
+
+// authenticatorData is the parsed authenticator data.
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+
+	// attested credential data, in registrations
+	credentialID []byte
+	publicKey    []byte
+}
+
+// NewChallenge returns a new random challenge, base64url encoded.
+func NewChallenge() (string, error) {
+	ba := make([]byte, 32)
+	if _, err := rand.Read(ba); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(ba), nil
+}
+
+// Challenge returns the challenge of the base64url client data of a
+// response, to find the ceremony it answers. It is not verified.
+func Challenge(clientDataJSON string) (string, error) {
+	cd, _, err := parseClientData(clientDataJSON)
+	if err != nil {
+		return "", err
+	}
+	return cd.Challenge, nil
+}
+
+// UserHandle returns the user of an assertion, as given by the authenticator
+// (discoverable credentials only). It is not verified.
+func (r *AssertionResponse) UserHandle() (string, error) {
+	ba, err := decodeBase64(r.Response.UserHandle)
+	return string(ba), err
+}
+
+// CreationOptions returns the options to register a credential for the user.
+// The user's name is used as user handle. Existing credentials are excluded,
+// so an authenticator can't be registered twice.
+func (c *Config) CreationOptions(challenge, user, displayName string, existing []Credential) *CreationOptions {
+	if displayName == "" {
+		displayName = user
+	}
+
+	rpName := c.RPName
+	if rpName == "" {
+		rpName = c.RPID
+	}
+
+	return &CreationOptions{
+		Challenge: challenge,
+		RP:        RelyingParty{ID: c.RPID, Name: rpName},
+		User: UserEntity{
+			ID:          base64.RawURLEncoding.EncodeToString([]byte(user)),
+			Name:        user,
+			DisplayName: displayName,
+		},
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgEdDSA},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		Timeout:            c.timeout().Milliseconds(),
+		ExcludeCredentials: descriptors(existing),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: c.userVerification(),
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions returns the options to authenticate with one of the credentials.
+// Without credentials, the authenticator offers its discoverable credentials.
+func (c *Config) RequestOptions(challenge string, allowed []Credential) *RequestOptions {
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          c.timeout().Milliseconds(),
+		RPID:             c.RPID,
+		AllowCredentials: descriptors(allowed),
+		UserVerification: c.userVerification(),
+	}
+}
+
+// ChallengeTTL returns how long challenges should be kept: the time given to complete a ceremony.
+func (c *Config) ChallengeTTL() time.Duration {
+	return c.timeout()
+}
+
+func (c *Config) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return 5 * time.Minute
+	}
+	return c.Timeout
+}
+
+func (c *Config) userVerification() string {
+	if c.UserVerification == "" {
+		return VerificationPreferred
+	}
+	return c.UserVerification
+}
+
+// VerifyRegistration verifies the response to the registration challenge, and
+// returns the new credential (without name).
+func (c *Config) VerifyRegistration(challenge string, r *RegistrationResponse) (*Credential, error) {
+	if r.Type != "public-key" {
+		return nil, fmt.Errorf("%w: unexpected credential type %q", ErrInvalid, r.Type)
+	}
+
+	if _, _, err := c.verifyClientData(r.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	attestationObject, err := decodeBase64(r.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid attestation object: %v", ErrInvalid, err)
+	}
+
+	v, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid attestation object: %v", ErrInvalid, err)
+	}
+
+	m, _ := v.(map[interface{}]interface{})
+	rawAuthData, _ := m["authData"].([]byte)
+	if rawAuthData == nil {
+		return nil, fmt.Errorf("%w: no authenticator data in the attestation object", ErrInvalid)
+	}
+
+	authData, err := c.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	if authData.credentialID == nil {
+		return nil, fmt.Errorf("%w: no attested credential", ErrInvalid)
+	}
+
+	if rawID, err := decodeBase64(r.RawID); err != nil || !bytes.Equal(rawID, authData.credentialID) {
+		return nil, fmt.Errorf("%w: the credential ID doesn't match the attested one", ErrInvalid)
+	}
+
+	if _, err := parsePublicKey(authData.publicKey); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	return &Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}, nil
+}
+
+// VerifyAssertion verifies the response to the authentication challenge,
+// signed by one of the user's credentials. Callers should check the signature
+// counter is greater than the previous one, when not zero, to detect cloned
+// authenticators.
+func (c *Config) VerifyAssertion(challenge string, r *AssertionResponse, credentials []Credential) (*Assertion, error) {
+	if r.Type != "public-key" {
+		return nil, fmt.Errorf("%w: unexpected credential type %q", ErrInvalid, r.Type)
+	}
+
+	rawID, err := decodeBase64(r.RawID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid credential ID: %v", ErrInvalid, err)
+	}
+
+	var credential *Credential
+	for i := range credentials {
+		if bytes.Equal(credentials[i].ID, rawID) {
+			credential = &credentials[i]
+			break
+		}
+	}
+
+	if credential == nil {
+		return nil, ErrUnknownCredential
+	}
+
+	_, rawClientData, err := c.verifyClientData(r.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	rawAuthData, err := decodeBase64(r.Response.AuthenticatorData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid authenticator data: %v", ErrInvalid, err)
+	}
+
+	authData, err := c.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := decodeBase64(r.Response.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid signature: %v", ErrInvalid, err)
+	}
+
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(rawClientData)
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+
+	if err := key.verify(signed, sig); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	return &Assertion{
+		Credential:   credential,
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&flagUserVerified != 0,
+	}, nil
+}
+
+// verifyClientData checks the type, challenge and origin of the client data.
+func (c *Config) verifyClientData(clientDataJSON, ceremony, challenge string) (cd *clientData, raw []byte, err error) {
+	cd, raw, err = parseClientData(clientDataJSON)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	if cd.Type != ceremony {
+		return nil, nil, fmt.Errorf("%w: unexpected client data type %q", ErrInvalid, cd.Type)
+	}
+
+	if challenge == "" || subtle.ConstantTimeCompare([]byte(strings.TrimRight(cd.Challenge, "=")), []byte(challenge)) != 1 {
+		return nil, nil, fmt.Errorf("%w: challenge mismatch", ErrInvalid)
+	}
+
+	if !c.originAllowed(cd.Origin) {
+		return nil, nil, fmt.Errorf("%w: origin %q not allowed", ErrInvalid, cd.Origin)
+	}
+
+	if cd.CrossOrigin {
+		return nil, nil, fmt.Errorf("%w: cross-origin ceremonies are not allowed", ErrInvalid)
+	}
+
+	return
+}
+
+func (c *Config) originAllowed(origin string) bool {
+	if len(c.Origins) == 0 {
+		return origin == "https://"+c.RPID
+	}
+
+	for _, allowed := range c.Origins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyAuthenticatorData checks the relying party and the user's presence
+// (and verification, if required).
+func (c *Config) verifyAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	authData, err := parseAuthenticatorData(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(authData.rpIDHash, rpIDHash[:]) {
+		return nil, fmt.Errorf("%w: relying party ID mismatch", ErrInvalid)
+	}
+
+	if authData.flags&flagUserPresent == 0 {
+		return nil, fmt.Errorf("%w: user not present", ErrInvalid)
+	}
+
+	if c.UserVerification == VerificationRequired && authData.flags&flagUserVerified == 0 {
+		return nil, fmt.Errorf("%w: user not verified", ErrInvalid)
+	}
+
+	return authData, nil
+}
+
+func parseClientData(clientDataJSON string) (cd *clientData, raw []byte, err error) {
+	if raw, err = decodeBase64(clientDataJSON); err != nil {
+		return nil, nil, fmt.Errorf("invalid client data: %v", err)
+	}
+
+	cd = &clientData{}
+	if err = json.Unmarshal(raw, cd); err != nil {
+		return nil, nil, fmt.Errorf("invalid client data: %v", err)
+	}
+
+	return
+}
+
+// parseAuthenticatorData parses the authenticator data: the RP ID hash (32
+// bytes), flags (1), the signature counter (4) and, if attested, the AAGUID
+// (16), the credential ID length (2), the credential ID and its COSE key.
+func parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, errors.New("authenticator data too short")
+	}
+
+	authData := &authenticatorData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+
+	if authData.flags&flagAttested == 0 {
+		return authData, nil
+	}
+
+	data := raw[37:]
+	if len(data) < 18 {
+		return nil, errors.New("attested credential data too short")
+	}
+
+	idLen := int(binary.BigEndian.Uint16(data[16:18]))
+	data = data[18:]
+	if idLen == 0 || idLen > 1023 || len(data) < idLen {
+		return nil, errors.New("invalid credential ID length")
+	}
+
+	authData.credentialID = data[:idLen]
+	data = data[idLen:]
+
+	// the key is followed by the extensions, if any
+	_, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential public key: %v", err)
+	}
+	authData.publicKey = data[:len(data)-len(rest)]
+
+	return authData, nil
+}
+
+func descriptors(credentials []Credential) (d []CredentialDescriptor) {
+	for _, c := range credentials {
+		d = append(d, CredentialDescriptor{Type: "public-key", ID: c.CredentialID()})
+	}
+	return
+}
+
+// decodeBase64 decodes base64url, padded or not.
+func decodeBase64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
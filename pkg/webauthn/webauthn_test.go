@@ -0,0 +1,174 @@
+package webauthn_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/isi-nc/autentigo/pkg/webauthn"
+	"github.com/isi-nc/autentigo/pkg/webauthn/webauthntest"
+)
+
+var config = &webauthn.Config{
+	RPID:    "example.com",
+	Origins: []string{"https://login.example.com"},
+}
+
+// register registers a credential of the authenticator.
+func register(t *testing.T, a *webauthntest.Authenticator, existing []webauthn.Credential) *webauthn.Credential {
+	t.Helper()
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := a.Create(config.CreationOptions(challenge, "bob", "Bob", existing))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cred, err := config.VerifyRegistration(challenge, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cred
+}
+
+// login returns a challenge and the authenticator's assertion.
+func login(t *testing.T, a *webauthntest.Authenticator, allowed []webauthn.Credential) (string, *webauthn.AssertionResponse) {
+	t.Helper()
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := a.Get(config.RequestOptions(challenge, allowed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge, resp
+}
+
+func TestCeremonies(t *testing.T) {
+	a := webauthntest.New("https://login.example.com")
+	cred := register(t, a, nil)
+	creds := []webauthn.Credential{*cred}
+
+	for i := uint32(1); i <= 2; i++ {
+		challenge, resp := login(t, a, creds)
+
+		if c, err := webauthn.Challenge(resp.Response.ClientDataJSON); err != nil || c != challenge {
+			t.Errorf("expected challenge %s, got %s (%v)", challenge, c, err)
+		}
+
+		assertion, err := config.VerifyAssertion(challenge, resp, creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if assertion.SignCount != i || assertion.UserVerified {
+			t.Errorf("unexpected assertion: %+v", assertion)
+		}
+	}
+
+	// discoverable credential
+	challenge, resp := login(t, a, nil)
+	if user, err := resp.UserHandle(); err != nil || user != "bob" {
+		t.Errorf("expected user handle bob, got %q (%v)", user, err)
+	}
+	if _, err := config.VerifyAssertion(challenge, resp, creds); err != nil {
+		t.Error(err)
+	}
+
+	// the authenticator refuses to register twice
+	challenge, _ = webauthn.NewChallenge()
+	if _, err := a.Create(config.CreationOptions(challenge, "bob", "", creds)); err != webauthntest.ErrExcluded {
+		t.Errorf("expected the credential to be excluded, got %v", err)
+	}
+}
+
+func TestAssertionFailures(t *testing.T) {
+	a := webauthntest.New("https://login.example.com")
+	creds := []webauthn.Credential{*register(t, a, nil)}
+
+	other := webauthntest.New("https://login.example.com")
+	otherCreds := []webauthn.Credential{*register(t, other, nil)}
+
+	challenge, resp := login(t, a, creds)
+
+	if _, err := config.VerifyAssertion("other", resp, creds); !errors.Is(err, webauthn.ErrInvalid) {
+		t.Errorf("wrong challenge: expected ErrInvalid, got %v", err)
+	}
+
+	if _, err := config.VerifyAssertion(challenge, resp, otherCreds); err != webauthn.ErrUnknownCredential {
+		t.Errorf("other user's credentials: expected ErrUnknownCredential, got %v", err)
+	}
+
+	// a key of another authenticator under the same ID
+	forged := []webauthn.Credential{{ID: creds[0].ID, PublicKey: otherCreds[0].PublicKey}}
+	if _, err := config.VerifyAssertion(challenge, resp, forged); !errors.Is(err, webauthn.ErrInvalid) {
+		t.Errorf("wrong key: expected ErrInvalid, got %v", err)
+	}
+
+	tampered := *resp
+	tampered.Response.AuthenticatorData = resp.Response.AuthenticatorData[:len(resp.Response.AuthenticatorData)-1] + "A"
+	if _, err := config.VerifyAssertion(challenge, &tampered, creds); !errors.Is(err, webauthn.ErrInvalid) {
+		t.Errorf("tampered data: expected ErrInvalid, got %v", err)
+	}
+
+	a.Origin = "https://evil.net"
+	challenge, resp = login(t, a, creds)
+	if _, err := config.VerifyAssertion(challenge, resp, creds); !errors.Is(err, webauthn.ErrInvalid) {
+		t.Errorf("wrong origin: expected ErrInvalid, got %v", err)
+	}
+	a.Origin = "https://login.example.com"
+
+	uvConfig := *config
+	uvConfig.UserVerification = webauthn.VerificationRequired
+	challenge, resp = login(t, a, creds)
+	if _, err := uvConfig.VerifyAssertion(challenge, resp, creds); !errors.Is(err, webauthn.ErrInvalid) {
+		t.Errorf("user not verified: expected ErrInvalid, got %v", err)
+	}
+
+	a.UserVerified = true
+	challenge, resp = login(t, a, creds)
+	if assertion, err := uvConfig.VerifyAssertion(challenge, resp, creds); err != nil || !assertion.UserVerified {
+		t.Errorf("user verified: unexpected result %+v, %v", assertion, err)
+	}
+
+	otherRP := *config
+	otherRP.RPID = "evil.net"
+	otherRP.Origins = nil
+	challenge, resp = login(t, a, creds)
+	if _, err := otherRP.VerifyAssertion(challenge, resp, creds); !errors.Is(err, webauthn.ErrInvalid) {
+		t.Errorf("other relying party: expected ErrInvalid, got %v", err)
+	}
+}
+
+func TestRegistrationFailures(t *testing.T) {
+	a := webauthntest.New("https://login.example.com")
+
+	challenge, _ := webauthn.NewChallenge()
+	resp, err := a.Create(config.CreationOptions(challenge, "bob", "", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := config.VerifyRegistration("other", resp); !errors.Is(err, webauthn.ErrInvalid) {
+		t.Errorf("wrong challenge: expected ErrInvalid, got %v", err)
+	}
+
+	// an assertion can't be used as registration
+	getChallenge, assertion := login(t, a, nil)
+	swapped := *resp
+	swapped.Response.ClientDataJSON = assertion.Response.ClientDataJSON
+	if _, err := config.VerifyRegistration(getChallenge, &swapped); !errors.Is(err, webauthn.ErrInvalid) {
+		t.Errorf("wrong ceremony: expected ErrInvalid, got %v", err)
+	}
+
+	otherID := *resp
+	otherID.RawID = "AAAA"
+	if _, err := config.VerifyRegistration(challenge, &otherID); !errors.Is(err, webauthn.ErrInvalid) {
+		t.Errorf("wrong credential ID: expected ErrInvalid, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package webauthntest
+
+// cborMap is a CBOR map, encoded in the given order.
+type cborMap []cborEntry
+
+type cborEntry struct {
+	key, value interface{}
+}
+
+// encodeCBOR encodes the few types authenticators need: ints, strings, byte strings and maps.
+func encodeCBOR(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+
+	case cborMap:
+		ba := cborHead(5, uint64(len(v)))
+		for _, e := range v {
+			ba = append(ba, encodeCBOR(e.key)...)
+			ba = append(ba, encodeCBOR(e.value)...)
+		}
+		return ba
+
+	default:
+		panic("cbor: unsupported type")
+	}
+}
+
+func cborHead(major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return []byte{major | byte(n)}
+	case n < 1<<8:
+		return []byte{major | 24, byte(n)}
+	case n < 1<<16:
+		return []byte{major | 25, byte(n >> 8), byte(n)}
+	default:
+		return []byte{major | 26, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+}
@@ -0,0 +1,209 @@
+// Package webauthntest provides a software WebAuthn authenticator, playing the
+// browser and authenticator parts of the ceremonies in tests.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/isi-nc/autentigo/pkg/webauthn"
+)
+
+// ErrNoCredential indicates no credential of the authenticator matches the request.
+var ErrNoCredential = errors.New("no matching credential")
+
+// ErrExcluded indicates a registration excluding one of the authenticator's credentials.
+var ErrExcluded = errors.New("the authenticator already has an excluded credential")
+
+// Authenticator is a software authenticator with ES256 discoverable credentials.
+type Authenticator struct {
+	// Origin is the origin of the page calling the API, put in the client data.
+	Origin string
+	// UserVerified sets the user verified flag (as if a PIN was given).
+	UserVerified bool
+
+	credentials []*credential
+}
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+// New returns an authenticator used from the given origin.
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin}
+}
+
+// Create registers a new credential, like navigator.credentials.create.
+func (a *Authenticator) Create(options *webauthn.CreationOptions) (*webauthn.RegistrationResponse, error) {
+	for _, excluded := range options.ExcludeCredentials {
+		if a.find(options.RP.ID, excluded.ID) != nil {
+			return nil, ErrExcluded
+		}
+	}
+
+	userHandle, err := decode(options.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	c := &credential{
+		id:         id,
+		rpID:       options.RP.ID,
+		userHandle: userHandle,
+		key:        key,
+	}
+	a.credentials = append(a.credentials, c)
+
+	// attested credential data: AAGUID (zero), ID length, ID, COSE key
+	attested := make([]byte, 18)
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(id)))
+	attested = append(attested, id...)
+	attested = append(attested, encodeCBOR(coseKey(&key.PublicKey))...)
+
+	authData := append(a.authData(c, 0x40), attested...)
+
+	attestationObject := encodeCBOR(cborMap{
+		{"fmt", "none"},
+		{"attStmt", cborMap{}},
+		{"authData", authData},
+	})
+
+	return &webauthn.RegistrationResponse{
+		ID:    encode(id),
+		RawID: encode(id),
+		Type:  "public-key",
+		Response: webauthn.AttestationResponseData{
+			ClientDataJSON:    a.clientData("webauthn.create", options.Challenge),
+			AttestationObject: encode(attestationObject),
+		},
+	}, nil
+}
+
+// Get signs the challenge with a credential, like navigator.credentials.get.
+// Without allowed credentials, the first credential of the relying party is used.
+func (a *Authenticator) Get(options *webauthn.RequestOptions) (*webauthn.AssertionResponse, error) {
+	var c *credential
+	if len(options.AllowCredentials) == 0 {
+		c = a.find(options.RPID, "")
+	}
+	for _, allowed := range options.AllowCredentials {
+		if c = a.find(options.RPID, allowed.ID); c != nil {
+			break
+		}
+	}
+
+	if c == nil {
+		return nil, ErrNoCredential
+	}
+
+	c.signCount++
+
+	authData := a.authData(c, 0)
+	clientDataJSON := a.clientData("webauthn.get", options.Challenge)
+
+	rawClientData, _ := decode(clientDataJSON)
+	clientDataHash := sha256.Sum256(rawClientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	sig, err := ecdsa.SignASN1(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &webauthn.AssertionResponse{
+		ID:    encode(c.id),
+		RawID: encode(c.id),
+		Type:  "public-key",
+		Response: webauthn.AssertionResponseData{
+			ClientDataJSON:    clientDataJSON,
+			AuthenticatorData: encode(authData),
+			Signature:         encode(sig),
+			UserHandle:        encode(c.userHandle),
+		},
+	}, nil
+}
+
+// SetSignCount sets the signature counter of all the credentials (to simulate a cloned authenticator).
+func (a *Authenticator) SetSignCount(count uint32) {
+	for _, c := range a.credentials {
+		c.signCount = count
+	}
+}
+
+func (a *Authenticator) find(rpID, id string) *credential {
+	for _, c := range a.credentials {
+		if c.rpID == rpID && (id == "" || encode(c.id) == strings.TrimRight(id, "=")) {
+			return c
+		}
+	}
+	return nil
+}
+
+// authData returns the authenticator data, without attested credential data.
+func (a *Authenticator) authData(c *credential, flags byte) []byte {
+	flags |= 0x01 // user present
+	if a.UserVerified {
+		flags |= 0x04
+	}
+
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+
+	authData := append(rpIDHash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(authData[33:], c.signCount)
+	return authData
+}
+
+func (a *Authenticator) clientData(ceremony, challenge string) string {
+	ba, _ := json.Marshal(map[string]interface{}{
+		"type":        ceremony,
+		"challenge":   challenge,
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	return encode(ba)
+}
+
+func coseKey(key *ecdsa.PublicKey) cborMap {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+
+	return cborMap{
+		{1, 2},  // kty: EC2
+		{3, -7}, // alg: ES256
+		{-1, 1}, // crv: P-256
+		{-2, x},
+		{-3, y},
+	}
+}
+
+func encode(ba []byte) string {
+	return base64.RawURLEncoding.EncodeToString(ba)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}